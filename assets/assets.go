@@ -0,0 +1,13 @@
+// Package assets holds the templates and the static files of the shop. They
+// are read from the disk, unless the shop is built with the embedassets tag:
+//
+//	go build -tags embedassets
+//
+// which embeds them in the binary, to be served with static.embedded set.
+package assets
+
+import "io/fs"
+
+// FS holds the embedded assets, the templates and static directories. It is
+// nil unless the shop is built with the embedassets tag.
+var FS fs.FS
@@ -0,0 +1,12 @@
+//go:build embedassets
+
+package assets
+
+import "embed"
+
+//go:embed templates static
+var embedded embed.FS
+
+func init() {
+	FS = embedded
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"reflect"
 	"strings"
@@ -16,69 +17,250 @@ type Configuration struct {
 
 	// Log supports setting various parameters related to the logging
 	// subsystem.
-	Log struct {
-		// AccessLog configures access logging.
-		AccessLog struct {
-			// Disabled disables access logging.
-			Disabled bool `yaml:"disabled,omitempty"`
-		} `yaml:"accesslog,omitempty"`
-
-		// Level is the granularity at which registry operations are logged.
-		Level Loglevel `yaml:"level,omitempty"`
-
-		// Formatter overrides the default formatter with another. Options
-		// include "text", "json" and "logstash".
-		Formatter string `yaml:"formatter,omitempty"`
-
-		// Fields allows users to specify static string fields to include in
-		// the logger context.
-		Fields map[string]interface{} `yaml:"fields,omitempty"`
-
-		// Hooks allows users to configure the log hooks, to enabling the
-		// sequent handling behavior, when defined levels of log message emit.
-		Hooks []LogHook `yaml:"hooks,omitempty"`
-	}
+	Log Log `yaml:"log"`
 
 	// Reporting is the configuration for error reporting
 	Reporting Reporting `yaml:"reporting,omitempty"`
 
-	Mail Mail `yaml:"mail,omitempty"`
+	// Mail configures the named mail transports. The one named "default" is
+	// used to send the emails of the shop.
+	Mail map[string]Mail `yaml:"mail,omitempty"`
 
-	HTTP struct {
-		// Addr specifies the bind address
-		Addr string `yaml:"addr,omitempty"`
+	// Auth configures the user accounts.
+	Auth Auth `yaml:"auth,omitempty"`
+
+	HTTP HTTP `yaml:"http,omitempty"`
+
+	// Templates configures the HTML pages rendering.
+	Templates Templates `yaml:"templates,omitempty"`
+
+	// Static configures the static files served under /static/.
+	Static Static `yaml:"static,omitempty"`
+
+	// Redis configures the named redis connections. The one named "default"
+	// is used by the shop.
+	Redis map[string]Redis `yaml:"redis,omitempty"`
+
+	// Health provides the configuration section for health checks.
+	Health Health `yaml:"health,omitempty"`
+
+	// MongoDB configures the named MongoDB connections. The one named
+	// "default" is used by the shop.
+	MongoDB map[string]MongoDB `yaml:"mongodb"`
+
+	// Storage configures the driver storing the uploaded files.
+	Storage Storage `yaml:"storage,omitempty"`
+
+	// Payment configures the payment gateways, by driver name.
+	Payment map[string]Parameters `yaml:"payment,omitempty"`
+
+	// RateLimits limits the rate of the requests to the routes, by route
+	// name. The counters are kept in the default redis, so the limits hold
+	// across instances. e.g.
+	//
+	//	ratelimits:
+	//	  auth.login:
+	//	    - key: ip
+	//	      requests: 10
+	//	      period: 1m
+	RateLimits map[string][]RateLimit `yaml:"ratelimits,omitempty"`
+}
+
+// DefaultConnection is the name of the connection, or mail transport, used by
+// the shop when several are configured.
+const DefaultConnection = "default"
+
+// Log supports setting various parameters related to the logging subsystem.
+type Log struct {
+	// AccessLog configures access logging.
+	AccessLog struct {
+		// Disabled disables access logging.
+		Disabled bool `yaml:"disabled,omitempty"`
+	} `yaml:"accesslog,omitempty"`
+
+	// Level is the granularity at which registry operations are logged.
+	Level Loglevel `yaml:"level,omitempty"`
+
+	// Formatter overrides the default formatter with another. Options
+	// include "text", "json" and "logstash".
+	Formatter string `yaml:"formatter,omitempty"`
+
+	// Fields allows users to specify static string fields to include in
+	// the logger context.
+	Fields map[string]interface{} `yaml:"fields,omitempty"`
+
+	// Hooks allows users to configure the log hooks, to enabling the
+	// sequent handling behavior, when defined levels of log message emit.
+	Hooks []LogHook `yaml:"hooks,omitempty"`
+}
 
-		// Net specifies the net portion of the bind address. A default empty value means tcp.
-		Net string `yaml:"net,omitempty"`
+// HTTP configures the http server.
+type HTTP struct {
+	// Addr specifies the bind address
+	Addr string `yaml:"addr,omitempty"`
+
+	// Net specifies the net portion of the bind address. A default empty value means tcp.
+	// "systemd" takes over a socket passed by systemd socket activation, Addr
+	// being its FileDescriptorName (empty for the first one), and "fd" takes
+	// over the inherited file descriptor numbered Addr.
+	Net string `yaml:"net,omitempty"`
+
+	// Host specifies an externally-reachable address for the app, as a fully
+	// qualified URL. The links of the mails are built on it, it is required
+	// when mail is configured.
+	Host string `yaml:"host,omitempty"`
+
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Secret specifies the secret key which HMAC tokens are created with.
+	Secret string `yaml:"secret,omitempty"`
+
+	SessionKey Base64Key `yaml:"session_key"`
+
+	// Session configures the cookie used to track the user's session.
+	Session Session `yaml:"session,omitempty"`
+
+	// CSRF configures the protection against cross-site request forgery.
+	CSRF CSRF `yaml:"csrf,omitempty"`
+
+	// TLS makes the http server terminate TLS itself, with the given
+	// certificate and key. Client certificates can be required, and the
+	// minimum version and cipher suites restricted. The files are reloaded
+	// on SIGHUP or when they change, without dropping the connections.
+	TLS TLS `yaml:"tls,omitempty"`
+
+	// Amount of time to wait for connection to drain before shutting down when registry
+	// receives a stop signal
+	DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
+
+	// TrustedProxies lists the reverse proxies, as IP addresses or CIDR
+	// networks, whose X-Forwarded-For and X-Real-Ip headers are believed
+	// when counting the requests by IP. Without them, the client is the
+	// peer of the connection: behind a proxy, every request would be
+	// counted against the proxy's IP.
+	TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
+	// Headers is a set of headers to include in HTTP responses. A common
+	// use case for this would be security headers such as
+	// Strict-Transport-Security. The map keys are the header names, and
+	// the values are the associated header payloads.
+	Headers http.Header `yaml:"headers,omitempty"`
+}
+
+// TrustedNetworks parses TrustedProxies.
+func (h HTTP) TrustedNetworks() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(h.TrustedProxies))
+	for _, proxy := range h.TrustedProxies {
+		n, err := parseNetwork(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseNetwork parses a network in CIDR notation, or a single IP address.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
 
-		// Host specifies an externally-reachable address for the app, as a fully
-		// qualified URL.
-		Host string `yaml:"host,omitempty"`
+// MongoDB configures a MongoDB connection.
+type MongoDB struct {
+	// URI is the connection string, of the form
+	// mongodb://[user:pass@]host1[:port1][,host2[:port2],...][/database][?options]
+	URI string `yaml:"uri,omitempty"`
 
-		Prefix string `yaml:"prefix,omitempty"`
+	// Name is the database name.
+	Name string `yaml:"name,omitempty"`
+}
 
-		// Secret specifies the secret key which HMAC tokens are created with.
-		Secret string `yaml:"secret,omitempty"`
+// Storage defines the configuration for the storage of the uploaded files. It
+// holds a single driver name mapped to its parameters, e.g.
+//
+//	storage:
+//	  filesystem:
+//	    rootdirectory: /var/lib/thatiq
+type Storage map[string]Parameters
+
+// Type returns the storage driver type, such as filesystem or s3
+func (storage Storage) Type() string {
+	for k := range storage {
+		return k
+	}
+	return ""
+}
 
-		SessionKey Base64Key `yaml:"session_key"`
+// Parameters returns the Parameters map for a Storage configuration
+func (storage Storage) Parameters() Parameters {
+	return storage[storage.Type()]
+}
 
-		// Amount of time to wait for connection to drain before shutting down when registry
-		// receives a stop signal
-		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+// Unmarshals a single item map into a Storage or a string into a Storage type with no parameters
+func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var storageMap map[string]Parameters
+	err := unmarshal(&storageMap)
+	if err == nil {
+		if len(storageMap) > 1 {
+			types := make([]string, 0, len(storageMap))
+			for k := range storageMap {
+				types = append(types, k)
+			}
+			return fmt.Errorf("must provide exactly one storage type. Provided: %v", types)
+		}
+		*storage = storageMap
+		return nil
+	}
 
-		// Headers is a set of headers to include in HTTP responses. A common
-		// use case for this would be security headers such as
-		// Strict-Transport-Security. The map keys are the header names, and
-		// the values are the associated header payloads.
-		Headers http.Header `yaml:"headers,omitempty"`
-	} `yaml:"http,omitempty"`
+	var storageType string
+	if err = unmarshal(&storageType); err == nil {
+		*storage = Storage{storageType: Parameters{}}
+		return nil
+	}
 
-	Redis Redis `yaml:"redis,omitempty"`
+	return err
+}
 
-	MongoDB struct {
-		URI  string `yaml:"uri,omitempty"`
-		Name string `yaml:"name,omitempty"`
-	} `yaml:"mongodb"`
+// RateLimit is a limit of the rate of the requests to a route.
+type RateLimit struct {
+	// Key is what the requests are counted by: "ip", the client IP, "user",
+	// the id of the logged in user, or "token", the session the user
+	// logged in with, so each login of an account has its own limit. The
+	// requests that aren't authenticated are counted by IP. The client IP
+	// is only read from the proxy headers of http.trustedproxies. Defaults
+	// to "ip".
+	Key string `yaml:"key,omitempty"`
+
+	// Algorithm is either "slidingwindow", allowing Requests in any
+	// Period, or "tokenbucket", allowing bursts of Burst requests refilled
+	// at Requests per Period. Defaults to "slidingwindow".
+	Algorithm string `yaml:"algorithm,omitempty"`
+
+	// Requests is the number of requests allowed per Period.
+	Requests int `yaml:"requests"`
+
+	// Period is the duration Requests are allowed in.
+	Period time.Duration `yaml:"period"`
+
+	// Burst is the capacity of the token bucket. Defaults to Requests.
+	Burst int `yaml:"burst,omitempty"`
+
+	// Methods restricts the limit to the requests of these methods, such
+	// as POST. Empty applies it to every request.
+	Methods []string `yaml:"methods,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -97,12 +279,23 @@ type LogHook struct {
 
 	// Mail allows user to configure email parameters.
 	Mail Mail `yaml:"options,omitempty"`
+
+	// Interval is the minimum duration in between two notifications, the log
+	// entries emitted meanwhile are batched together. Defaults to 1 minute.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// BatchSize is the maximum number of log entries in a single
+	// notification, the entries past it are dropped. Defaults to 100.
+	BatchSize int `yaml:"batchsize,omitempty"`
+
+	// Parameters holds options of hook types that need more than the above.
+	Parameters Parameters `yaml:"parameters,omitempty"`
 }
 
 type Mail struct {
 	SMTP struct {
 		// Addr defines smtp host address
-		Addr string `yaml:addr,omitempty`
+		Addr string `yaml:"addr,omitempty"`
 
 		// Username defines user name to smtp host
 		Username string `yaml:"username,omitempty"`
@@ -114,12 +307,176 @@ type Mail struct {
 		Insecure bool `yaml:"insecure,omitempty"`
 	}
 
-	From string `yaml:from,omitempty`
+	From string `yaml:"from,omitempty"`
 
 	// To defines mail receiving address
 	To []string `yaml:"to,omitempty"`
 }
 
+// Auth configures the user accounts.
+type Auth struct {
+	// Verification configures the email address verification.
+	Verification Verification `yaml:"verification,omitempty"`
+
+	// PasswordReset configures the forgotten password flow.
+	PasswordReset PasswordReset `yaml:"passwordreset,omitempty"`
+}
+
+// PasswordReset configures how users reset a forgotten password.
+type PasswordReset struct {
+	// Timeout is how long a password reset link is valid. Defaults to 1 hour.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Verification configures how users verify their email address, and what
+// unverified users aren't allowed to do.
+type Verification struct {
+	// Timeout is how long a verification link is valid. Defaults to 72 hours.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Restrict lists the names of the routes unverified users are denied
+	// access to, e.g. "checkout".
+	Restrict []string `yaml:"restrict,omitempty"`
+}
+
+// TLS configures the TLS termination of the http server. The certificate, key
+// and client CAs are reloaded from disk on SIGHUP or when the files change.
+type TLS struct {
+	// Certificate specifies the path to an x509 certificate file to
+	// be used for TLS.
+	Certificate string `yaml:"certificate,omitempty"`
+
+	// Key specifies the path to the x509 key file, which should
+	// contain the private portion for the file specified in
+	// Certificate.
+	Key string `yaml:"key,omitempty"`
+
+	// Specifies the CA certs for client authentication. Clients must
+	// present a certificate signed by one of them when set.
+	ClientCAs []string `yaml:"clientcas,omitempty"`
+
+	// Specifies the lowest TLS version allowed. Options include "tls1.0",
+	// "tls1.1", "tls1.2" and "tls1.3". Defaults to "tls1.2".
+	MinimumTLS string `yaml:"minimumtls,omitempty"`
+
+	// Specifies a list of cipher suites allowed, by their IANA names such
+	// as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. They don't apply to TLS 1.3.
+	CipherSuites []string `yaml:"ciphersuites,omitempty"`
+}
+
+// Session configures the session cookie and the lifetime of the session data
+// kept on the server.
+type Session struct {
+	// Name is the name of the session cookie. Defaults to "thatiq_session".
+	Name string `yaml:"name,omitempty"`
+
+	// MaxAge is how long a session lives, both in the browser and in the
+	// server side storage. Defaults to 14 days.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+
+	// Path sets the cookie path. Defaults to "/".
+	Path string `yaml:"path,omitempty"`
+
+	// Domain sets the cookie domain. Defaults to the host of the request.
+	Domain string `yaml:"domain,omitempty"`
+
+	// Secure marks the cookie to be sent over HTTPS only.
+	Secure bool `yaml:"secure,omitempty"`
+
+	// SameSite sets the SameSite attribute of the cookie. Options include
+	// "lax", "strict" and "none". An empty value omits the attribute.
+	SameSite string `yaml:"samesite,omitempty"`
+}
+
+// Templates configures the HTML pages rendering.
+type Templates struct {
+	// Directory is the root of the templates tree. Defaults to
+	// "assets/templates".
+	Directory string `yaml:"directory,omitempty"`
+
+	// Reload parses the templates again when they change, for
+	// development. They are parsed once at startup otherwise.
+	Reload bool `yaml:"reload,omitempty"`
+}
+
+// Static configures the static files, such as the stylesheets and the
+// images, served under /static/ below http.prefix.
+type Static struct {
+	// Directory holds the static files. Defaults to "assets/static".
+	Directory string `yaml:"directory,omitempty"`
+
+	// Embedded serves the static files and the templates embedded in the
+	// binary, built with the embedassets tag, rather than those of
+	// Directory and templates.directory.
+	Embedded bool `yaml:"embedded,omitempty"`
+
+	// Reload hashes the static files again when they change, for
+	// development. They are hashed once at startup otherwise.
+	Reload bool `yaml:"reload,omitempty"`
+}
+
+// CSRF configures the protection against cross-site request forgery.
+type CSRF struct {
+	// TrustedOrigins lists the origins, besides http.host, allowed to send
+	// unsafe requests, e.g. "https://admin.example.com".
+	TrustedOrigins []string `yaml:"trustedorigins,omitempty"`
+
+	// Exempt lists the names of the routes that aren't checked, such as
+	// the webhooks.
+	Exempt []string `yaml:"exempt,omitempty"`
+}
+
+// Health provides the configuration section for health checks.
+type Health struct {
+	// Redis configures the check of the redis server, it is enabled by
+	// default.
+	Redis HealthCheck `yaml:"redis,omitempty"`
+
+	// MongoDB configures the check of the MongoDB server, it is enabled by
+	// default.
+	MongoDB HealthCheck `yaml:"mongodb,omitempty"`
+
+	// SMTP configures the check of the SMTP server, it is enabled by default
+	// if mail is configured.
+	SMTP HealthCheck `yaml:"smtp,omitempty"`
+
+	// Disk is a list of disk space checkers.
+	Disk []DiskChecker `yaml:"disk,omitempty"`
+}
+
+// HealthCheck configures a periodic health check.
+type HealthCheck struct {
+	// Disabled disables the check.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Interval is the duration in between checks. Defaults to 10 seconds.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Timeout is the timeout for each check. Defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state. Zero fails on the first error.
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
+// DiskChecker is a type of entry in the health section for checking the free
+// space of a filesystem.
+type DiskChecker struct {
+	// Path is a path on the filesystem to check.
+	Path string `yaml:"path"`
+
+	// MinFree is the minimum number of bytes that must be available.
+	MinFree uint64 `yaml:"minfree"`
+
+	// Interval is the duration in between checks. Defaults to 10 seconds.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state. Zero fails on the first error.
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
 // Redis configuration
 type Redis struct {
 	// Addr specifies the the redis instance available to the application
@@ -143,11 +500,81 @@ type Redis struct {
 	// IdleTimeout sets the amount time to wait before closing
 	// inactive connections.
 	IdleTimeout time.Duration `yaml:"idletimeout,omitempty"`
+
+	// TLS configures TLS to the redis servers, the sentinels included.
+	TLS RedisTLS `yaml:"tls,omitempty"`
+
+	// Sentinel discovers the master through Redis Sentinel, instead of
+	// connecting to Addr.
+	Sentinel RedisSentinel `yaml:"sentinel,omitempty"`
+
+	// Cluster connects to a Redis Cluster, instead of the single server
+	// at Addr.
+	Cluster RedisCluster `yaml:"cluster,omitempty"`
+}
+
+// RedisTLS configures TLS to the redis servers.
+type RedisTLS struct {
+	// Enabled connects to the servers over TLS.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Certificate and Key are the paths to the client certificate and its
+	// private key, if the servers authenticate the clients.
+	Certificate string `yaml:"certificate,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+
+	// RootCAs are the paths to the CA certificates the server certificates
+	// are verified with. Defaults to the system pool.
+	RootCAs []string `yaml:"rootcas,omitempty"`
+
+	// ServerName overrides the name the server certificates are verified
+	// against. Defaults to the host of the server address.
+	ServerName string `yaml:"servername,omitempty"`
+
+	// Insecure skips the verification of the server certificates.
+	Insecure bool `yaml:"insecure,omitempty"`
+}
+
+// RedisSentinel configures the discovery of the master through Redis
+// Sentinel. The connections are dialed to the current master, and dropped
+// once it is demoted by a failover.
+type RedisSentinel struct {
+	// MasterName is the name of the monitored master, enabling Sentinel.
+	MasterName string `yaml:"mastername,omitempty"`
+
+	// Addrs are the addresses of the sentinels, host:port.
+	Addrs []string `yaml:"addrs,omitempty"`
+
+	// Password authenticates to the sentinels, Redis.Password to the master.
+	Password string `yaml:"password,omitempty"`
+}
+
+// RedisCluster configures the connection to a Redis Cluster. The commands
+// are routed to the master owning the slot of their key, following the
+// MOVED and ASK redirections.
+type RedisCluster struct {
+	// Addrs are the addresses of some of the nodes of the cluster,
+	// host:port, enabling Cluster. The others are discovered.
+	Addrs []string `yaml:"addrs,omitempty"`
+}
+
+// v0_1Configuration is a Version 0.1 Configuration struct. It has a single
+// mongodb, redis and mail connection.
+type v0_1Configuration struct {
+	Version   Version   `yaml:"version"`
+	Log       Log       `yaml:"log"`
+	Reporting Reporting `yaml:"reporting,omitempty"`
+	Mail      Mail      `yaml:"mail,omitempty"`
+	Auth      Auth      `yaml:"auth,omitempty"`
+	HTTP      HTTP      `yaml:"http,omitempty"`
+	Redis     Redis     `yaml:"redis,omitempty"`
+	Health    Health    `yaml:"health,omitempty"`
+	MongoDB   MongoDB   `yaml:"mongodb"`
 }
 
-// v0_1Configuration is a Version 0.1 Configuration struct
+// v0_2Configuration is a Version 0.2 Configuration struct
 // This is currently aliased to Configuration, as it is the current version
-type v0_1Configuration Configuration
+type v0_2Configuration Configuration
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface
 // Unmarshals a string of the form X.Y into a Version, validating that X and Y can represent unsigned integers
@@ -172,7 +599,7 @@ func (version *Version) UnmarshalYAML(unmarshal func(interface{}) error) error {
 }
 
 // CurrentVersion is the most recent Version that can be parsed
-var CurrentVersion = MajorMinorVersion(0, 1)
+var CurrentVersion = MajorMinorVersion(0, 2)
 
 type Loglevel string
 
@@ -205,6 +632,10 @@ func (b64Key *Base64Key) UnmarshalYAML(unmarshal func(interface{}) error) error
 	if err != nil {
 		return err
 	}
+	if b64KeyString == "" {
+		*b64Key = nil
+		return nil
+	}
 	data, err := base64.StdEncoding.DecodeString(b64KeyString)
 	if err != nil {
 		return err
@@ -213,6 +644,11 @@ func (b64Key *Base64Key) UnmarshalYAML(unmarshal func(interface{}) error) error
 	return nil
 }
 
+// MarshalYAML implements the yaml.Marshaler interface
+func (b64Key Base64Key) MarshalYAML() (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(b64Key), nil
+}
+
 // Reporting defines error reporting methods.
 type Reporting struct {
 	// Bugsnag configures error reporting for Bugsnag (bugsnag.com).
@@ -239,14 +675,40 @@ type Parameters map[string]interface{}
 // Environment variables may be used to override configuration parameters other than version,
 // following the scheme below:
 // Configuration.Abc may be replaced by the value of THATIQ_ABC,
-// Configuration.Abc.Xyz may be replaced by the value of THATIQ_ABC_XYZ, and so forth
+// Configuration.Abc.Xyz may be replaced by the value of THATIQ_ABC_XYZ, and so forth.
+// THATIQ_ABC_FILE may name a file holding the value instead, and string values
+// may reference files and environment variables, see Parser.Parse.
 func Parse(rd io.Reader) (*Configuration, error) {
 	in, err := ioutil.ReadAll(rd)
 	if err != nil {
 		return nil, err
 	}
 
-	p := NewParser("thatiq", []VersionedParseInfo{
+	p := newParser()
+
+	config := new(Configuration)
+	err = p.Parse(in, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ParseFile parses the configuration file at path, like Parse. The included
+// and referenced files are relative to the directory of path.
+func ParseFile(path string) (*Configuration, error) {
+	config := new(Configuration)
+	if err := newParser().ParseFile(path, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// newParser returns the parser of every configuration version.
+func newParser() *Parser {
+	return NewParser("thatiq", []VersionedParseInfo{
 		{
 			Version: MajorMinorVersion(0, 1),
 			ParseAs: reflect.TypeOf(v0_1Configuration{}),
@@ -256,18 +718,37 @@ func Parse(rd io.Reader) (*Configuration, error) {
 						v0_1.Log.Level = Loglevel("info")
 					}
 
-					return (*Configuration)(v0_1), nil
+					config := &Configuration{
+						Version:   v0_1.Version,
+						Log:       v0_1.Log,
+						Reporting: v0_1.Reporting,
+						Auth:      v0_1.Auth,
+						HTTP:      v0_1.HTTP,
+						Health:    v0_1.Health,
+						MongoDB:   map[string]MongoDB{DefaultConnection: v0_1.MongoDB},
+						Redis:     map[string]Redis{DefaultConnection: v0_1.Redis},
+					}
+					if !reflect.DeepEqual(v0_1.Mail, Mail{}) {
+						config.Mail = map[string]Mail{DefaultConnection: v0_1.Mail}
+					}
+					return config, nil
 				}
 				return nil, fmt.Errorf("Expected *v0_1Configuration, received %#v", c)
 			},
 		},
-	})
-
-	config := new(Configuration)
-	err = p.Parse(in, config)
-	if err != nil {
-		return nil, err
-	}
+		{
+			Version: MajorMinorVersion(0, 2),
+			ParseAs: reflect.TypeOf(v0_2Configuration{}),
+			ConversionFunc: func(c interface{}) (interface{}, error) {
+				if v0_2, ok := c.(*v0_2Configuration); ok {
+					if v0_2.Log.Level == Loglevel("") {
+						v0_2.Log.Level = Loglevel("info")
+					}
 
-	return config, nil
+					return (*Configuration)(v0_2), nil
+				}
+				return nil, fmt.Errorf("Expected *v0_2Configuration, received %#v", c)
+			},
+		},
+	})
 }
@@ -2,10 +2,13 @@ package configuration
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
-	"time"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v2"
@@ -15,16 +18,8 @@ import (
 func Test(t *testing.T) { TestingT(t) }
 
 var configStruct = Configuration{
-	Version: "0.1",
-	Log: struct {
-		AccessLog struct {
-			Disabled bool `yaml:"disabled,omitempty"`
-		} `yaml:"accesslog,omitempty"`
-		Level     Loglevel               `yaml:"level,omitempty"`
-		Formatter string                 `yaml:"formatter,omitempty"`
-		Fields    map[string]interface{} `yaml:"fields,omitempty"`
-		Hooks     []LogHook              `yaml:"hooks,omitempty"`
-	}{
+	Version: "0.2",
+	Log: Log{
 		Level:  "info",
 		Fields: map[string]interface{}{"environment": "test"},
 	},
@@ -35,42 +30,25 @@ var configStruct = Configuration{
 		},
 	},
 
-	HTTP: struct {
-		Addr         string        `yaml:"addr,omitempty"`
-		Net          string        `yaml:"net,omitempty"`
-		Host         string        `yaml:"host,omitempty"`
-		Prefix       string        `yaml:"prefix,omitempty"`
-		Secret       string        `yaml:"secret,omitempty"`
-		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
-		Headers      http.Header   `yaml:"headers,omitempty"`
-	}{
+	HTTP: HTTP{
 		Addr: "localhost",
 		Headers: http.Header{
 			"X-Content-Type-Options": []string{"nosniff"},
 		},
 	},
 
-	Redis: struct {
-		Addr         string        `yaml:"addr,omitempty"`
-		Password     string        `yaml:"password,omitempty"`
-		DB           int           `yaml:"db,omitempty"`
-		DialTimeout  time.Duration `yaml:"dialtimeout,omitempty"`
-		ReadTimeout  time.Duration `yaml:"readtimeout,omitempty"`
-		WriteTimeout time.Duration `yaml:"writetimeout,omitempty"`
-		MaxIdle      int           `yaml:"maxidle,omitempty"`
-		MaxActive    int           `yaml:"maxactive,omitempty"`
-		IdleTimeout  time.Duration `yaml:"idletimeout,omitempty"`
-	}{
-		Addr:     "localhost",
-		Password: "secret",
-		DB:       1,
+	Redis: map[string]Redis{
+		DefaultConnection: {
+			Addr:     "localhost",
+			Password: "secret",
+			DB:       1,
+		},
 	},
 
-	MongoDB: struct {
-		URI  string `yaml:"uri,omitempty"`
-		Name string `yaml:"name,omitempty"`
-	}{
-		URI: "mongodb://localhost:2701",
+	MongoDB: map[string]MongoDB{
+		DefaultConnection: {
+			URI: "mongodb://localhost:2701",
+		},
 	},
 }
 
@@ -83,7 +61,7 @@ log:
     environment: test
 reporting:
   bugsnag:
-    apiKey: BugsnagApiKey
+    apikey: BugsnagApiKey
 http:
   addr: localhost
   headers:
@@ -96,6 +74,30 @@ mongodb:
   uri: "mongodb://localhost:2701"
 `
 
+// configYamlV0_2 is a Version 0.2 yaml document representing configStruct
+var configYamlV0_2 = `
+version: 0.2
+log:
+  level: info
+  fields:
+    environment: test
+reporting:
+  bugsnag:
+    apikey: BugsnagApiKey
+http:
+  addr: localhost
+  headers:
+    X-Content-Type-Options: [nosniff]
+redis:
+  default:
+    addr: localhost
+    password: secret
+    db: 1
+mongodb:
+  default:
+    uri: "mongodb://localhost:2701"
+`
+
 type ConfigSuite struct {
 	expectedConfig *Configuration
 }
@@ -121,11 +123,338 @@ func (suite *ConfigSuite) TestMarshalRoundtrip(c *C) {
 // TestParseSimple validates that configYamlV0_1 can be parsed into a struct
 // matching configStruct
 func (suite *ConfigSuite) TestParseSimple(c *C) {
+	suite.expectedConfig.Version = "0.1"
 	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
 	c.Assert(err, IsNil)
 	c.Assert(config, DeepEquals, suite.expectedConfig)
 }
 
+// TestParseV0_2 validates that configYamlV0_2 can be parsed into a struct
+// matching configStruct
+func (suite *ConfigSuite) TestParseV0_2(c *C) {
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseV0_1UnknownKeys validates that the sections of a 0.1 document
+// which only exist in later versions are reported
+func (suite *ConfigSuite) TestParseV0_1UnknownKeys(c *C) {
+	in := configYamlV0_1 + "ratelimits:\n  auth.login:\n  - requests: 5\n    period: 1m\n"
+	_, err := Parse(bytes.NewReader([]byte(in)))
+	c.Assert(err, ErrorMatches, "version 0.1 doesn't support ratelimits, run `shop config migrate` to upgrade to 0.2")
+}
+
+// TestMigrate validates that configYamlV0_1 migrates to a document of the
+// current version describing the same configuration
+func (suite *ConfigSuite) TestMigrate(c *C) {
+	out, err := Migrate([]byte(configYamlV0_1))
+	c.Assert(err, IsNil)
+	config, err := Parse(bytes.NewReader(out))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvScalar validates that a scalar can be overridden by an
+// environment variable
+func (suite *ConfigSuite) TestParseWithEnvScalar(c *C) {
+	suite.expectedConfig.Log.Level = "debug"
+	suite.expectedConfig.HTTP.Addr = "localhost:5000"
+
+	os.Setenv("THATIQ_LOG_LEVEL", "debug")
+	os.Setenv("THATIQ_HTTP_ADDR", "localhost:5000")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvMapEntry validates that the fields of an existing map
+// entry can be overridden, keeping the others
+func (suite *ConfigSuite) TestParseWithEnvMapEntry(c *C) {
+	redis := suite.expectedConfig.Redis[DefaultConnection]
+	redis.DB = 3
+	suite.expectedConfig.Redis[DefaultConnection] = redis
+
+	os.Setenv("THATIQ_REDIS_DEFAULT_DB", "3")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvNewMapEntry validates that a map entry can be added by
+// setting one of its fields
+func (suite *ConfigSuite) TestParseWithEnvNewMapEntry(c *C) {
+	suite.expectedConfig.MongoDB["analytics"] = MongoDB{
+		URI:  "mongodb://analytics:27017",
+		Name: "analytics",
+	}
+
+	os.Setenv("THATIQ_MONGODB_ANALYTICS_URI", "mongodb://analytics:27017")
+	os.Setenv("THATIQ_MONGODB_ANALYTICS_NAME", "analytics")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvMapPayload validates that a whole map can be given as a
+// yaml or json payload
+func (suite *ConfigSuite) TestParseWithEnvMapPayload(c *C) {
+	suite.expectedConfig.HTTP.Headers = http.Header{
+		"X-Frame-Options":        []string{"DENY"},
+		"X-Content-Type-Options": []string{"nosniff"},
+	}
+	suite.expectedConfig.Log.Fields = map[string]interface{}{"environment": "staging"}
+
+	os.Setenv("THATIQ_HTTP_HEADERS", `{"X-Frame-Options": ["DENY"], "X-Content-Type-Options": ["nosniff"]}`)
+	os.Setenv("THATIQ_LOG_FIELDS", "environment: staging")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvInterfaceMap validates that the entries of maps of
+// arbitrary values can be set
+func (suite *ConfigSuite) TestParseWithEnvInterfaceMap(c *C) {
+	suite.expectedConfig.Payment = map[string]Parameters{
+		"midtrans": {"serverkey": "key", "production": true},
+	}
+
+	os.Setenv("THATIQ_PAYMENT_MIDTRANS_SERVERKEY", "key")
+	os.Setenv("THATIQ_PAYMENT_MIDTRANS_PRODUCTION", "true")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvIndexedSlice validates that the elements of a list can be
+// set by index, appending to the list
+func (suite *ConfigSuite) TestParseWithEnvIndexedSlice(c *C) {
+	suite.expectedConfig.Mail = map[string]Mail{
+		DefaultConnection: {
+			To: []string{"ops@example.com", "dev@example.com"},
+		},
+	}
+
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO_0", "ops@example.com")
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO_1", "dev@example.com")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvIndexedSliceOrder validates that the indexes are applied in
+// numerical order
+func (suite *ConfigSuite) TestParseWithEnvIndexedSliceOrder(c *C) {
+	restrict := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}
+	suite.expectedConfig.Auth.Verification.Restrict = restrict
+
+	for i, name := range restrict {
+		os.Setenv("THATIQ_AUTH_VERIFICATION_RESTRICT_"+strconv.Itoa(i), name)
+	}
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvIndexedSliceOverwrite validates that an existing element
+// can be overridden
+func (suite *ConfigSuite) TestParseWithEnvIndexedSliceOverwrite(c *C) {
+	suite.expectedConfig.Auth.Verification.Restrict = []string{"checkout", "review"}
+
+	os.Setenv("THATIQ_AUTH_VERIFICATION_RESTRICT_1", "review")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2 + `
+auth:
+  verification:
+    restrict: [checkout, comment]
+`)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvSlicePayload validates that a whole list can be given as a
+// yaml or json payload
+func (suite *ConfigSuite) TestParseWithEnvSlicePayload(c *C) {
+	suite.expectedConfig.Mail = map[string]Mail{
+		DefaultConnection: {
+			To: []string{"ops@example.com", "dev@example.com"},
+		},
+		"alerts": {
+			To: []string{"oncall@example.com"},
+		},
+	}
+
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO", "[ops@example.com, dev@example.com]")
+	os.Setenv("THATIQ_MAIL_ALERTS_TO", `["oncall@example.com"]`)
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvStructInSlice validates that the fields of the structs of a
+// list can be set by index
+func (suite *ConfigSuite) TestParseWithEnvStructInSlice(c *C) {
+	hook := LogHook{
+		Type:   "mail",
+		Levels: []string{"error", "panic"},
+	}
+	hook.Mail.SMTP.Addr = "smtp.example.com:25"
+	hook.Mail.To = []string{"ops@example.com"}
+	suite.expectedConfig.Log.Hooks = []LogHook{hook}
+
+	os.Setenv("THATIQ_LOG_HOOKS_0_TYPE", "mail")
+	os.Setenv("THATIQ_LOG_HOOKS_0_LEVELS", "[error, panic]")
+	os.Setenv("THATIQ_LOG_HOOKS_0_MAIL_SMTP_ADDR", "smtp.example.com:25")
+	os.Setenv("THATIQ_LOG_HOOKS_0_MAIL_TO_0", "ops@example.com")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvStructPayload validates that a whole struct can be given as
+// a yaml or json payload
+func (suite *ConfigSuite) TestParseWithEnvStructPayload(c *C) {
+	suite.expectedConfig.Redis[DefaultConnection] = Redis{Addr: "redis:6379", DB: 2}
+	suite.expectedConfig.Health.Disk = []DiskChecker{{Path: "/var/lib/thatiq", MinFree: 1024}}
+
+	os.Setenv("THATIQ_REDIS_DEFAULT", `{"addr": "redis:6379", "db": 2}`)
+	os.Setenv("THATIQ_HEALTH_DISK", "[{path: /var/lib/thatiq, minfree: 1024}]")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
+// TestParseWithEnvTypeMismatch validates that a payload which doesn't fit the
+// type of the field is reported along with the variable
+func (suite *ConfigSuite) TestParseWithEnvTypeMismatch(c *C) {
+	os.Setenv("THATIQ_REDIS_DEFAULT_DB", "one")
+
+	_, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, `environment variable THATIQ_REDIS_DEFAULT_DB: invalid int value "one": .*`)
+}
+
+// TestParseWithEnvSliceTypeMismatch validates that a list payload which
+// isn't a list is reported
+func (suite *ConfigSuite) TestParseWithEnvSliceTypeMismatch(c *C) {
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO", "{ops: ops@example.com}")
+
+	_, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, `environment variable THATIQ_MAIL_DEFAULT_TO: invalid \[\]string value .*`)
+}
+
+// TestParseWithEnvIndexOutOfRange validates that the indexes past the end of
+// the list are rejected, rather than leaving holes
+func (suite *ConfigSuite) TestParseWithEnvIndexOutOfRange(c *C) {
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO_1", "ops@example.com")
+
+	_, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, "environment variable THATIQ_MAIL_DEFAULT_TO_1: index 1 out of range, the list has 0 elements")
+}
+
+// TestParseWithEnvInvalidIndex validates that a list element must be given by
+// its index
+func (suite *ConfigSuite) TestParseWithEnvInvalidIndex(c *C) {
+	os.Setenv("THATIQ_MAIL_DEFAULT_TO_FIRST", "ops@example.com")
+
+	_, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, "environment variable THATIQ_MAIL_DEFAULT_TO_FIRST: expected a list index, got FIRST")
+}
+
+// TestParseWithEnvScalarPath validates that a path going through a scalar is
+// rejected
+func (suite *ConfigSuite) TestParseWithEnvScalarPath(c *C) {
+	os.Setenv("THATIQ_HTTP_ADDR_PORT", "5000")
+
+	_, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, "environment variable THATIQ_HTTP_ADDR_PORT: can't set PORT in a string value")
+}
+
+// TestParseWithEnvNonStringKeys validates that the keys of maps which aren't
+// keyed by strings are unmarshaled
+func (suite *ConfigSuite) TestParseWithEnvNonStringKeys(c *C) {
+	type ports struct {
+		Version Version
+		Ports   map[int]string
+	}
+	p := NewParser("test", []VersionedParseInfo{
+		{
+			Version: MajorMinorVersion(0, 1),
+			ParseAs: reflect.TypeOf(ports{}),
+			ConversionFunc: func(c interface{}) (interface{}, error) {
+				return c, nil
+			},
+		},
+	})
+	p.env = envVars{
+		{name: "TEST_PORTS_80", value: "http"},
+		{name: "TEST_PORTS_443", value: "https"},
+	}
+
+	var config ports
+	err := p.Parse([]byte("version: 0.1\nports: {80: www}"), &config)
+	c.Assert(err, IsNil)
+	c.Assert(config.Ports, DeepEquals, map[int]string{80: "http", 443: "https"})
+
+	p.env = envVars{{name: "TEST_PORTS_HTTP", value: "http"}}
+	err = p.Parse([]byte("version: 0.1"), &config)
+	c.Assert(err, ErrorMatches, "environment variable TEST_PORTS_HTTP: HTTP is not a valid int map key")
+}
+
+// TestParseWithEnvFile validates that a value can be read from the file named
+// by a _FILE variable
+func (suite *ConfigSuite) TestParseWithEnvFile(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "password")
+	c.Assert(ioutil.WriteFile(path, []byte("s3cr3t\n"), 0600), IsNil)
+
+	redis := suite.expectedConfig.Redis[DefaultConnection]
+	redis.Password = "s3cr3t"
+	suite.expectedConfig.Redis[DefaultConnection] = redis
+
+	os.Setenv("THATIQ_REDIS_DEFAULT_PASSWORD_FILE", path)
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+
+	os.Setenv("THATIQ_REDIS_DEFAULT_PASSWORD", "other")
+	_, err = Parse(bytes.NewReader([]byte(configYamlV0_2)))
+	c.Assert(err, ErrorMatches, "both THATIQ_REDIS_DEFAULT_PASSWORD and THATIQ_REDIS_DEFAULT_PASSWORD_FILE are set")
+}
+
+// TestParseWithReferences validates that the references to files and
+// environment variables are resolved
+func (suite *ConfigSuite) TestParseWithReferences(c *C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "password"), []byte("s3cr3t\n"), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte(configYamlV0_2), 0600), IsNil)
+
+	redis := suite.expectedConfig.Redis[DefaultConnection]
+	redis.Password = "s3cr3t"
+	redis.DB = 4
+	suite.expectedConfig.Redis[DefaultConnection] = redis
+	suite.expectedConfig.HTTP.Secret = "${literal}"
+
+	os.Setenv("REDIS_DB", "4")
+	os.Setenv("THATIQ_REDIS_DEFAULT_PASSWORD", "${file:password}")
+	os.Setenv("THATIQ_REDIS_DEFAULT_DB", "${env:REDIS_DB}")
+	os.Setenv("THATIQ_HTTP_SECRET", "$${literal}")
+
+	config, err := ParseFile(filepath.Join(dir, "config.yml"))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+}
+
 func copyConfig(config Configuration) *Configuration {
 	configCopy := new(Configuration)
 
@@ -137,14 +466,23 @@ func copyConfig(config Configuration) *Configuration {
 		configCopy.Log.Fields[k] = v
 	}
 
+	configCopy.Reporting = config.Reporting
+
 	configCopy.HTTP = config.HTTP
 	configCopy.HTTP.Headers = make(http.Header)
 	for k, v := range config.HTTP.Headers {
 		configCopy.HTTP.Headers[k] = v
 	}
 
-	configCopy.Redis = config.Redis
-	configCopy.MongoDB = config.MongoDB
+	configCopy.Redis = make(map[string]Redis, len(config.Redis))
+	for k, v := range config.Redis {
+		configCopy.Redis[k] = v
+	}
+
+	configCopy.MongoDB = make(map[string]MongoDB, len(config.MongoDB))
+	for k, v := range config.MongoDB {
+		configCopy.MongoDB[k] = v
+	}
 
 	return configCopy
 }
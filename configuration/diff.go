@@ -0,0 +1,115 @@
+package configuration
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change describes a configuration parameter that differs between two
+// configurations. Path is the dotted yaml path of the parameter, such as
+// "log.level".
+type Change struct {
+	Path     string
+	Old, New interface{}
+}
+
+// Secret reports whether the parameter holds a secret, such as a password or
+// an api key, whose value shouldn't be shown.
+func (c Change) Secret() bool {
+	return isSecret(c.Path)
+}
+
+func (c Change) String() string {
+	if c.Secret() {
+		return c.Path + ": changed"
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// secretNames are the substrings of the parameter names that hold secrets.
+var secretNames = []string{"password", "secret", "key", "token"}
+
+func isSecret(path string) bool {
+	name := strings.ToLower(path[strings.LastIndex(path, ".")+1:])
+	for _, s := range secretNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the parameters that differ between old and new, sorted by
+// path. Structs and maps are compared member by member, any other value as
+// a whole.
+func Diff(old, new *Configuration) []Change {
+	var changes []Change
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func diffValue(path string, old, new reflect.Value, changes *[]Change) {
+	switch old.Kind() {
+	case reflect.Struct:
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			diffValue(joinPath(path, yamlName(field)), old.Field(i), new.Field(i), changes)
+		}
+		return
+
+	case reflect.Map:
+		if old.Type().Key().Kind() == reflect.String {
+			keys := make(map[string]bool)
+			for _, k := range old.MapKeys() {
+				keys[k.String()] = true
+			}
+			for _, k := range new.MapKeys() {
+				keys[k.String()] = true
+			}
+			for k := range keys {
+				key := reflect.ValueOf(k).Convert(old.Type().Key())
+				diffMapEntry(joinPath(path, k), old.MapIndex(key), new.MapIndex(key), changes)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+		*changes = append(*changes, Change{Path: path, Old: old.Interface(), New: new.Interface()})
+	}
+}
+
+func diffMapEntry(path string, old, new reflect.Value, changes *[]Change) {
+	switch {
+	case !old.IsValid():
+		*changes = append(*changes, Change{Path: path, New: new.Interface()})
+	case !new.IsValid():
+		*changes = append(*changes, Change{Path: path, Old: old.Interface()})
+	default:
+		diffValue(path, old, new, changes)
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// yamlName returns the name of the field in the yaml document.
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
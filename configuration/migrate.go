@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// migration rewrites a configuration document of a version to the next one.
+type migration struct {
+	to      Version
+	migrate func(doc yaml.MapSlice) yaml.MapSlice
+}
+
+// migrations maps the versions to the migration to the next version.
+var migrations = map[Version]migration{
+	MajorMinorVersion(0, 1): {
+		to: MajorMinorVersion(0, 2),
+		migrate: func(doc yaml.MapSlice) yaml.MapSlice {
+			// the single connections become the default ones.
+			for i, item := range doc {
+				switch item.Key {
+				case "mongodb", "redis", "mail":
+					if item.Value != nil {
+						doc[i].Value = yaml.MapSlice{{Key: DefaultConnection, Value: item.Value}}
+					}
+				}
+			}
+			return doc
+		},
+	},
+}
+
+// Migrate rewrites the configuration document in to the CurrentVersion. The
+// environment overrides, includes and references aren't applied, so they
+// don't end up in the file. Comments are lost.
+func Migrate(in []byte) ([]byte, error) {
+	var versionedStruct struct {
+		Version Version
+	}
+	if err := yaml.Unmarshal(in, &versionedStruct); err != nil {
+		return nil, err
+	}
+	version := versionedStruct.Version
+	if version == CurrentVersion {
+		return in, nil
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+
+	for version != CurrentVersion {
+		m, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported version: %q", version)
+		}
+		doc = m.migrate(doc)
+		version = m.to
+	}
+	for i, item := range doc {
+		if item.Key == "version" {
+			doc[i].Value = string(CurrentVersion)
+		}
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure both documents describe the same configuration.
+	p := newParser()
+	p.env = nil
+	p.literal = true
+	var before, after Configuration
+	if err := p.Parse(in, &before); err != nil {
+		return nil, err
+	}
+	if err := p.Parse(out, &after); err != nil {
+		return nil, fmt.Errorf("error parsing the migrated configuration: %v", err)
+	}
+	before.Version = after.Version
+	if !reflect.DeepEqual(before, after) {
+		return nil, fmt.Errorf("the migrated configuration differs from the original: %v", Diff(&before, &after))
+	}
+
+	return out, nil
+}
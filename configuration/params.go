@@ -0,0 +1,76 @@
+package configuration
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Param is a single configuration parameter and its effective value.
+type Param struct {
+	// Path is the dotted yaml path of the parameter, such as "http.addr".
+	// Elements of lists are addressed by their index.
+	Path string
+
+	// Env is the environment variable overriding the parameter, such as
+	// THATIQ_HTTP_ADDR.
+	Env string
+
+	Value interface{}
+}
+
+// Secret reports whether the parameter holds a secret, such as a password or
+// an api key, whose value shouldn't be shown.
+func (p Param) Secret() bool {
+	return isSecret(p.Path)
+}
+
+// Params returns every parameter of config, sorted by path. Structs, maps and
+// lists of structs are expanded, any other value is a single parameter.
+func Params(config *Configuration) []Param {
+	var params []Param
+	walkParams("", "THATIQ", reflect.ValueOf(*config), &params)
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Path < params[j].Path
+	})
+	return params
+}
+
+func walkParams(path, env string, v reflect.Value, params *[]Param) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			walkParams(joinPath(path, yamlName(field)), env+"_"+strings.ToUpper(field.Name), v.Field(i), params)
+		}
+		return
+
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String && v.Len() > 0 {
+			for _, k := range v.MapKeys() {
+				walkParams(joinPath(path, k.String()), env+"_"+strings.ToUpper(k.String()), v.MapIndex(k), params)
+			}
+			return
+		}
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct && v.Len() > 0 {
+			for i := 0; i < v.Len(); i++ {
+				walkParams(joinPath(path, strconv.Itoa(i)), env+"_"+strconv.Itoa(i), v.Index(i), params)
+			}
+			return
+		}
+
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkParams(path, env, v.Elem(), params)
+			return
+		}
+	}
+
+	*params = append(*params, Param{Path: path, Env: env, Value: v.Interface()})
+}
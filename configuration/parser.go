@@ -1,8 +1,11 @@
 package configuration
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -63,13 +66,36 @@ type VersionedParseInfo struct {
 type envVar struct {
 	name  string
 	value string
+
+	// file is set if value is the path of a file holding the actual value,
+	// given by a variable suffixed with _FILE.
+	file bool
 }
 
 type envVars []envVar
 
 func (a envVars) Len() int           { return len(a) }
 func (a envVars) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a envVars) Less(i, j int) bool { return a[i].name < a[j].name }
+func (a envVars) Less(i, j int) bool { return envLess(a[i].name, a[j].name) }
+
+// envLess compares the names of environment variables segment by segment,
+// the numeric segments by their value, so that PREFIX_ABC_2 comes before
+// PREFIX_ABC_10 and lists are filled in order.
+func envLess(a, b string) bool {
+	as, bs := strings.Split(a, "_"), strings.Split(b, "_")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
 
 // Parser can be used to parse a configuration file and environment of a defined
 // version into a unified output structure
@@ -77,6 +103,10 @@ type Parser struct {
 	prefix  string
 	mapping map[Version]VersionedParseInfo
 	env     envVars
+
+	// literal disables the includes and references, the document is parsed
+	// as written.
+	literal bool
 }
 
 // NewParser returns a *Parser with the given environment prefix which handles
@@ -90,15 +120,24 @@ func NewParser(prefix string, parseInfos []VersionedParseInfo) *Parser {
 
 	for _, env := range os.Environ() {
 		envParts := strings.SplitN(env, "=", 2)
-		p.env = append(p.env, envVar{envParts[0], envParts[1]})
+		v := envVar{name: envParts[0], value: envParts[1]}
+		if strings.HasSuffix(v.name, "_FILE") {
+			// PREFIX_ABC_FILE holds the path of the file PREFIX_ABC is
+			// read from.
+			v.name = strings.TrimSuffix(v.name, "_FILE")
+			v.file = true
+		}
+		p.env = append(p.env, v)
 	}
 
 	// We must sort the environment variables lexically by name so that
-	// more specific variables are applied before less specific ones
+	// less specific variables are applied before more specific ones
 	// (i.e. THATIQ_REDIS before
 	// THATIQ_REDIS_PASSWWORD). This sucks, but it's a
 	// lot simpler and easier to get right than unmarshalling map entries
-	// into temporaries and merging with the existing entry.
+	// into temporaries and merging with the existing entry. The list
+	// indexes are sorted numerically (i.e. THATIQ_MAIL_TO_2 before
+	// THATIQ_MAIL_TO_10).
 	sort.Sort(p.env)
 
 	return &p
@@ -110,8 +149,39 @@ func NewParser(prefix string, parseInfos []VersionedParseInfo) *Parser {
 // Environment variables may be used to override configuration parameters other
 // than version, following the scheme below:
 // v.Abc may be replaced by the value of PREFIX_ABC,
-// v.Abc.Xyz may be replaced by the value of PREFIX_ABC_XYZ, and so forth
+// v.Abc.Xyz may be replaced by the value of PREFIX_ABC_XYZ, and so forth.
+// PREFIX_ABC_FILE may name a file holding the value of PREFIX_ABC instead.
+//
+// The document may include other files, see MergeIncludes. The string values,
+// in the document and the environment variables, may reference a file with
+// ${file:/path/to/file} and an environment variable with ${env:NAME}. They
+// are resolved before the values are unmarshaled. Relative paths are relative
+// to the working directory.
 func (p *Parser) Parse(in []byte, v interface{}) error {
+	return p.parse(in, "", v)
+}
+
+// ParseFile reads the configuration file at path, like Parse. Relative paths
+// of included and referenced files are relative to the directory of path.
+func (p *Parser) ParseFile(path string, v interface{}) error {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return p.parse(in, filepath.Dir(path), v)
+}
+
+func (p *Parser) parse(in []byte, dir string, v interface{}) error {
+	if !p.literal {
+		var err error
+		if in, err = MergeIncludes(in, dir); err != nil {
+			return err
+		}
+		if in, err = resolveReferences(in, dir); err != nil {
+			return err
+		}
+	}
+
 	var versionedStruct struct {
 		Version Version
 	}
@@ -130,13 +200,35 @@ func (p *Parser) Parse(in []byte, v interface{}) error {
 	if err != nil {
 		return err
 	}
+	if versionedStruct.Version != CurrentVersion {
+		// the sections an older version doesn't know about are most
+		// likely meant for a later one, don't ignore them silently.
+		unknown, err := unknownKeys(in, parseInfo.ParseAs)
+		if err != nil {
+			return err
+		}
+		if len(unknown) > 0 {
+			return fmt.Errorf("version %s doesn't support %s, run `shop config migrate` to upgrade to %s",
+				versionedStruct.Version, strings.Join(unknown, ", "), CurrentVersion)
+		}
+	}
 
+	seen := make(map[string]bool)
 	for _, envVar := range p.env {
 		pathStr := envVar.name
 		if strings.HasPrefix(pathStr, strings.ToUpper(p.prefix)+"_") {
-			path := strings.Split(pathStr, "_")
+			if seen[pathStr] {
+				return fmt.Errorf("both %s and %s_FILE are set", pathStr, pathStr)
+			}
+			seen[pathStr] = true
 
-			err = p.overwriteFields(parseAs, pathStr, path[1:], envVar.value)
+			payload, err := p.envPayload(envVar, dir)
+			if err != nil {
+				return err
+			}
+
+			path := strings.Split(pathStr, "_")
+			err = p.overwriteFields(parseAs, pathStr, path[1:], payload)
 			if err != nil {
 				return err
 			}
@@ -151,6 +243,72 @@ func (p *Parser) Parse(in []byte, v interface{}) error {
 	return nil
 }
 
+// unknownKeys returns the top level keys of the document in that t, a struct
+// type, has no field for.
+func unknownKeys(in []byte, t reflect.Type) ([]string, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		known[name] = true
+	}
+
+	var unknown []string
+	for _, item := range doc {
+		if key := fmt.Sprint(item.Key); !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown, nil
+}
+
+// envPayload returns the value of the environment variable, read from a file
+// if needed, with the references resolved.
+func (p *Parser) envPayload(v envVar, dir string) (string, error) {
+	if v.file {
+		content, err := readSecretFile(v.value, dir)
+		if err != nil {
+			return "", fmt.Errorf("%s_FILE: %v", v.name, err)
+		}
+		return scalarPayload(content), nil
+	}
+
+	if p.literal {
+		return v.value, nil
+	}
+	resolved, err := expandReferences(v.value, dir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", v.name, err)
+	}
+	if resolved != v.value && referencePattern.FindString(v.value) == v.value {
+		return scalarPayload(resolved), nil
+	}
+	// the payload is yaml, like the variables without references.
+	return resolved, nil
+}
+
+// scalarPayload returns the yaml document of the scalar value s, quoting it
+// unless it is a number or a boolean. The content of a secret file is taken
+// as is, it is not a yaml document.
+func scalarPayload(s string) string {
+	if _, ok := scalar(s).(string); !ok {
+		return s
+	}
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return s
+	}
+	return string(out)
+}
+
 // overwriteFields replaces configuration values with alternate values specified
 // through the environment. Precondition: an empty path slice must never be
 // passed in.
@@ -166,10 +324,20 @@ func (p *Parser) overwriteFields(v reflect.Value, fullpath string, path []string
 		return p.overwriteStruct(v, fullpath, path, payload)
 	case reflect.Map:
 		return p.overwriteMap(v, fullpath, path, payload)
+	case reflect.Slice:
+		return p.overwriteSlice(v, fullpath, path, payload)
 	case reflect.Interface:
 		if v.NumMethod() == 0 {
 			if !v.IsNil() {
-				return p.overwriteFields(v.Elem(), fullpath, path, payload)
+				// the dynamic value isn't addressable, overwrite a
+				// copy and store it back.
+				elem := reflect.New(v.Elem().Type()).Elem()
+				elem.Set(v.Elem())
+				if err := p.overwriteFields(elem, fullpath, path, payload); err != nil {
+					return err
+				}
+				v.Set(elem)
+				return nil
 			}
 			// Interface was empty; create an implicit map
 			var template map[string]interface{}
@@ -178,7 +346,7 @@ func (p *Parser) overwriteFields(v reflect.Value, fullpath string, path []string
 			return p.overwriteMap(wrappedV, fullpath, path, payload)
 		}
 	}
-	return nil
+	return fmt.Errorf("environment variable %s: can't set %s in a %s value", fullpath, strings.Join(path, "_"), v.Type())
 }
 
 func (p *Parser) overwriteStruct(v reflect.Value, fullpath string, path []string, payload string) error {
@@ -204,8 +372,7 @@ func (p *Parser) overwriteStruct(v reflect.Value, fullpath string, path []string
 	if len(path) == 1 {
 		// Env var specifies this field directly
 		fieldVal := reflect.New(sf.Type)
-		err := yaml.Unmarshal([]byte(payload), fieldVal.Interface())
-		if err != nil {
+		if err := unmarshalPayload(fullpath, payload, fieldVal); err != nil {
 			return err
 		}
 		field.Set(reflect.Indirect(fieldVal))
@@ -220,7 +387,7 @@ func (p *Parser) overwriteStruct(v reflect.Value, fullpath string, path []string
 		}
 	case reflect.Ptr:
 		if field.IsNil() {
-			field.Set(reflect.New(sf.Type))
+			field.Set(reflect.New(sf.Type.Elem()))
 		}
 	}
 
@@ -233,28 +400,36 @@ func (p *Parser) overwriteStruct(v reflect.Value, fullpath string, path []string
 }
 
 func (p *Parser) overwriteMap(m reflect.Value, fullpath string, path []string, payload string) error {
-	if m.Type().Key().Kind() != reflect.String {
-		// non-string keys unsupported
-		logrus.Warnf("Ignoring environment variable %s involving map with non-string keys", fullpath)
-		return nil
+	key, err := mapKey(m.Type().Key(), fullpath, path[0])
+	if err != nil {
+		return err
 	}
 
 	if len(path) > 1 {
 		// If a matching key exists, get its value and continue the
 		// overwriting process.
 		for _, k := range m.MapKeys() {
-			if strings.ToUpper(k.String()) == path[0] {
-				mapValue := m.MapIndex(k)
-				// If the existing value is nil, we want to
-				// recreate it instead of using this value.
-				if (mapValue.Kind() == reflect.Ptr ||
-					mapValue.Kind() == reflect.Interface ||
-					mapValue.Kind() == reflect.Map) &&
-					mapValue.IsNil() {
-					break
-				}
-				return p.overwriteFields(mapValue, fullpath, path[1:], payload)
+			if !sameKey(k, key) {
+				continue
 			}
+			mapValue := m.MapIndex(k)
+			// If the existing value is nil, we want to
+			// recreate it instead of using this value.
+			if (mapValue.Kind() == reflect.Ptr ||
+				mapValue.Kind() == reflect.Interface ||
+				mapValue.Kind() == reflect.Map) &&
+				mapValue.IsNil() {
+				break
+			}
+			// map elements aren't addressable, overwrite a copy and
+			// store it back.
+			copied := reflect.New(mapValue.Type()).Elem()
+			copied.Set(mapValue)
+			if err := p.overwriteFields(copied, fullpath, path[1:], payload); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, copied)
+			return nil
 		}
 	}
 
@@ -271,13 +446,97 @@ func (p *Parser) overwriteMap(m reflect.Value, fullpath string, path []string, p
 			return err
 		}
 	} else {
-		err := yaml.Unmarshal([]byte(payload), mapValue.Interface())
-		if err != nil {
+		if mapValue.Kind() == reflect.Map {
+			// unmarshal into a pointer, so the map can be replaced.
+			ptr := reflect.New(mapValue.Type())
+			ptr.Elem().Set(mapValue)
+			mapValue = ptr
+		}
+		if err := unmarshalPayload(fullpath, payload, mapValue); err != nil {
 			return err
 		}
 	}
 
-	m.SetMapIndex(reflect.ValueOf(strings.ToLower(path[0])), reflect.Indirect(mapValue))
+	m.SetMapIndex(key, reflect.Indirect(mapValue))
 
 	return nil
 }
+
+// overwriteSlice sets the element of the slice s at the index path[0]. The
+// index may be the length of the slice, the element is appended then.
+func (p *Parser) overwriteSlice(s reflect.Value, fullpath string, path []string, payload string) error {
+	index, err := strconv.Atoi(path[0])
+	if err != nil || index < 0 {
+		return fmt.Errorf("environment variable %s: expected a list index, got %s", fullpath, path[0])
+	}
+	if index > s.Len() {
+		return fmt.Errorf("environment variable %s: index %d out of range, the list has %d elements", fullpath, index, s.Len())
+	}
+
+	elem := reflect.New(s.Type().Elem())
+	if index < s.Len() {
+		elem.Elem().Set(s.Index(index))
+	}
+
+	if len(path) > 1 {
+		if elem.Elem().Kind() == reflect.Map && elem.Elem().IsNil() {
+			elem.Elem().Set(reflect.MakeMap(elem.Elem().Type()))
+		}
+		if err := p.overwriteFields(elem, fullpath, path[1:], payload); err != nil {
+			return err
+		}
+	} else if err := unmarshalPayload(fullpath, payload, elem); err != nil {
+		return err
+	}
+
+	if index == s.Len() {
+		if !s.CanSet() {
+			return fmt.Errorf("environment variable %s: can't append to the list", fullpath)
+		}
+		s.Set(reflect.Append(s, elem.Elem()))
+	} else {
+		s.Index(index).Set(elem.Elem())
+	}
+	return nil
+}
+
+// mapKey returns the key of a map with keys of type t, written as segment in
+// the name of an environment variable. String keys are lowercased.
+func mapKey(t reflect.Type, fullpath, segment string) (reflect.Value, error) {
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(strings.ToLower(segment)).Convert(t), nil
+	}
+
+	key := reflect.New(t)
+	if err := yaml.Unmarshal([]byte(strings.ToLower(segment)), key.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("environment variable %s: %s is not a valid %s map key", fullpath, segment, t)
+	}
+	return key.Elem(), nil
+}
+
+// sameKey reports whether the existing map key k matches key, the string keys
+// are compared case-insensitively.
+func sameKey(k, key reflect.Value) bool {
+	if k.Kind() == reflect.String {
+		return strings.EqualFold(k.String(), key.String())
+	}
+	return reflect.DeepEqual(k.Interface(), key.Interface())
+}
+
+// unmarshalPayload unmarshals the yaml, or json, payload of the environment
+// variable into the pointer v.
+func unmarshalPayload(fullpath, payload string, v reflect.Value) error {
+	err := yaml.Unmarshal([]byte(payload), v.Interface())
+	if err == nil {
+		return nil
+	}
+
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs := make([]string, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			msgs[i] = strings.TrimPrefix(msg, "line 1: ")
+		}
+		err = errors.New(strings.Join(msgs, "; "))
+	}
+	return fmt.Errorf("environment variable %s: invalid %s value %q: %v", fullpath, v.Type().Elem(), payload, err)
+}
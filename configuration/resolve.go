@@ -0,0 +1,218 @@
+package configuration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// includeKey is the top level key listing the files a configuration document
+// is overlaid on.
+const includeKey = "include"
+
+// referencePattern matches the references to files, ${file:/run/secrets/x},
+// and environment variables, ${env:NAME}. $${ escapes a literal ${.
+var referencePattern = regexp.MustCompile(`\$\$\{|\$\{(file|env):([^}]*)\}`)
+
+// MergeIncludes returns the document in overlaid on the files it includes.
+// The document may list them under the top level include key, relative paths
+// are relative to dir:
+//
+//	include:
+//	  - base.yml
+//
+// The included files are merged in order, each one overriding the previous
+// ones, and the document overrides them all. Maps are merged key by key, any
+// other value is replaced. Included files may include other files.
+func MergeIncludes(in []byte, dir string) ([]byte, error) {
+	doc, err := mergeIncludes(in, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func mergeIncludes(in []byte, dir string, seen []string) (map[interface{}]interface{}, error) {
+	var doc map[interface{}]interface{}
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	switch include := doc[includeKey].(type) {
+	case nil:
+		return doc, nil
+	case string:
+		includes = []string{include}
+	case []interface{}:
+		for _, path := range include {
+			s, ok := path.(string)
+			if !ok {
+				return nil, fmt.Errorf("include: expected a file path, got %v", path)
+			}
+			includes = append(includes, s)
+		}
+	default:
+		return nil, fmt.Errorf("include: expected a file path or a list of file paths, got %v", include)
+	}
+	delete(doc, includeKey)
+
+	merged := make(map[interface{}]interface{})
+	for _, path := range includes {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		for _, s := range seen {
+			if s == path {
+				return nil, fmt.Errorf("include: %s includes itself", path)
+			}
+		}
+
+		base, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("include: %v", err)
+		}
+		baseDoc, err := mergeIncludes(base, filepath.Dir(path), append(seen, path))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+
+		if version, ok := baseDoc["version"]; ok && fmt.Sprint(version) != fmt.Sprint(doc["version"]) {
+			return nil, fmt.Errorf("include: %s is version %v, the including file version %v", path, version, doc["version"])
+		}
+		merged = mergeMaps(merged, baseDoc)
+	}
+
+	return mergeMaps(merged, doc), nil
+}
+
+// mergeMaps returns base with the values of overlay, merging the maps both
+// have.
+func mergeMaps(base, overlay map[interface{}]interface{}) map[interface{}]interface{} {
+	for k, v := range overlay {
+		baseMap, baseOk := base[k].(map[interface{}]interface{})
+		overlayMap, overlayOk := v.(map[interface{}]interface{})
+		if baseOk && overlayOk {
+			base[k] = mergeMaps(baseMap, overlayMap)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+// resolveReferences replaces the references to files and environment
+// variables in the string values of the document in.
+func resolveReferences(in []byte, dir string) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+
+	doc, err := resolveValue("", doc, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+func resolveValue(path string, v interface{}, dir string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, elem := range v {
+			resolved, err := resolveValue(joinPath(path, fmt.Sprint(k)), elem, dir)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = resolved
+		}
+	case []interface{}:
+		for i, elem := range v {
+			resolved, err := resolveValue(joinPath(path, fmt.Sprint(i)), elem, dir)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	case string:
+		resolved, err := expandReferences(v, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if resolved != v && referencePattern.FindString(v) == v {
+			// the value is a single reference, keep the type of the
+			// referenced value so it can be a number or a boolean.
+			return scalar(resolved), nil
+		}
+		return resolved, nil
+	}
+	return v, nil
+}
+
+// expandReferences replaces the references to files and environment variables
+// in s. Relative file paths are relative to dir.
+func expandReferences(s, dir string) (string, error) {
+	var firstErr error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+
+		var (
+			value string
+			err   error
+		)
+		m := referencePattern.FindStringSubmatch(ref)
+		switch m[1] {
+		case "file":
+			value, err = readSecretFile(m[2], dir)
+		case "env":
+			var ok bool
+			if value, ok = os.LookupEnv(m[2]); !ok {
+				err = fmt.Errorf("environment variable %s referenced by %s is not set", m[2], ref)
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return value
+	})
+	return expanded, firstErr
+}
+
+// readSecretFile reads a file holding a secret. The trailing newlines are
+// trimmed.
+func readSecretFile(path, dir string) (string, error) {
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// scalar returns the yaml scalar s represents, if it is written the way yaml
+// writes it back, s otherwise. That way "5000" is a number, but "0123" stays
+// a string.
+func scalar(s string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case int, int64, uint64, float64, bool:
+		out, err := yaml.Marshal(v)
+		if err == nil && strings.TrimSpace(string(out)) == s {
+			return v
+		}
+	}
+	return s
+}
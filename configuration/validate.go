@@ -0,0 +1,314 @@
+package configuration
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationErrors holds every problem found by Validate.
+type ValidationErrors []error
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// validator collects the problems of a configuration.
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// Validate checks the semantics of config, beyond what Parse can check:
+// addresses, URLs, durations, keys and the options limited to a set of
+// values. It returns a ValidationErrors reporting every problem at once,
+// sorted by parameter path, or nil.
+func Validate(config *Configuration) error {
+	v := &validator{}
+
+	switch config.Log.Formatter {
+	case "", "text", "json", "logstash":
+	default:
+		v.errorf("log.formatter", "unsupported formatter %q, must be one of text, json or logstash", config.Log.Formatter)
+	}
+	for i, hook := range config.Log.Hooks {
+		path := fmt.Sprintf("log.hooks.%d", i)
+		if hook.Type == "" {
+			v.errorf(path+".type", "required")
+		}
+		v.validateMail(path+".options", hook.Mail)
+	}
+
+	v.validateHTTP(config)
+
+	for name, conf := range config.Mail {
+		v.validateMail("mail."+name, conf)
+	}
+	// the links of the mails are built on http.host.
+	if config.Mail[DefaultConnection].SMTP.Addr != "" && config.HTTP.Host == "" {
+		v.errorf("http.host", "required to send mail")
+	}
+
+	if _, ok := config.Redis[DefaultConnection]; !ok {
+		v.errorf("redis."+DefaultConnection, "required")
+	}
+	for name, conf := range config.Redis {
+		v.validateRedis("redis."+name, conf)
+	}
+
+	if _, ok := config.MongoDB[DefaultConnection]; !ok {
+		v.errorf("mongodb."+DefaultConnection, "required")
+	}
+	for name, conf := range config.MongoDB {
+		v.mongoURI("mongodb."+name+".uri", conf.URI)
+	}
+
+	if len(config.Storage) > 1 {
+		v.errorf("storage", "must provide exactly one storage type")
+	}
+
+	for route, limits := range config.RateLimits {
+		for i, limit := range limits {
+			v.validateRateLimit(fmt.Sprintf("ratelimits.%s.%d", route, i), limit)
+		}
+	}
+
+	for i, disk := range config.Health.Disk {
+		if disk.Path == "" {
+			v.errorf(fmt.Sprintf("health.disk.%d.path", i), "required")
+		}
+	}
+
+	for _, param := range Params(config) {
+		if d, ok := param.Value.(time.Duration); ok && d < 0 {
+			v.errorf(param.Path, "negative duration %v", d)
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.Slice(v.errs, func(i, j int) bool {
+		return v.errs[i].Error() < v.errs[j].Error()
+	})
+	return v.errs
+}
+
+func (v *validator) validateMail(path string, conf Mail) {
+	if conf.SMTP.Addr != "" {
+		v.hostPort(path+".smtp.addr", conf.SMTP.Addr)
+		if _, err := mail.ParseAddress(conf.From); err != nil {
+			v.errorf(path+".from", "invalid address %q: %v", conf.From, err)
+		}
+	}
+	for i, to := range conf.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			v.errorf(fmt.Sprintf("%s.to.%d", path, i), "invalid address %q: %v", to, err)
+		}
+	}
+}
+
+func (v *validator) validateHTTP(config *Configuration) {
+	switch config.HTTP.Net {
+	case "", "tcp":
+		v.hostPort("http.addr", config.HTTP.Addr)
+	case "unix":
+		if config.HTTP.Addr == "" {
+			v.errorf("http.addr", "required")
+		}
+	case "systemd":
+	case "fd":
+		if fd, err := strconv.Atoi(config.HTTP.Addr); err != nil || fd < 3 {
+			v.errorf("http.addr", "invalid file descriptor %q", config.HTTP.Addr)
+		}
+	default:
+		v.errorf("http.net", "unsupported net %q, must be one of tcp, unix, systemd or fd", config.HTTP.Net)
+	}
+
+	if config.HTTP.Host != "" {
+		u, err := url.Parse(config.HTTP.Host)
+		if err != nil {
+			v.errorf("http.host", "%v", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf("http.host", "must be an absolute http or https URL, got %q", config.HTTP.Host)
+		}
+	}
+
+	for i, proxy := range config.HTTP.TrustedProxies {
+		if _, err := parseNetwork(proxy); err != nil {
+			v.errorf(fmt.Sprintf("http.trustedproxies.%d", i), "%v", err)
+		}
+	}
+
+	if config.HTTP.Prefix != "" && !strings.HasPrefix(config.HTTP.Prefix, "/") {
+		v.errorf("http.prefix", "must start with /, got %q", config.HTTP.Prefix)
+	}
+
+	// the key is split in a hash key and an AES-128, 192 or 256 key.
+	switch n := len(config.HTTP.SessionKey); n {
+	case 0, 32, 48, 64:
+	default:
+		v.errorf("http.session_key", "must decode to 32, 48 or 64 bytes, got %d, use `shop session generate`", n)
+	}
+
+	switch config.HTTP.Session.SameSite {
+	case "", "lax", "strict", "none":
+	default:
+		v.errorf("http.session.samesite", "unsupported mode %q, must be one of lax, strict or none", config.HTTP.Session.SameSite)
+	}
+
+	tlsConf := config.HTTP.TLS
+	if tlsConf.Certificate != "" || tlsConf.Key != "" {
+		if tlsConf.Certificate == "" {
+			v.errorf("http.tls.certificate", "required with http.tls.key")
+		}
+		if tlsConf.Key == "" {
+			v.errorf("http.tls.key", "required with http.tls.certificate")
+		}
+	}
+	switch tlsConf.MinimumTLS {
+	case "", "tls1.0", "tls1.1", "tls1.2", "tls1.3":
+	default:
+		v.errorf("http.tls.minimumtls", "unsupported version %q, must be one of tls1.0, tls1.1, tls1.2 or tls1.3", tlsConf.MinimumTLS)
+	}
+	if len(tlsConf.CipherSuites) > 0 {
+		known := make(map[string]bool)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			known[suite.Name] = true
+		}
+		for i, name := range tlsConf.CipherSuites {
+			if !known[name] {
+				v.errorf(fmt.Sprintf("http.tls.ciphersuites.%d", i), "unknown cipher suite %q", name)
+			}
+		}
+	}
+}
+
+func (v *validator) validateRedis(path string, conf Redis) {
+	sentinel, cluster := conf.Sentinel.MasterName != "", len(conf.Cluster.Addrs) > 0
+	switch {
+	case sentinel && cluster:
+		v.errorf(path, "sentinel and cluster are exclusive")
+	case sentinel:
+		if len(conf.Sentinel.Addrs) == 0 {
+			v.errorf(path+".sentinel.addrs", "required")
+		}
+		for i, addr := range conf.Sentinel.Addrs {
+			v.hostPort(fmt.Sprintf("%s.sentinel.addrs.%d", path, i), addr)
+		}
+	case cluster:
+		for i, addr := range conf.Cluster.Addrs {
+			v.hostPort(fmt.Sprintf("%s.cluster.addrs.%d", path, i), addr)
+		}
+		if conf.DB != 0 {
+			v.errorf(path+".db", "a cluster only has the database 0")
+		}
+	default:
+		v.hostPort(path+".addr", conf.Addr)
+	}
+
+	if (len(conf.Sentinel.Addrs) > 0 || conf.Sentinel.Password != "") && !sentinel {
+		v.errorf(path+".sentinel.mastername", "required with the other sentinel options")
+	}
+	if (conf.TLS.Certificate == "") != (conf.TLS.Key == "") {
+		v.errorf(path+".tls", "certificate and key must be set together")
+	}
+}
+
+func (v *validator) validateRateLimit(path string, limit RateLimit) {
+	switch limit.Key {
+	case "", "ip", "user", "token":
+	default:
+		v.errorf(path+".key", "unsupported key %q, must be one of ip, user or token", limit.Key)
+	}
+	switch limit.Algorithm {
+	case "", "slidingwindow", "tokenbucket":
+	default:
+		v.errorf(path+".algorithm", "unsupported algorithm %q, must be one of slidingwindow or tokenbucket", limit.Algorithm)
+	}
+	if limit.Requests <= 0 {
+		v.errorf(path+".requests", "must be positive")
+	}
+	// the negative durations are reported by Validate.
+	if limit.Period == 0 {
+		v.errorf(path+".period", "required")
+	} else if limit.Period > 0 && limit.Period < time.Millisecond {
+		v.errorf(path+".period", "must be at least 1ms")
+	}
+	if limit.Burst < 0 {
+		v.errorf(path+".burst", "must be positive")
+	}
+}
+
+// hostPort checks that addr is of the form host:port, the host may be empty.
+func (v *validator) hostPort(path, addr string) {
+	if addr == "" {
+		v.errorf(path, "required")
+		return
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		v.errorf(path, "invalid address %q: %v", addr, err)
+		return
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		v.errorf(path, "invalid port in %q", addr)
+	}
+}
+
+// mongoURI checks the syntax of a MongoDB connection string, of the form
+// mongodb://[user:pass@]host1[:port1][,host2[:port2],...][/database][?options]
+func (v *validator) mongoURI(path, uri string) {
+	if uri == "" {
+		v.errorf(path, "required")
+		return
+	}
+	rest := strings.TrimPrefix(uri, "mongodb://")
+	if rest == uri {
+		v.errorf(path, "must start with mongodb://")
+		return
+	}
+
+	if i := strings.Index(rest, "?"); i >= 0 {
+		if _, err := url.ParseQuery(rest[i+1:]); err != nil {
+			v.errorf(path, "invalid options: %v", err)
+		}
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest = rest[:i]
+	}
+	if rest == "" {
+		v.errorf(path, "missing host")
+		return
+	}
+	for _, host := range strings.Split(rest, ",") {
+		if !strings.Contains(host, ":") {
+			continue
+		}
+		_, port, err := net.SplitHostPort(host)
+		if err != nil {
+			v.errorf(path, "invalid host %q: %v", host, err)
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			v.errorf(path, "invalid port in %q", host)
+		}
+	}
+}
@@ -64,6 +64,53 @@ func RemoteIP(r *http.Request) string {
 	return addr
 }
 
+// TrustedRemoteIP extracts the remote IP of the request, only taking into
+// account the proxy headers set by the trusted proxies. X-Forwarded-For is
+// read from the right, the client being the first address that isn't a
+// trusted proxy. With no trusted proxies, it is the peer of the connection.
+func TrustedRemoteIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 {
+		hops := strings.Split(strings.Join(prior, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrusted(ip, trusted) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := r.Header.Get("X-Real-Ip"); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// isTrusted reports whether ip belongs to one of the trusted networks.
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 // WithRequest places the request on the context. The context of the request
 // is assigned a unique id, available at "http.request.id". The request itself
 // is available at "http.request". Other common attributes are available under
@@ -0,0 +1,75 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syaiful6/thatique/health"
+)
+
+// FileChecker checks the existence of a file and returns an error
+// if the file exists.
+func FileChecker(f string) health.Checker {
+	return health.CheckFunc(func() error {
+		absoluteFilePath, err := filepath.Abs(f)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %q: %v", f, err)
+		}
+
+		_, err = os.Stat(absoluteFilePath)
+		if err == nil {
+			return errors.New("file exists")
+		} else if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// TCPChecker attempts to open a TCP connection.
+func TCPChecker(addr string, timeout time.Duration) health.Checker {
+	return health.CheckFunc(func() error {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return errors.New("connection to " + addr + " failed")
+		}
+		conn.Close()
+		return nil
+	})
+}
+
+// DiskSpaceChecker checks that the filesystem holding path has at least
+// minFree bytes available.
+func DiskSpaceChecker(path string, minFree uint64) health.Checker {
+	return health.CheckFunc(func() error {
+		free, err := diskFree(path)
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d bytes free on %s, need at least %d", free, path, minFree)
+		}
+		return nil
+	})
+}
+
+// Timeout wraps check so it fails if it doesn't complete within timeout. The
+// wrapped check keeps running in the background until it returns.
+func Timeout(check health.Checker, timeout time.Duration) health.Checker {
+	return health.CheckFunc(func() error {
+		c := make(chan error, 1)
+		go func() { c <- check.Check() }()
+
+		select {
+		case err := <-c:
+			return err
+		case <-time.After(timeout):
+			return fmt.Errorf("check timed out after %v", timeout)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package checks
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/health"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type ChecksSuite struct{}
+
+var _ = Suite(new(ChecksSuite))
+
+// TestFileChecker validates that the check fails while the file exists.
+func (suite *ChecksSuite) TestFileChecker(c *C) {
+	path := filepath.Join(c.MkDir(), "maintenance")
+	check := FileChecker(path)
+	c.Assert(check.Check(), IsNil)
+
+	c.Assert(ioutil.WriteFile(path, nil, 0600), IsNil)
+	c.Assert(check.Check(), ErrorMatches, "file exists")
+}
+
+// TestTCPChecker validates that the check fails once nothing listens on the
+// address.
+func (suite *ChecksSuite) TestTCPChecker(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	addr := l.Addr().String()
+	check := TCPChecker(addr, time.Second)
+	c.Assert(check.Check(), IsNil)
+
+	l.Close()
+	c.Assert(check.Check(), ErrorMatches, "connection to "+addr+" failed")
+}
+
+// TestDiskSpaceChecker validates that the check fails when less than the
+// minimum is free.
+func (suite *ChecksSuite) TestDiskSpaceChecker(c *C) {
+	dir := c.MkDir()
+	c.Assert(DiskSpaceChecker(dir, 0).Check(), IsNil)
+	c.Assert(DiskSpaceChecker(dir, ^uint64(0)).Check(), ErrorMatches, ".* bytes free on .*, need at least .*")
+	c.Assert(DiskSpaceChecker(filepath.Join(dir, "missing"), 0).Check(), NotNil)
+}
+
+// TestTimeout validates that a slow check is reported as failing.
+func (suite *ChecksSuite) TestTimeout(c *C) {
+	done := make(chan struct{})
+	defer close(done)
+	slow := health.CheckFunc(func() error {
+		<-done
+		return nil
+	})
+	c.Assert(Timeout(slow, 10*time.Millisecond).Check(), ErrorMatches, "check timed out after 10ms")
+
+	failing := health.CheckFunc(func() error { return os.ErrNotExist })
+	c.Assert(Timeout(failing, time.Second).Check(), Equals, os.ErrNotExist)
+}
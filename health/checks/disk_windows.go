@@ -0,0 +1,8 @@
+package checks
+
+import "errors"
+
+// diskFree is not supported on windows.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk space check is not supported on windows")
+}
@@ -0,0 +1,341 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A Registry is a collection of checks. Most applications will use the global
+// registry defined in DefaultRegistry. However, unit tests may need to create
+// separate registries to isolate themselves from other tests.
+type Registry struct {
+	mu               sync.RWMutex
+	registeredChecks map[string]Checker
+}
+
+// NewRegistry creates a new registry. This isn't necessary for normal use of
+// the package, but may be useful for unit tests so individual tests have their
+// own set of checks.
+func NewRegistry() *Registry {
+	return &Registry{
+		registeredChecks: make(map[string]Checker),
+	}
+}
+
+// DefaultRegistry is the default registry where checks are registered. It is
+// the registry used by the HTTP handler.
+var DefaultRegistry = NewRegistry()
+
+// Checker is the interface for a Health Checker
+type Checker interface {
+	// Check returns nil if the service is okay.
+	Check() error
+}
+
+// CheckFunc is a convenience type to create functions that implement
+// the Checker interface
+type CheckFunc func() error
+
+// Check Implements the Checker interface to allow for any func() error method
+// to be passed as a Checker
+func (cf CheckFunc) Check() error {
+	return cf()
+}
+
+// Updater implements a health check that is explicitly set.
+type Updater interface {
+	Checker
+
+	// Update updates the current status of the health check.
+	Update(status error)
+}
+
+// updater implements Checker and Updater, providing an asynchronous Update
+// method.
+// This allows us to have a Checker that returns the Check() call immediately
+// not blocking on a potentially expensive check.
+type updater struct {
+	mu     sync.Mutex
+	status error
+}
+
+// Check implements the Checker interface
+func (u *updater) Check() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.status
+}
+
+// Update implements the Updater interface, allowing asynchronous access to
+// the status of a Checker.
+func (u *updater) Update(status error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status = status
+}
+
+// NewStatusUpdater returns a new updater
+func NewStatusUpdater() Updater {
+	return &updater{}
+}
+
+// thresholdUpdater implements Checker and Updater, providing an asynchronous
+// Update method.
+// This allows us to have a Checker that returns the Check() call immediately
+// not blocking on a potentially expensive check.
+type thresholdUpdater struct {
+	mu        sync.Mutex
+	status    error
+	threshold int
+	count     int
+}
+
+// Check implements the Checker interface
+func (tu *thresholdUpdater) Check() error {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+
+	if tu.count >= tu.threshold {
+		return tu.status
+	}
+
+	return nil
+}
+
+// Update implements the Updater interface, allowing asynchronous access to
+// the status of a Checker.
+func (tu *thresholdUpdater) Update(status error) {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+
+	if status == nil {
+		tu.count = 0
+	} else if tu.count < tu.threshold {
+		tu.count++
+	}
+
+	tu.status = status
+}
+
+// NewThresholdStatusUpdater returns a new thresholdUpdater
+func NewThresholdStatusUpdater(t int) Updater {
+	return &thresholdUpdater{threshold: t}
+}
+
+// PeriodicChecker wraps an updater to provide a periodic checker. The check
+// stops running when ctx is done.
+func PeriodicChecker(ctx context.Context, check Checker, period time.Duration) Checker {
+	u := NewStatusUpdater()
+	go runPeriodic(ctx, check, period, u)
+	return u
+}
+
+// PeriodicThresholdChecker wraps an updater to provide a periodic checker that
+// uses a threshold before it changes status. The check stops running when ctx
+// is done.
+func PeriodicThresholdChecker(ctx context.Context, check Checker, period time.Duration, threshold int) Checker {
+	tu := NewThresholdStatusUpdater(threshold)
+	go runPeriodic(ctx, check, period, tu)
+	return tu
+}
+
+// runPeriodic updates u with the status of check every period, until ctx is
+// done.
+func runPeriodic(ctx context.Context, check Checker, period time.Duration, u Updater) {
+	u.Update(check.Check())
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			u.Update(check.Check())
+		}
+	}
+}
+
+// CheckStatus is the status of a single check.
+type CheckStatus struct {
+	// Status is either "ok" or "failing".
+	Status string `json:"status"`
+
+	// Error is the error reported by a failing check.
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the report of all the checks of a registry.
+type Status struct {
+	// Status is "ok" if every check passes, "failing" otherwise.
+	Status string `json:"status"`
+
+	Checks map[string]CheckStatus `json:"checks"`
+}
+
+// Healthy reports whether every check passes.
+func (s Status) Healthy() bool {
+	return s.Status == statusOK
+}
+
+const (
+	statusOK      = "ok"
+	statusFailing = "failing"
+)
+
+// Status runs every check of the registry and reports their status.
+func (registry *Registry) Status() Status {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	status := Status{
+		Status: statusOK,
+		Checks: make(map[string]CheckStatus, len(registry.registeredChecks)),
+	}
+	for k, v := range registry.registeredChecks {
+		if err := v.Check(); err != nil {
+			status.Status = statusFailing
+			status.Checks[k] = CheckStatus{Status: statusFailing, Error: err.Error()}
+		} else {
+			status.Checks[k] = CheckStatus{Status: statusOK}
+		}
+	}
+
+	return status
+}
+
+// Register associates the checker with the provided name.
+func (registry *Registry) Register(name string, check Checker) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	_, ok := registry.registeredChecks[name]
+	if ok {
+		panic("Check already exists: " + name)
+	}
+	registry.registeredChecks[name] = check
+}
+
+// Register associates the checker with the provided name in the default
+// registry.
+func Register(name string, check Checker) {
+	DefaultRegistry.Register(name, check)
+}
+
+// RegisterFunc allows the convenience of registering a checker directly from
+// an arbitrary func() error. The check runs on demand, every time the status
+// is requested.
+func (registry *Registry) RegisterFunc(name string, check func() error) {
+	registry.Register(name, CheckFunc(check))
+}
+
+// RegisterFunc allows the convenience of registering a checker in the default
+// registry directly from an arbitrary func() error.
+func RegisterFunc(name string, check func() error) {
+	DefaultRegistry.RegisterFunc(name, check)
+}
+
+// RegisterPeriodicFunc allows the convenience of registering a PeriodicChecker
+// from an arbitrary func() error, running until ctx is done.
+func (registry *Registry) RegisterPeriodicFunc(ctx context.Context, name string, period time.Duration, check CheckFunc) {
+	registry.Register(name, PeriodicChecker(ctx, check, period))
+}
+
+// RegisterPeriodicFunc allows the convenience of registering a PeriodicChecker
+// in the default registry from an arbitrary func() error, running until ctx is
+// done.
+func RegisterPeriodicFunc(ctx context.Context, name string, period time.Duration, check CheckFunc) {
+	DefaultRegistry.RegisterPeriodicFunc(ctx, name, period, check)
+}
+
+// RegisterPeriodicThresholdFunc allows the convenience of registering a
+// PeriodicChecker from an arbitrary func() error, running until ctx is done.
+func (registry *Registry) RegisterPeriodicThresholdFunc(ctx context.Context, name string, period time.Duration, threshold int, check CheckFunc) {
+	registry.Register(name, PeriodicThresholdChecker(ctx, check, period, threshold))
+}
+
+// RegisterPeriodicThresholdFunc allows the convenience of registering a
+// PeriodicChecker in the default registry from an arbitrary func() error,
+// running until ctx is done.
+func RegisterPeriodicThresholdFunc(ctx context.Context, name string, period time.Duration, threshold int, check CheckFunc) {
+	DefaultRegistry.RegisterPeriodicThresholdFunc(ctx, name, period, threshold, check)
+}
+
+// StatusHandler returns a JSON blob with the status of every check of the
+// default registry. It responds with 503 Service Unavailable if any check
+// fails.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.StatusHandler(w, r)
+}
+
+// StatusHandler returns a JSON blob with the status of every check of the
+// registry. It responds with 503 Service Unavailable if any check fails.
+func (registry *Registry) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := registry.Status()
+	code := http.StatusOK
+	if !status.Healthy() {
+		code = http.StatusServiceUnavailable
+	}
+
+	statusResponse(w, r, code, status)
+}
+
+// Handler returns a handler that will return 503 response code if the health
+// checks of the default registry have failed. If everything is okay with the
+// health checks, the handler will pass through to the provided handler. Use
+// this handler to disable a web application when the health checks fail.
+func Handler(handler http.Handler) http.Handler {
+	return DefaultRegistry.Handler(handler)
+}
+
+// Handler returns a handler that will return 503 response code if the health
+// checks of the registry have failed.
+func (registry *Registry) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := registry.Status()
+		if !status.Healthy() {
+			statusResponse(w, r, http.StatusServiceUnavailable, status)
+			return // return early
+		}
+
+		handler.ServeHTTP(w, r) // pass through
+	})
+}
+
+// statusResponse completes the request with a response describing the health
+// of the service.
+func statusResponse(w http.ResponseWriter, r *http.Request, status int, checks Status) {
+	p, err := json.Marshal(checks)
+	if err != nil {
+		logrus.Errorf("error serializing health status: %v", err)
+		p, err = json.Marshal(struct {
+			ServerError string `json:"server_error"`
+		}{
+			ServerError: "Could not parse error message",
+		})
+		status = http.StatusInternalServerError
+
+		if err != nil {
+			logrus.Errorf("error serializing health status failure message: %v", err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+	w.WriteHeader(status)
+	if _, err := w.Write(p); err != nil {
+		logrus.Errorf("error writing health status response body: %v", err)
+	}
+}
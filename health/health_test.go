@@ -0,0 +1,146 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/health"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type HealthSuite struct {
+	registry *health.Registry
+}
+
+var _ = Suite(new(HealthSuite))
+
+func (suite *HealthSuite) SetUpTest(c *C) {
+	suite.registry = health.NewRegistry()
+}
+
+// status requests the status of the registry and decodes the response.
+func (suite *HealthSuite) status(c *C, method string) (int, health.Status) {
+	w := httptest.NewRecorder()
+	suite.registry.StatusHandler(w, httptest.NewRequest(method, "/debug/health", nil))
+
+	var status health.Status
+	if w.Code != http.StatusMethodNotAllowed {
+		c.Assert(w.Header().Get("Content-Type"), Equals, "application/json; charset=utf-8")
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &status), IsNil)
+	}
+	return w.Code, status
+}
+
+// TestEmpty validates that a registry without checks is healthy.
+func (suite *HealthSuite) TestEmpty(c *C) {
+	code, status := suite.status(c, "GET")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(status.Status, Equals, "ok")
+	c.Assert(status.Checks, HasLen, 0)
+}
+
+// TestStatus validates that each check is reported, and that a single
+// failing check makes the registry unhealthy.
+func (suite *HealthSuite) TestStatus(c *C) {
+	suite.registry.RegisterFunc("redis", func() error { return nil })
+	code, status := suite.status(c, "GET")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(status.Checks["redis"], Equals, health.CheckStatus{Status: "ok"})
+
+	suite.registry.RegisterFunc("mongodb", func() error { return errors.New("no reachable servers") })
+	code, status = suite.status(c, "GET")
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+	c.Assert(status.Status, Equals, "failing")
+	c.Assert(status.Checks["redis"], Equals, health.CheckStatus{Status: "ok"})
+	c.Assert(status.Checks["mongodb"], Equals, health.CheckStatus{Status: "failing", Error: "no reachable servers"})
+}
+
+// TestMethod validates that only GET and HEAD are served.
+func (suite *HealthSuite) TestMethod(c *C) {
+	code, _ := suite.status(c, "HEAD")
+	c.Assert(code, Equals, http.StatusOK)
+	code, _ = suite.status(c, "POST")
+	c.Assert(code, Equals, http.StatusMethodNotAllowed)
+}
+
+// TestRegisterTwice validates that a name can't be registered twice in a
+// registry, but can in different registries.
+func (suite *HealthSuite) TestRegisterTwice(c *C) {
+	check := func() error { return nil }
+	suite.registry.RegisterFunc("redis", check)
+	c.Assert(func() { suite.registry.RegisterFunc("redis", check) }, PanicMatches, "Check already exists: redis")
+	health.NewRegistry().RegisterFunc("redis", check)
+}
+
+// TestHandler validates that the requests only pass through while the
+// registry is healthy.
+func (suite *HealthSuite) TestHandler(c *C) {
+	var status error
+	suite.registry.RegisterFunc("redis", func() error { return status })
+	handler := suite.registry.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+
+	status = errors.New("down")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+}
+
+// TestThreshold validates that a threshold updater only fails after the
+// given number of consecutive failures.
+func (suite *HealthSuite) TestThreshold(c *C) {
+	u := health.NewThresholdStatusUpdater(2)
+	down := errors.New("down")
+
+	u.Update(down)
+	c.Assert(u.Check(), IsNil)
+	u.Update(down)
+	c.Assert(u.Check(), Equals, down)
+	u.Update(down)
+	c.Assert(u.Check(), Equals, down)
+
+	// a success resets the count.
+	u.Update(nil)
+	c.Assert(u.Check(), IsNil)
+	u.Update(down)
+	c.Assert(u.Check(), IsNil)
+}
+
+// TestPeriodicStop validates that a periodic check stops running once its
+// context is done.
+func (suite *HealthSuite) TestPeriodicStop(c *C) {
+	var runs int32
+	down := errors.New("down")
+	ctx, cancel := context.WithCancel(context.Background())
+	check := health.PeriodicThresholdChecker(ctx, health.CheckFunc(func() error {
+		atomic.AddInt32(&runs, 1)
+		return down
+	}), time.Millisecond, 2)
+
+	for atomic.LoadInt32(&runs) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(check.Check(), Equals, down)
+
+	cancel()
+	// a check may be running while the context is canceled.
+	time.Sleep(10 * time.Millisecond)
+	stopped := atomic.LoadInt32(&runs)
+	time.Sleep(20 * time.Millisecond)
+	c.Assert(atomic.LoadInt32(&runs), Equals, stopped)
+}
@@ -1,10 +1,36 @@
 package errcode
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 )
 
+// ErrorWriter writes err as the response of r. The application installs one
+// on the request context, so that the middleware that doesn't know how the
+// errors are presented, such as the authentication and CSRF checks, answers
+// like the handlers do.
+type ErrorWriter func(w http.ResponseWriter, r *http.Request, err error)
+
+type errorWriterKey struct{}
+
+func (errorWriterKey) String() string { return "errcode.writer" }
+
+// WithErrorWriter returns a context whose errors are written by writer.
+func WithErrorWriter(ctx context.Context, writer ErrorWriter) context.Context {
+	return context.WithValue(ctx, errorWriterKey{}, writer)
+}
+
+// ServeError writes err as the response of r, with the ErrorWriter of the
+// request context or with ServeJSON if there is none.
+func ServeError(w http.ResponseWriter, r *http.Request, err error) {
+	if writer, ok := r.Context().Value(errorWriterKey{}).(ErrorWriter); ok {
+		writer(w, r, err)
+		return
+	}
+	ServeJSON(w, err)
+}
+
 // ServeJSON attempts to serve the errcode in a JSON envelope. It marshals err
 // and sets the content-type header to 'application/json'. It will handle
 // ErrorCoder and Errors, and if necessary will create an envelope.
@@ -0,0 +1,212 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/syaiful6/thatique/shop/api/errcode"
+)
+
+const errGroup = "shop.api.v1"
+
+var (
+	// ErrorCodeBodyMalformed is returned when the request body can't be
+	// decoded.
+	ErrorCodeBodyMalformed = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "BODY_MALFORMED",
+		Message: "malformed request body",
+		Description: `The request body could not be decoded, it is either
+		not valid JSON or doesn't have the expected structure.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeEmailInvalid is returned when the given email address isn't
+	// valid.
+	ErrorCodeEmailInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:          "EMAIL_INVALID",
+		Message:        "invalid email address",
+		Description:    `The provided email address is empty or malformed.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodePasswordInvalid is returned when the given password doesn't
+	// meet the requirements.
+	ErrorCodePasswordInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PASSWORD_INVALID",
+		Message: "password must be at least %d characters",
+		Description: `The provided password is too short to be accepted
+		for an account.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeEmailExists is returned on signup when the email address is
+	// already registered.
+	ErrorCodeEmailExists = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "EMAIL_EXISTS",
+		Message: "email address already registered",
+		Description: `An account with the provided email address already
+		exists.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeCredentialsInvalid is returned on login when the email and
+	// password don't match any account.
+	ErrorCodeCredentialsInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "CREDENTIALS_INVALID",
+		Message: "invalid email or password",
+		Description: `The provided email and password don't match any
+		account.`,
+		HTTPStatusCode: http.StatusUnauthorized,
+	})
+
+	// ErrorCodeTokenInvalid is returned when a verification or reset token
+	// is malformed, expired or was already used.
+	ErrorCodeTokenInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TOKEN_INVALID",
+		Message: "invalid or expired token",
+		Description: `The provided token is malformed, its signature doesn't
+		match, it has expired or it was already used.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeEmailUnverified is returned when an unverified user attempts
+	// an action that requires a verified email address.
+	ErrorCodeEmailUnverified = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "EMAIL_UNVERIFIED",
+		Message: "email address is not verified",
+		Description: `The requested action requires the user to verify the
+		email address first.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeAccountDisabled is returned on login when the account was
+	// disabled by an administrator.
+	ErrorCodeAccountDisabled = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ACCOUNT_DISABLED",
+		Message: "account is disabled",
+		Description: `The account exists but was disabled by an
+		administrator, it can't be used to log in.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeCSRFInvalid is returned when an unsafe request fails the
+	// cross-site request forgery checks.
+	ErrorCodeCSRFInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "CSRF_INVALID",
+		Message: "CSRF verification failed",
+		Description: `The request changes the state but its CSRF token is
+		missing or invalid, or it comes from another origin. The token is
+		sent in the csrf_token form field or the X-CSRF-Token header.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeFieldInvalid is returned when a field of the request body
+	// has an invalid value, the detail tells why.
+	ErrorCodeFieldInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "FIELD_INVALID",
+		Message: "invalid %s",
+		Description: `A field of the request body is missing or has an
+		invalid value. The message names the field, the detail tells what
+		is expected.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeSlugInvalid is returned when the given slug isn't valid.
+	ErrorCodeSlugInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SLUG_INVALID",
+		Message: "invalid slug",
+		Description: `The slug must be 3 to 50 lowercase letters, digits and
+		single dashes, starting and ending with a letter or a digit, and not
+		be a reserved word.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeSlugExists is returned when the given slug is taken.
+	ErrorCodeSlugExists = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:          "SLUG_EXISTS",
+		Message:        "slug already taken",
+		Description:    `The provided slug is used by another resource.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeStoreNotFound is returned when the requested store doesn't
+	// exist, or isn't visible to the user.
+	ErrorCodeStoreNotFound = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "STORE_NOT_FOUND",
+		Message: "store not found",
+		Description: `The store doesn't exist, or it isn't active and the
+		user doesn't own it.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeProductNotFound is returned when the requested product
+	// doesn't exist, or isn't visible to the user.
+	ErrorCodeProductNotFound = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PRODUCT_NOT_FOUND",
+		Message: "product not found",
+		Description: `The product doesn't exist in the store, or it isn't
+		published and the user doesn't own the store.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeSKUExists is returned when a SKU of a product is used by
+	// another product of the store.
+	ErrorCodeSKUExists = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SKU_EXISTS",
+		Message: "SKU already used",
+		Description: `A SKU of the product is used by another product of the
+		store, the SKUs identify the variants within a store.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeServiceNotFound is returned when the requested service
+	// doesn't exist, or isn't visible to the user.
+	ErrorCodeServiceNotFound = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SERVICE_NOT_FOUND",
+		Message: "service not found",
+		Description: `The service doesn't exist in the store, or it isn't
+		published and the user doesn't own the store.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeBookingNotFound is returned when the requested booking
+	// doesn't exist, or the user is neither its customer nor manages its
+	// store.
+	ErrorCodeBookingNotFound = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "BOOKING_NOT_FOUND",
+		Message: "booking not found",
+		Description: `The booking doesn't exist, or the user didn't make it
+		and doesn't own the store of the service.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeSlotUnavailable is returned when the requested slot can't be
+	// booked.
+	ErrorCodeSlotUnavailable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SLOT_UNAVAILABLE",
+		Message: "slot unavailable",
+		Description: `The requested start isn't a slot of the service, it is
+		in the past, or another booking holds it.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeTooManyPendingBookings is returned when the customer has
+	// too many bookings waiting for the stores to confirm them.
+	ErrorCodeTooManyPendingBookings = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TOO_MANY_PENDING_BOOKINGS",
+		Message: "too many pending bookings",
+		Description: `The customer has the maximum number of upcoming
+		bookings pending, another can be made once the stores confirm or
+		cancel them.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeBookingState is returned when a booking can't change to the
+	// requested state.
+	ErrorCodeBookingState = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "BOOKING_STATE_INVALID",
+		Message: "booking can't be changed",
+		Description: `The booking is cancelled, its slot has begun, or it
+		was changed by another request in the meantime.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+)
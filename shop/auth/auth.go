@@ -2,40 +2,51 @@ package auth
 
 import (
 	"context"
-	"fmt"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/http"
 
-	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/data/user"
+	"github.com/syaiful6/thatique/shop/sessions"
 )
 
 const (
-	sessionName = "auth.session"
-
 	userSessionKey = "auth.session.userKey"
 
+	// hashSessionKey stores a digest of the password hash the user logged in
+	// with. Changing the password invalidates every existing session.
+	hashSessionKey = "auth.session.hashKey"
+
 	// UserKey is used to get the user object from
 	// a user context
 	UserKey = "auth.user"
 
+	// UserIdKey is used to get the user id, as hex string, from a user
+	// context
 	UserIdKey = "auth.user.id"
 )
 
-type UserInfo struct {
-	Id   string
-}
+// ErrUserNotFound is returned by UserStore when the requested user doesn't
+// exist.
+var ErrUserNotFound = errors.New("auth: user not found")
 
-func WithUser(ctx context.Context, user UserInfo) context.Context {
+// WithUser returns a context with the authorized user info.
+func WithUser(ctx context.Context, user *user.User) context.Context {
 	return userInfoContext{
 		Context: ctx,
-		user: user,
+		user:    user,
 	}
 }
 
 type userInfoContext struct {
 	context.Context
 
-	user UserInfo
+	user *user.User
 }
 
 func (uic userInfoContext) Value(key interface{}) interface{} {
@@ -43,93 +54,206 @@ func (uic userInfoContext) Value(key interface{}) interface{} {
 	case UserKey:
 		return uic.user
 	case UserIdKey:
-		return uic.user.Id
+		if uic.user == nil {
+			return nil
+		}
+		return uic.user.Id.Hex()
 	}
 
 	return uic.Context.Value(key)
 }
 
-// authenticator
+// GetUser returns the user attached to the context, or nil if the request
+// isn't authenticated.
+func GetUser(ctx context.Context) *user.User {
+	u, _ := ctx.Value(UserKey).(*user.User)
+	return u
+}
+
+// Authenticator keeps track of the logged in user with the session.
 type Authenticator struct {
-	storage sessions.Storage
+	storage     sessions.Store
+	sessionName string
+	users       UserStore
+}
+
+// NewAuthenticator returns an Authenticator that keeps the user id in the
+// session named sessionName of storage and resolves it through users.
+func NewAuthenticator(storage sessions.Store, sessionName string, users UserStore) *Authenticator {
+	return &Authenticator{
+		storage:     storage,
+		sessionName: sessionName,
+		users:       users,
+	}
 }
 
 // Middleware that load user from session and set it current user if success
 func (a *Authenticator) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
-		userInfo, err := a.getUserFromSession(r)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := a.getUserFromSession(r)
 		if err != nil {
+			scontext.GetLogger(r.Context()).Warnf("error loading user from session: %v", err)
+		}
+		if u == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, a.LoginOnce(userInfo, r))
+		next.ServeHTTP(w, a.LoginOnce(u, r))
 	})
 }
 
-// get current user if any, this function may return nil
-func (a *Authenticator) User(r *http.Request) UserInfo {
-	u, ok := r.Context().Value(UserKey).(UserInfo)
-	if !ok {
-		return nil
-	}
-	return u
+// User returns the current user if any, this function may return nil
+func (a *Authenticator) User(r *http.Request) *user.User {
+	return GetUser(r.Context())
 }
 
-// Set user only to current request without persisting to session store
-func (a *Authenticator) LoginOnce(u auth.UserInfo, r *http.Request) *http.Request {
+// LoginOnce sets user only to current request without persisting to session
+// store
+func (a *Authenticator) LoginOnce(u *user.User, r *http.Request) *http.Request {
 	return r.WithContext(WithUser(r.Context(), u))
 }
 
-// login user to application, return http.Request that can be passed to next http.Handler
-// so that user visible.
-func (a *Authenticator) Login(u auth.UserInfo, w http.ResponseWriter, r *http.Request) (*http.Request, error) {
-	sess, err := a.storage.Get(r, sessionName)
-	if err != nil {
+// Login logs the user in to the application, return http.Request that can be
+// passed to next http.Handler so that user visible. The session is given a
+// new id to prevent session fixation.
+func (a *Authenticator) Login(u *user.User, w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	sess, err := a.storage.Get(r, a.sessionName)
+	if err != nil && sess == nil {
 		return nil, err
 	}
 
+	if sess.Options.MaxAge < 0 {
+		// the session was revoked earlier in the request, the new one gets
+		// the options of the store back.
+		fresh, err := a.storage.New(r, a.sessionName)
+		if fresh == nil {
+			return nil, err
+		}
+		sess.Options = fresh.Options
+	}
+
 	// save the user id to session
-	sess.Values[userSessionKey] = u.Id
-	if err = sess.Save(r, w); err != nil {
+	sess.Values[userSessionKey] = u.Id.Hex()
+	sess.Values[hashSessionKey] = sessionHash(u)
+	if err = sess.Regenerate(r, w); err != nil {
 		return nil, err
 	}
 
 	return a.LoginOnce(u, r), nil
 }
 
-// logout user from application, remove userInfoContext if it can
+// Logout logs the user out of the application. The whole session is removed
+// from the store so it can't be reused by anyone holding the cookie.
 func (a *Authenticator) Logout(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
-	sess, err := a.storage.Get(r, sessionName)
-	if err != nil {
+	sess, err := a.storage.Get(r, a.sessionName)
+	if err != nil && sess == nil {
 		return nil, err
 	}
 
-	delete(sess.Values, userSessionKey)
-	if err = sess.Save(r, w); err != nil {
+	if err = sess.Delete(r, w); err != nil {
 		return nil, err
 	}
 
-	// remove user from context
-	ctx, ok := r.Context().(userInfoContext)
-	if !ok {
-		return r, nil
+	// hide the user from the rest of the request
+	return r.WithContext(WithUser(r.Context(), nil)), nil
+}
+
+func (a *Authenticator) getUserFromSession(r *http.Request) (*user.User, error) {
+	sess, err := a.storage.Get(r, a.sessionName)
+	if err != nil {
+		return nil, err
 	}
 
-	return r.WithContext(ctx.Context), nil
-}
+	uid, ok := sess.Values[userSessionKey].(string)
+	if !ok {
+		// user logged out
+		return nil, nil
+	}
 
-func (a *Authenticator) getUserFromSession(r *http.Request) (UserInfo, error) {
-	sess, err := a.storage.Get(r, sessionName)
+	u, err := a.users.FindByID(r.Context(), uid)
+	if err == ErrUserNotFound || (err == nil && u.Disabled) {
+		// the user was removed or disabled while logged in, the session
+		// is no longer valid.
+		return nil, a.revoke(sess)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if uid, ok := sess.Values[userSessionKey]; !ok {
-		return nil, fmt.Error("User logged out")
+
+	hash, _ := sess.Values[hashSessionKey].(string)
+	if !hmac.Equal([]byte(hash), []byte(sessionHash(u))) {
+		// the password changed since this session logged in, the session
+		// is no longer valid.
+		return nil, a.revoke(sess)
 	}
 
-	if uid, ok = uid.(string); !ok {
-		return nil, fmt.Error("invalid user session value")
+	return u, nil
+}
+
+// revoke removes the session from the store and empties it. Like
+// sessions.Session.Delete, it expires the cookie, so that saving the session
+// later in the request doesn't write it back under its revoked ID.
+func (a *Authenticator) revoke(sess *sessions.Session) error {
+	id := sess.ID
+	sess.ID = ""
+	sess.Options.MaxAge = -1
+	for k := range sess.Values {
+		delete(sess.Values, k)
 	}
+	return a.storage.Delete(id)
+}
+
+// sessionHash returns the digest of the user's password hash kept in the
+// session.
+func sessionHash(u *user.User) string {
+	sum := sha256.Sum256([]byte(hashSessionKey + u.Password))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireLogin responds with errcode.ErrorCodeUnauthorized to requests that
+// aren't authenticated.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetUser(r.Context()) == nil {
+			errcode.ServeError(w, r, errcode.ErrorCodeUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	return UserInfo{Id: uid,}, nil
+// RequireStaff responds with errcode.ErrorCodeUnauthorized to requests that
+// aren't authenticated and errcode.ErrorCodeDenied to users that aren't staff
+// nor superuser.
+func RequireStaff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := GetUser(r.Context())
+		if u == nil {
+			errcode.ServeError(w, r, errcode.ErrorCodeUnauthorized)
+			return
+		}
+		if !u.IsStaff() {
+			errcode.ServeError(w, r, errcode.ErrorCodeDenied)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerified responds with errcode.ErrorCodeUnauthorized to requests
+// that aren't authenticated and v1.ErrorCodeEmailUnverified to users that
+// haven't verified their email address.
+func RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := GetUser(r.Context())
+		if u == nil {
+			errcode.ServeError(w, r, errcode.ErrorCodeUnauthorized)
+			return
+		}
+		if !u.Verified {
+			errcode.ServeError(w, r, v1.ErrorCodeEmailUnverified)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
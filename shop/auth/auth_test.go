@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/user"
+	"github.com/syaiful6/thatique/shop/sessions"
+)
+
+// memoryStore serves a single session to every request, recording the ids
+// deleted.
+type memoryStore struct {
+	session *sessions.Session
+	deleted []string
+}
+
+func (s *memoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.session, nil
+}
+
+func (s *memoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.NewSession(s, name), nil
+}
+
+func (s *memoryStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+// memoryUsers is a UserStore of a fixed set of users.
+type memoryUsers map[string]*user.User
+
+func (m memoryUsers) FindByID(ctx context.Context, id string) (*user.User, error) {
+	u, ok := m[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+type AuthSuite struct {
+	store *memoryStore
+	user  *user.User
+	auth  *Authenticator
+}
+
+var _ = Suite(new(AuthSuite))
+
+func (suite *AuthSuite) SetUpTest(c *C) {
+	suite.store = new(memoryStore)
+	suite.store.session = sessions.NewSession(suite.store, "session")
+	suite.store.session.ID = "sid"
+	suite.user = &user.User{Id: bson.NewObjectId(), Email: "user@example.com", Password: "hash"}
+	suite.auth = NewAuthenticator(suite.store, "session", memoryUsers{suite.user.Id.Hex(): suite.user})
+
+	suite.store.session.Values[userSessionKey] = suite.user.Id.Hex()
+	suite.store.session.Values[hashSessionKey] = sessionHash(suite.user)
+}
+
+// current runs a request through the middleware and returns the user it
+// resolved.
+func (suite *AuthSuite) current() *user.User {
+	var u *user.User
+	suite.auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u = GetUser(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	return u
+}
+
+// TestMiddleware validates that the user of the session is resolved.
+func (suite *AuthSuite) TestMiddleware(c *C) {
+	c.Assert(suite.current(), Equals, suite.user)
+	c.Assert(suite.store.deleted, HasLen, 0)
+}
+
+// TestDisabled validates that the session of a user disabled while logged in
+// is removed.
+func (suite *AuthSuite) TestDisabled(c *C) {
+	suite.user.Disabled = true
+	c.Assert(suite.current(), IsNil)
+	c.Assert(suite.store.session.Values, HasLen, 0)
+	c.Assert(suite.store.session.ID, Equals, "")
+	c.Assert(suite.store.session.Options.MaxAge, Equals, -1)
+	c.Assert(suite.store.deleted, DeepEquals, []string{"sid"})
+}
+
+// TestPasswordChanged validates that the sessions opened before a password
+// change are removed.
+func (suite *AuthSuite) TestPasswordChanged(c *C) {
+	suite.user.Password = "other"
+	c.Assert(suite.current(), IsNil)
+	c.Assert(suite.store.session.Values, HasLen, 0)
+	c.Assert(suite.store.deleted, DeepEquals, []string{"sid"})
+}
+
+// TestLoginAfterRevoked validates that logging in again, in the request that
+// revoked the session, doesn't expire the new session.
+func (suite *AuthSuite) TestLoginAfterRevoked(c *C) {
+	suite.user.Password = "other"
+	r := httptest.NewRequest("POST", "/auth/login", nil)
+	u, err := suite.auth.getUserFromSession(r)
+	c.Assert(err, IsNil)
+	c.Assert(u, IsNil)
+	c.Assert(suite.store.session.Options.MaxAge, Equals, -1)
+
+	_, err = suite.auth.Login(suite.user, httptest.NewRecorder(), r)
+	c.Assert(err, IsNil)
+	c.Assert(suite.store.session.Options.MaxAge >= 0, Equals, true)
+	c.Assert(suite.store.session.Values[hashSessionKey], Equals, sessionHash(suite.user))
+}
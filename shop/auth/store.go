@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// UserStore looks up the users that can be authenticated.
+type UserStore interface {
+	// FindByID returns the user with the given hex encoded id, or
+	// ErrUserNotFound.
+	FindByID(ctx context.Context, id string) (*user.User, error)
+}
+
+// MongoUserStore is a UserStore backed by the MongoDB users collection.
+type MongoUserStore struct {
+	users *user.Repository
+}
+
+// NewMongoUserStore returns a UserStore reading users from the repository.
+func NewMongoUserStore(users *user.Repository) *MongoUserStore {
+	return &MongoUserStore{users: users}
+}
+
+// FindByID implements UserStore.
+func (s *MongoUserStore) FindByID(ctx context.Context, id string) (*user.User, error) {
+	u, err := s.users.FindByID(ctx, id)
+	if err == user.ErrNotFound {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
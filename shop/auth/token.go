@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+var (
+	// ErrTokenInvalid is returned when a token is malformed or its
+	// signature doesn't match.
+	ErrTokenInvalid = errors.New("auth: invalid token")
+
+	// ErrTokenExpired is returned when a token is past its expiry.
+	ErrTokenExpired = errors.New("auth: token expired")
+)
+
+// TokenGenerator makes and checks HMAC signed tokens bound to a user. Besides
+// the user id and an expiry, the signature covers a state derived from the
+// user, so a token becomes invalid as soon as that state changes. This makes
+// the tokens single use without storing them.
+type TokenGenerator struct {
+	secret  []byte
+	purpose string
+	timeout time.Duration
+	state   func(*user.User) string
+}
+
+// NewTokenGenerator returns a TokenGenerator signing with secret. The purpose
+// is mixed in the signature so tokens generated for one purpose can't be used
+// for another. Tokens expire after timeout.
+func NewTokenGenerator(secret, purpose string, timeout time.Duration, state func(*user.User) string) *TokenGenerator {
+	return &TokenGenerator{
+		secret:  []byte(secret),
+		purpose: purpose,
+		timeout: timeout,
+		state:   state,
+	}
+}
+
+// Make returns a token for the user u.
+func (g *TokenGenerator) Make(u *user.User) string {
+	return g.makeWithExpiry(u, time.Now().Add(g.timeout).Unix())
+}
+
+func (g *TokenGenerator) makeWithExpiry(u *user.User, expiry int64) string {
+	payload := u.Id.Hex() + "." + strconv.FormatInt(expiry, 36)
+	return payload + "." + g.sign(u, payload)
+}
+
+// UserID returns the hex encoded id of the user the token was made for. The
+// token is not verified.
+func (g *TokenGenerator) UserID(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !bson.IsObjectIdHex(parts[0]) {
+		return "", ErrTokenInvalid
+	}
+	return parts[0], nil
+}
+
+// Check verifies that token was made for u, in its current state, and is
+// not expired.
+func (g *TokenGenerator) Check(u *user.User, token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != u.Id.Hex() {
+		return ErrTokenInvalid
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+
+	expected := g.sign(u, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return ErrTokenInvalid
+	}
+
+	if time.Now().Unix() > expiry {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+func (g *TokenGenerator) sign(u *user.User, payload string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(g.purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(g.state(u)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type TokenSuite struct {
+	user      *user.User
+	generator *TokenGenerator
+}
+
+var _ = Suite(new(TokenSuite))
+
+func (suite *TokenSuite) SetUpTest(c *C) {
+	suite.user = &user.User{
+		Id:    bson.NewObjectId(),
+		Email: "user@example.com",
+	}
+	suite.generator = NewTokenGenerator("secret", "test", time.Hour, func(u *user.User) string {
+		return u.Email
+	})
+}
+
+// TestRoundtrip validates that a fresh token is accepted and carries the
+// user id.
+func (suite *TokenSuite) TestRoundtrip(c *C) {
+	token := suite.generator.Make(suite.user)
+
+	uid, err := suite.generator.UserID(token)
+	c.Assert(err, IsNil)
+	c.Assert(uid, Equals, suite.user.Id.Hex())
+	c.Assert(suite.generator.Check(suite.user, token), IsNil)
+}
+
+// TestStateChange validates that a token is rejected once the user state it
+// was made with changes.
+func (suite *TokenSuite) TestStateChange(c *C) {
+	token := suite.generator.Make(suite.user)
+	suite.user.Email = "other@example.com"
+
+	c.Assert(suite.generator.Check(suite.user, token), Equals, ErrTokenInvalid)
+}
+
+// TestExpired validates that expired tokens are rejected.
+func (suite *TokenSuite) TestExpired(c *C) {
+	token := suite.generator.makeWithExpiry(suite.user, time.Now().Add(-time.Minute).Unix())
+
+	c.Assert(suite.generator.Check(suite.user, token), Equals, ErrTokenExpired)
+}
+
+// TestTampered validates that tokens signed with another secret, made for
+// another purpose or for another user are rejected.
+func (suite *TokenSuite) TestTampered(c *C) {
+	other := NewTokenGenerator("other", "test", time.Hour, func(u *user.User) string {
+		return u.Email
+	})
+	c.Assert(suite.generator.Check(suite.user, other.Make(suite.user)), Equals, ErrTokenInvalid)
+
+	purpose := NewTokenGenerator("secret", "other", time.Hour, func(u *user.User) string {
+		return u.Email
+	})
+	c.Assert(suite.generator.Check(suite.user, purpose.Make(suite.user)), Equals, ErrTokenInvalid)
+
+	another := &user.User{Id: bson.NewObjectId(), Email: suite.user.Email}
+	c.Assert(suite.generator.Check(suite.user, suite.generator.Make(another)), Equals, ErrTokenInvalid)
+
+	c.Assert(suite.generator.Check(suite.user, "garbage"), Equals, ErrTokenInvalid)
+}
@@ -0,0 +1,294 @@
+// Package csrf protects the unsafe requests, those changing the state, from
+// cross-site request forgery. Each session holds a random secret, the tokens
+// handed to the forms and the API clients are signed from it with the HTTP
+// secret, and masked with fresh random bytes so they differ on every response
+// and don't leak through compression (BREACH).
+//
+// The unsafe requests must carry a token, in the csrf_token form field or the
+// X-CSRF-Token header, and come from the same origin as the shop.
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/sessions"
+)
+
+const (
+	// FieldName is the name of the form field carrying the token.
+	FieldName = "csrf_token"
+
+	// HeaderName is the name of the header carrying the token.
+	HeaderName = "X-CSRF-Token"
+
+	// secretSessionKey stores the secret of the session, hex encoded.
+	secretSessionKey = "csrf.secret"
+
+	// secretSize is the number of random bytes of the session secrets and
+	// of the masks.
+	secretSize = 32
+)
+
+var (
+	// ErrTokenMissing is returned when an unsafe request has no token.
+	ErrTokenMissing = errors.New("CSRF token missing")
+
+	// ErrTokenInvalid is returned when the token of an unsafe request is
+	// malformed or wasn't issued for its session.
+	ErrTokenInvalid = errors.New("CSRF token invalid")
+
+	// ErrOriginInvalid is returned when the Origin of an unsafe request is
+	// neither the shop nor a trusted origin.
+	ErrOriginInvalid = errors.New("origin not allowed")
+
+	// ErrRefererInvalid is returned when the Referer of an unsafe request,
+	// sent without Origin, is neither the shop nor a trusted origin.
+	ErrRefererInvalid = errors.New("referer not allowed")
+
+	// ErrRefererMissing is returned when an unsafe HTTPS request has
+	// neither Origin nor Referer.
+	ErrRefererMissing = errors.New("referer missing")
+)
+
+// Protector issues the tokens of the sessions and checks the unsafe requests.
+type Protector struct {
+	storage     sessions.Store
+	sessionName string
+	secret      []byte
+
+	// origin is the origin of the configured host, requests are compared
+	// to their own host if it's empty.
+	origin string
+
+	// trusted holds the other origins allowed to send unsafe requests.
+	trusted map[string]bool
+
+	// exempt holds the names of the routes that aren't checked.
+	exempt map[string]bool
+}
+
+// New returns a Protector keeping the secrets in the session named
+// sessionName of storage and signing the tokens with secret. The host is the
+// externally reachable URL of the shop, the origin the requests are expected
+// from. The trustedOrigins, such as https://admin.example.com, are allowed as
+// well.
+func New(storage sessions.Store, sessionName, secret, host string, trustedOrigins []string) *Protector {
+	p := &Protector{
+		storage:     storage,
+		sessionName: sessionName,
+		secret:      []byte(secret),
+		trusted:     make(map[string]bool),
+		exempt:      make(map[string]bool),
+	}
+	if host != "" {
+		p.origin = originOf(host)
+	}
+	for _, origin := range trustedOrigins {
+		p.trusted[originOf(origin)] = true
+	}
+	return p
+}
+
+// Exempt disables the checks of the named routes, such as the webhooks and
+// the API endpoints authenticated by a token rather than the session. It
+// must be called before serving.
+func (p *Protector) Exempt(names ...string) {
+	for _, name := range names {
+		p.exempt[name] = true
+	}
+}
+
+// Middleware rejects the unsafe requests failing the checks with
+// v1.ErrorCodeCSRFInvalid, unless their route is exempted.
+func (p *Protector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Safe(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && p.exempt[route.GetName()] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := p.Check(r); err != nil {
+			scontext.GetLogger(r.Context()).Infof("CSRF check failed: %v", err)
+			errcode.ServeError(w, r, v1.ErrorCodeCSRFInvalid.WithDetail(err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check verifies the origin and the token of the request r.
+func (p *Protector) Check(r *http.Request) error {
+	if err := p.checkOrigin(r); err != nil {
+		return err
+	}
+
+	token := r.Header.Get(HeaderName)
+	if token == "" {
+		token = r.PostFormValue(FieldName)
+	}
+	if token == "" {
+		return ErrTokenMissing
+	}
+
+	sess, err := p.storage.Get(r, p.sessionName)
+	if err != nil {
+		// the session is lost, so is its secret.
+		return ErrTokenInvalid
+	}
+	return p.checkToken(sess, token)
+}
+
+// Token returns a token for the session of the request, to be included in
+// the forms or sent to the API clients. The session is given a secret, and
+// saved, the first time. Token must be called before the response is
+// written.
+func (p *Protector) Token(w http.ResponseWriter, r *http.Request) (string, error) {
+	sess, err := p.storage.Get(r, p.sessionName)
+	if err != nil && sess == nil {
+		return "", err
+	}
+
+	token, created, err := p.token(sess)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		if err = sess.Save(r, w); err != nil {
+			return "", err
+		}
+	}
+	return token, nil
+}
+
+// token returns a token for sess, reporting whether the secret of the session
+// was created.
+func (p *Protector) token(sess *sessions.Session) (token string, created bool, err error) {
+	secret, ok := sess.Values[secretSessionKey].(string)
+	if !ok {
+		b := make([]byte, secretSize)
+		if _, err = rand.Read(b); err != nil {
+			return "", false, err
+		}
+		secret, created = hex.EncodeToString(b), true
+		sess.Values[secretSessionKey] = secret
+	}
+
+	mask := make([]byte, secretSize)
+	if _, err = rand.Read(mask); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(mask, xor(mask, p.sign(secret))...)), created, nil
+}
+
+// checkToken verifies that token was issued for sess.
+func (p *Protector) checkToken(sess *sessions.Session, token string) error {
+	secret, ok := sess.Values[secretSessionKey].(string)
+	if !ok {
+		return ErrTokenInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*secretSize {
+		return ErrTokenInvalid
+	}
+	if !hmac.Equal(xor(b[:secretSize], b[secretSize:]), p.sign(secret)) {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func (p *Protector) sign(secret string) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("csrf"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+// checkOrigin verifies that the request comes from the shop or a trusted
+// origin. The Origin header is checked if the browser sent it, the Referer
+// otherwise. The HTTPS requests must have either, the HTTP requests can't
+// count on them being kept by proxies.
+func (p *Protector) checkOrigin(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !p.allowed(r, origin) {
+			return ErrOriginInvalid
+		}
+		return nil
+	}
+
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		if r.TLS != nil || strings.HasPrefix(p.origin, "https://") {
+			return ErrRefererMissing
+		}
+		return nil
+	}
+	if !p.allowed(r, originOf(referer)) {
+		return ErrRefererInvalid
+	}
+	return nil
+}
+
+// allowed reports whether origin is the shop's or a trusted one.
+func (p *Protector) allowed(r *http.Request, origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.trusted[origin] {
+		return true
+	}
+
+	expected := p.origin
+	if expected == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		expected = scheme + "://" + strings.ToLower(r.Host)
+	}
+	return origin == expected
+}
+
+// Safe reports whether the requests of method are safe, they don't change
+// the state and are never checked.
+func Safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// originOf returns the scheme and host of rawurl, lowercased, or rawurl
+// itself if it isn't an absolute URL.
+func originOf(rawurl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return strings.ToLower(rawurl)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/sessions"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+// memoryStore serves a single session to every request.
+type memoryStore struct {
+	session *sessions.Session
+	saved   int
+}
+
+func (s *memoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.session, nil
+}
+
+func (s *memoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.session, nil
+}
+
+func (s *memoryStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	s.saved++
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	return nil
+}
+
+type CSRFSuite struct {
+	store     *memoryStore
+	protector *Protector
+}
+
+var _ = Suite(new(CSRFSuite))
+
+func (suite *CSRFSuite) SetUpTest(c *C) {
+	suite.store = new(memoryStore)
+	suite.store.session = sessions.NewSession(suite.store, "session")
+	suite.protector = New(suite.store, "session", "secret", "https://shop.example.com",
+		[]string{"https://admin.example.com/"})
+}
+
+func (suite *CSRFSuite) token(c *C) string {
+	token, err := suite.protector.Token(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	c.Assert(err, IsNil)
+	return token
+}
+
+func (suite *CSRFSuite) post(token, origin string) *http.Request {
+	form := url.Values{FieldName: {token}}
+	r := httptest.NewRequest("POST", "https://shop.example.com/auth/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+// TestToken validates that the tokens are masked differently every time and
+// that the session is saved only when its secret is created.
+func (suite *CSRFSuite) TestToken(c *C) {
+	first, second := suite.token(c), suite.token(c)
+	c.Assert(first, Not(Equals), second)
+	c.Assert(suite.store.saved, Equals, 1)
+
+	c.Assert(suite.protector.Check(suite.post(first, "https://shop.example.com")), IsNil)
+	c.Assert(suite.protector.Check(suite.post(second, "https://shop.example.com")), IsNil)
+}
+
+// TestTokenHeader validates that the token is read from the header.
+func (suite *CSRFSuite) TestTokenHeader(c *C) {
+	r := suite.post("", "https://shop.example.com")
+	r.Header.Set(HeaderName, suite.token(c))
+	c.Assert(suite.protector.Check(r), IsNil)
+}
+
+// TestTokenInvalid validates that missing, malformed and foreign tokens are
+// rejected.
+func (suite *CSRFSuite) TestTokenInvalid(c *C) {
+	token := suite.token(c)
+
+	c.Assert(suite.protector.Check(suite.post("", "https://shop.example.com")), Equals, ErrTokenMissing)
+	c.Assert(suite.protector.Check(suite.post("garbage", "https://shop.example.com")), Equals, ErrTokenInvalid)
+	c.Assert(suite.protector.Check(suite.post(token[:len(token)-2]+"AA", "https://shop.example.com")), Equals, ErrTokenInvalid)
+
+	// a token of another session.
+	suite.store.session = sessions.NewSession(suite.store, "session")
+	suite.token(c)
+	c.Assert(suite.protector.Check(suite.post(token, "https://shop.example.com")), Equals, ErrTokenInvalid)
+
+	// a token signed with another secret.
+	other := New(suite.store, "session", "other", "https://shop.example.com", nil)
+	c.Assert(other.Check(suite.post(suite.token(c), "https://shop.example.com")), Equals, ErrTokenInvalid)
+}
+
+// TestOrigin validates the checks of the Origin and Referer headers.
+func (suite *CSRFSuite) TestOrigin(c *C) {
+	token := suite.token(c)
+
+	c.Assert(suite.protector.Check(suite.post(token, "https://evil.example.com")), Equals, ErrOriginInvalid)
+	c.Assert(suite.protector.Check(suite.post(token, "http://shop.example.com")), Equals, ErrOriginInvalid)
+	c.Assert(suite.protector.Check(suite.post(token, "null")), Equals, ErrOriginInvalid)
+	c.Assert(suite.protector.Check(suite.post(token, "https://admin.example.com")), IsNil)
+	c.Assert(suite.protector.Check(suite.post(token, "https://SHOP.example.com")), IsNil)
+
+	r := suite.post(token, "")
+	c.Assert(suite.protector.Check(r), Equals, ErrRefererMissing)
+	r.Header.Set("Referer", "https://evil.example.com/shop.example.com")
+	c.Assert(suite.protector.Check(r), Equals, ErrRefererInvalid)
+	r.Header.Set("Referer", "https://shop.example.com/auth/login?next=/")
+	c.Assert(suite.protector.Check(r), IsNil)
+}
+
+// TestOriginRequestHost validates that the requests are compared to their own
+// host if no host is configured.
+func (suite *CSRFSuite) TestOriginRequestHost(c *C) {
+	p := New(suite.store, "session", "secret", "", nil)
+	token := suite.token(c)
+
+	r := httptest.NewRequest("POST", "http://localhost:5000/", nil)
+	r.Header.Set(HeaderName, token)
+	c.Assert(p.Check(r), IsNil, Commentf("plain HTTP requests may lack a referer"))
+	r.Header.Set("Origin", "http://localhost:5000")
+	c.Assert(p.Check(r), IsNil)
+	r.Header.Set("Origin", "http://localhost:8000")
+	c.Assert(p.Check(r), Equals, ErrOriginInvalid)
+}
+
+// TestMiddleware validates that the unsafe requests are rejected unless their
+// route is exempted.
+func (suite *CSRFSuite) TestMiddleware(c *C) {
+	router := mux.NewRouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/login", ok).Name("login")
+	router.Handle("/webhook", ok).Name("webhook")
+	router.Use(suite.protector.Middleware)
+	suite.protector.Exempt("webhook")
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/login", http.StatusOK},
+		{"HEAD", "/login", http.StatusOK},
+		{"POST", "/login", http.StatusForbidden},
+		{"DELETE", "/login", http.StatusForbidden},
+		{"POST", "/webhook", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, "https://shop.example.com"+tc.path, nil))
+		c.Assert(w.Code, Equals, tc.status, Commentf("%s %s", tc.method, tc.path))
+		if tc.status == http.StatusForbidden {
+			c.Assert(w.Body.String(), Matches, `(?s).*"code":"CSRF_INVALID".*`)
+		}
+	}
+}
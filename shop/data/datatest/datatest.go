@@ -0,0 +1,74 @@
+// Package datatest runs the tests of the repositories against a MongoDB
+// server, given by $THATIQ_TEST_MONGODB_URI. The tests needing it are skipped
+// when the variable isn't set.
+package datatest
+
+import (
+	"context"
+	"os"
+
+	check "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+// URIEnv is the environment variable naming the MongoDB server of the tests.
+const URIEnv = "THATIQ_TEST_MONGODB_URI"
+
+// Dial connects to the test MongoDB server, to the database thatiq_test_name
+// which it empties first. It skips the test if no server is configured. Drop
+// removes the database when the tests are done.
+func Dial(c *check.C, name string) *data.MongoConn {
+	uri := os.Getenv(URIEnv)
+	if uri == "" {
+		c.Skip(URIEnv + " is not set")
+	}
+
+	conn, err := data.Dial(uri, "thatiq_test_"+name)
+	c.Assert(err, check.IsNil)
+	c.Assert(conn.DB.DropDatabase(), check.IsNil)
+	return conn
+}
+
+// Drop removes the test database of conn and closes it.
+func Drop(c *check.C, conn *data.MongoConn) {
+	if conn == nil {
+		return
+	}
+	c.Check(conn.DB.DropDatabase(), check.IsNil)
+	conn.Session.Close()
+}
+
+// Indexer is a repository creating the indexes of its collections.
+type Indexer interface {
+	EnsureIndexes(ctx context.Context) error
+}
+
+// Suite is embedded in the suites of the repositories. It dials the test
+// database Name when the suite starts, and drops it when the suite is done.
+type Suite struct {
+	Name string
+	Conn *data.MongoConn
+	Ctx  context.Context
+}
+
+func (s *Suite) SetUpSuite(c *check.C) {
+	s.Conn = Dial(c, s.Name)
+	s.Ctx = context.Background()
+}
+
+func (s *Suite) TearDownSuite(c *check.C) {
+	Drop(c, s.Conn)
+}
+
+// Reset drops the collections and creates the indexes of repo, if not nil, so
+// that each test starts from empty collections.
+func (s *Suite) Reset(c *check.C, repo Indexer, collections ...string) {
+	for _, name := range collections {
+		// the collection doesn't exist before the first test.
+		s.Conn.DB.C(name).DropCollection()
+	}
+	if repo != nil {
+		c.Assert(repo.EnsureIndexes(s.Ctx), check.IsNil)
+	}
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+func init() {
+	Register(Migration{
+		Version:     20261016090000,
+		Description: "backfill created_at of the users",
+		// the users created before created_at was set get the creation
+		// time of their id.
+		Up: func(ctx context.Context, db *mgo.Database) error {
+			c := db.C(user.CollectionName)
+			iter := c.Find(bson.M{"$or": []bson.M{
+				{"created_at": bson.M{"$exists": false}},
+				{"created_at": time.Time{}},
+			}}).Select(bson.M{"_id": 1}).Iter()
+
+			var doc struct {
+				Id bson.ObjectId `bson:"_id"`
+			}
+			for iter.Next(&doc) {
+				if err := ctx.Err(); err != nil {
+					iter.Close()
+					return err
+				}
+				err := c.UpdateId(doc.Id, bson.M{"$set": bson.M{"created_at": doc.Id.Time().UTC()}})
+				if err != nil && err != mgo.ErrNotFound {
+					iter.Close()
+					return err
+				}
+			}
+			return iter.Close()
+		},
+		// the backfilled dates can't be told apart from the others, they
+		// are kept.
+		Down: func(ctx context.Context, db *mgo.Database) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/globalsign/mgo"
+
+	"github.com/syaiful6/thatique/shop/data/product"
+)
+
+// productsCategoryIndex is the name of the index of the products by category
+// and status. No query used it, the products are only listed by store.
+const productsCategoryIndex = "category_1_status_1"
+
+// namespaceNotFound is the code of the error listing the indexes of a
+// collection that doesn't exist.
+const namespaceNotFound = 26
+
+func init() {
+	Register(Migration{
+		Version:     20261016120000,
+		Description: "drop the unused category index of the products",
+		Up: func(ctx context.Context, db *mgo.Database) error {
+			c := db.C(product.CollectionName)
+			indexes, err := c.Indexes()
+			if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == namespaceNotFound {
+				// no product was created yet.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, index := range indexes {
+				if index.Name == productsCategoryIndex {
+					return c.DropIndexName(productsCategoryIndex)
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mgo.Database) error {
+			return db.C(product.CollectionName).EnsureIndex(mgo.Index{
+				Key:        []string{"category", "status"},
+				Background: true,
+			})
+		},
+	})
+}
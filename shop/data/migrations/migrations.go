@@ -0,0 +1,393 @@
+// Package migrations evolves the MongoDB schema of the shop. The migrations
+// are Go functions registered by the files of this package, applied in the
+// order of their versions and recorded in the migrations collection.
+//
+// A new migration is added by `shop migrate create <name>`, which writes the
+// skeleton of the migration file.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	// CollectionName is the collection recording the applied migrations.
+	CollectionName = "migrations"
+
+	// LockCollectionName is the collection holding the lock taken while
+	// migrating.
+	LockCollectionName = "migrations_lock"
+)
+
+const (
+	// lockID is the id of the lock document.
+	lockID = "migrations"
+
+	// lockTTL is how long the lock is held without being refreshed. The
+	// lock of an instance that died while migrating expires after it.
+	lockTTL = time.Minute
+
+	// lockRetryInterval is the delay in between attempts to take the lock.
+	lockRetryInterval = time.Second
+)
+
+// ErrIrreversible is returned by Down when a migration to revert has no Down
+// function.
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// Migration is a change of the schema or the documents. The migrations don't
+// run in a transaction: a migration failing halfway is run again from the
+// start, so it must be idempotent.
+type Migration struct {
+	// Version orders the migrations, it is the UTC time the migration was
+	// created at, formatted as 20060102150405.
+	Version int64
+
+	// Description tells what the migration does.
+	Description string
+
+	// Up applies the migration. ctx is canceled if the migrations lock is
+	// lost: another instance may then run the migration too, a long
+	// migration checks ctx in between its steps and returns its error.
+	Up func(ctx context.Context, db *mgo.Database) error
+
+	// Down reverts the migration, checking ctx like Up. A nil Down makes
+	// the migration irreversible.
+	Down func(ctx context.Context, db *mgo.Database) error
+}
+
+var registry = make(map[int64]Migration)
+
+// Register adds a migration, it is meant to be called by the init functions
+// of the migration files. It panics if the version is registered already.
+func Register(m Migration) {
+	if m.Version <= 0 {
+		panic(fmt.Sprintf("migrations: invalid version %d", m.Version))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migrations: migration %d has no Up function", m.Version))
+	}
+	if _, ok := registry[m.Version]; ok {
+		panic(fmt.Sprintf("migrations: version %d registered twice", m.Version))
+	}
+	registry[m.Version] = m
+}
+
+// All returns the registered migrations, ordered by version.
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+	return all
+}
+
+// record is the document recording an applied migration.
+type record struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Status is the state of a migration in the database.
+type Status struct {
+	Migration
+
+	// Applied is set if the migration was applied, at AppliedAt.
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the registered migrations to a database.
+type Migrator struct {
+	conn       *data.MongoConn
+	migrations []Migration
+	owner      string
+}
+
+// NewMigrator returns a Migrator of the database of conn.
+func NewMigrator(conn *data.MongoConn) *Migrator {
+	host, _ := os.Hostname()
+	return &Migrator{
+		conn:       conn,
+		migrations: All(),
+		owner:      fmt.Sprintf("%s:%d:%x", host, os.Getpid(), rand.Int63()),
+	}
+}
+
+// Status returns the state of the registered migrations, ordered by version.
+// The migrations recorded in the database but not registered, applied by a
+// more recent version of the shop, are included without Up nor Down.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if r, ok := applied[mig.Version]; ok {
+			s.Applied, s.AppliedAt = true, r.AppliedAt
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, r := range applied {
+		statuses = append(statuses, Status{
+			Migration: Migration{Version: r.Version, Description: r.Description},
+			Applied:   true,
+			AppliedAt: r.AppliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Pending returns the registered migrations that aren't applied.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations up to the target version included, or all
+// of them if target is 0. It returns the migrations applied, up to the one
+// that failed.
+func (m *Migrator) Up(ctx context.Context, target int64) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range pending {
+			if target != 0 && mig.Version > target {
+				break
+			}
+			err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+				if err := mig.Up(ctx, db); err != nil {
+					return err
+				}
+				return db.C(CollectionName).Insert(record{
+					Version:     mig.Version,
+					Description: mig.Description,
+					AppliedAt:   time.Now().UTC(),
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", mig.Version, mig.Description, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, most recent first. It
+// returns the migrations reverted, up to the one that failed.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		byVersion := make(map[int64]Migration)
+		for _, mig := range m.migrations {
+			byVersion[mig.Version] = mig
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			mig, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d %s: unknown to this version of the shop",
+					versions[i], applied[versions[i]].Description)
+			}
+			if mig.Down == nil {
+				return fmt.Errorf("migration %d %s: %v", mig.Version, mig.Description, ErrIrreversible)
+			}
+			err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+				if err := mig.Down(ctx, db); err != nil {
+					return err
+				}
+				return db.C(CollectionName).RemoveId(mig.Version)
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", mig.Version, mig.Description, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// applied returns the records of the applied migrations, by version.
+func (m *Migrator) applied(ctx context.Context) (map[int64]record, error) {
+	var records []record
+	err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(nil).All(&records)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// withLock runs f holding the lock, so that a single instance migrates at a
+// time. It waits for the lock until ctx is done. The lock is refreshed while
+// f runs, and expires if the instance holding it dies. The context given to f
+// is canceled if the lock can't be refreshed, the error is then returned. f
+// isn't interrupted otherwise: the migrations check the context to stop.
+func (m *Migrator) withLock(ctx context.Context, f func(ctx context.Context) error) error {
+	for {
+		ok, err := m.lock(ctx)
+		if err != nil {
+			return fmt.Errorf("error taking the migrations lock: %v", err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error taking the migrations lock: %v", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// refreshErr is set before refreshed is closed.
+	var refreshErr error
+	stop := make(chan struct{})
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := m.refresh(lockCtx); err != nil && ctx.Err() == nil {
+					// another instance may take the lock over, stop f
+					// before it migrates concurrently.
+					refreshErr = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := f(lockCtx)
+
+	close(stop)
+	<-refreshed
+	if refreshErr != nil {
+		err = fmt.Errorf("error refreshing the migrations lock: %v", refreshErr)
+	}
+	// the lock is released even if ctx is canceled.
+	if unlockErr := m.unlock(context.Background()); unlockErr != nil && err == nil {
+		err = fmt.Errorf("error releasing the migrations lock: %v", unlockErr)
+	}
+	return err
+}
+
+// lock takes the lock if it is free or expired. It reports whether the lock
+// was taken.
+func (m *Migrator) lock(ctx context.Context) (bool, error) {
+	var taken bool
+	err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+		c := db.C(LockCollectionName)
+		now := time.Now().UTC()
+		expires := now.Add(lockTTL)
+
+		err := c.Insert(bson.M{"_id": lockID, "owner": m.owner, "expires_at": expires})
+		if err == nil {
+			taken = true
+			return nil
+		}
+		if !mgo.IsDup(err) {
+			return err
+		}
+
+		// take over the lock of an instance that died.
+		err = c.Update(bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}}, bson.M{"$set": bson.M{"owner": m.owner, "expires_at": expires}})
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		taken = err == nil
+		return err
+	})
+	return taken, err
+}
+
+// errLockLost is returned by refresh when the lock isn't held anymore.
+var errLockLost = errors.New("the lock expired and was taken over")
+
+// refresh extends the lock held.
+func (m *Migrator) refresh(ctx context.Context) error {
+	err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(LockCollectionName).Update(
+			bson.M{"_id": lockID, "owner": m.owner},
+			bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(lockTTL)}})
+	})
+	if err == mgo.ErrNotFound {
+		return errLockLost
+	}
+	return err
+}
+
+// unlock releases the lock held.
+func (m *Migrator) unlock(ctx context.Context) error {
+	err := m.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(LockCollectionName).Remove(bson.M{"_id": lockID, "owner": m.owner})
+	})
+	if err == mgo.ErrNotFound {
+		// the lock expired and was taken over.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,251 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/datatest"
+	"github.com/syaiful6/thatique/shop/data/product"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type RegisterSuite struct {
+	saved map[int64]Migration
+}
+
+var _ = Suite(new(RegisterSuite))
+
+func (suite *RegisterSuite) SetUpTest(c *C) {
+	suite.saved, registry = registry, make(map[int64]Migration)
+}
+
+func (suite *RegisterSuite) TearDownTest(c *C) {
+	registry = suite.saved
+}
+
+// TestAll validates that the migrations are ordered by version, whatever
+// the order they are registered in.
+func (suite *RegisterSuite) TestAll(c *C) {
+	up := func(ctx context.Context, db *mgo.Database) error { return nil }
+	for _, v := range []int64{20261016090000, 20250101000000, 20261201000000} {
+		Register(Migration{Version: v, Up: up})
+	}
+
+	var versions []int64
+	for _, m := range All() {
+		versions = append(versions, m.Version)
+	}
+	c.Assert(versions, DeepEquals, []int64{20250101000000, 20261016090000, 20261201000000})
+}
+
+// TestRegisterInvalid validates that Register panics on the migrations that
+// can't be applied.
+func (suite *RegisterSuite) TestRegisterInvalid(c *C) {
+	up := func(ctx context.Context, db *mgo.Database) error { return nil }
+	c.Assert(func() { Register(Migration{Up: up}) }, PanicMatches, "migrations: invalid version 0")
+	c.Assert(func() { Register(Migration{Version: 1}) }, PanicMatches, "migrations: migration 1 has no Up function")
+
+	Register(Migration{Version: 1, Up: up})
+	c.Assert(func() { Register(Migration{Version: 1, Up: up}) }, PanicMatches, "migrations: version 1 registered twice")
+}
+
+// stepsCollection records the test migrations applied.
+const stepsCollection = "steps"
+
+type MigratorSuite struct {
+	datatest.Suite
+}
+
+var _ = Suite(&MigratorSuite{Suite: datatest.Suite{Name: "migrations"}})
+
+func (suite *MigratorSuite) SetUpTest(c *C) {
+	suite.Reset(c, nil, CollectionName, LockCollectionName, stepsCollection)
+}
+
+// migration returns a migration recording its version in the steps
+// collection.
+func migration(version int64, reversible bool) Migration {
+	m := Migration{
+		Version:     version,
+		Description: "test",
+		Up: func(ctx context.Context, db *mgo.Database) error {
+			return db.C(stepsCollection).Insert(bson.M{"_id": version})
+		},
+	}
+	if reversible {
+		m.Down = func(ctx context.Context, db *mgo.Database) error {
+			return db.C(stepsCollection).RemoveId(version)
+		}
+	}
+	return m
+}
+
+func (suite *MigratorSuite) migrator(owner string, migrations ...Migration) *Migrator {
+	return &Migrator{conn: suite.Conn, migrations: migrations, owner: owner}
+}
+
+// versions returns the versions of migrations.
+func versions(migrations []Migration) []int64 {
+	var vs []int64
+	for _, m := range migrations {
+		vs = append(vs, m.Version)
+	}
+	return vs
+}
+
+// steps returns the versions recorded by the applied test migrations.
+func (suite *MigratorSuite) steps(c *C) []int64 {
+	var docs []struct {
+		Version int64 `bson:"_id"`
+	}
+	c.Assert(suite.Conn.DB.C(stepsCollection).Find(nil).Sort("_id").All(&docs), IsNil)
+	var vs []int64
+	for _, d := range docs {
+		vs = append(vs, d.Version)
+	}
+	return vs
+}
+
+// TestUpDown validates that the migrations applied and reverted are
+// recorded, and that Down stops at an irreversible migration.
+func (suite *MigratorSuite) TestUpDown(c *C) {
+	m := suite.migrator("test", migration(1, false), migration(2, true), migration(3, true))
+
+	done, err := m.Up(suite.Ctx, 2)
+	c.Assert(err, IsNil)
+	c.Assert(versions(done), DeepEquals, []int64{1, 2})
+	pending, err := m.Pending(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(versions(pending), DeepEquals, []int64{3})
+
+	done, err = m.Up(suite.Ctx, 0)
+	c.Assert(err, IsNil)
+	c.Assert(versions(done), DeepEquals, []int64{3})
+	c.Assert(suite.steps(c), DeepEquals, []int64{1, 2, 3})
+
+	statuses, err := m.Status(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 3)
+	for _, s := range statuses {
+		c.Assert(s.Applied, Equals, true)
+	}
+
+	done, err = m.Down(suite.Ctx, 3)
+	c.Assert(err, ErrorMatches, "migration 1 test: migration is irreversible")
+	c.Assert(versions(done), DeepEquals, []int64{3, 2})
+	c.Assert(suite.steps(c), DeepEquals, []int64{1})
+	pending, err = m.Pending(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(versions(pending), DeepEquals, []int64{2, 3})
+}
+
+// TestUpFailure validates that a failing migration stops Up and isn't
+// recorded.
+func (suite *MigratorSuite) TestUpFailure(c *C) {
+	failing := migration(2, true)
+	failing.Up = func(ctx context.Context, db *mgo.Database) error { return errors.New("failed") }
+	m := suite.migrator("test", migration(1, true), failing, migration(3, true))
+
+	done, err := m.Up(suite.Ctx, 0)
+	c.Assert(err, ErrorMatches, "migration 2 test: failed")
+	c.Assert(versions(done), DeepEquals, []int64{1})
+	pending, err := m.Pending(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(versions(pending), DeepEquals, []int64{2, 3})
+}
+
+// TestUnknownApplied validates that the migrations applied by a more recent
+// version of the shop are reported, but not reverted.
+func (suite *MigratorSuite) TestUnknownApplied(c *C) {
+	_, err := suite.migrator("test", migration(1, true), migration(2, true)).Up(suite.Ctx, 0)
+	c.Assert(err, IsNil)
+
+	m := suite.migrator("test", migration(1, true))
+	statuses, err := m.Status(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 2)
+	c.Assert(statuses[1].Applied, Equals, true)
+	c.Assert(statuses[1].Up, IsNil)
+
+	_, err = m.Down(suite.Ctx, 1)
+	c.Assert(err, ErrorMatches, "migration 2 test: unknown to this version of the shop")
+}
+
+// TestLockTakeover validates that the lock is exclusive until it expires,
+// and that the instance it was taken over from notices it.
+func (suite *MigratorSuite) TestLockTakeover(c *C) {
+	a, b := suite.migrator("a"), suite.migrator("b")
+
+	ok, err := a.lock(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	ok, err = b.lock(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	c.Assert(a.refresh(suite.Ctx), IsNil)
+
+	// a dies while holding the lock.
+	err = suite.Conn.DB.C(LockCollectionName).UpdateId(lockID,
+		bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(-time.Second)}})
+	c.Assert(err, IsNil)
+
+	ok, err = b.lock(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(a.refresh(suite.Ctx), Equals, errLockLost)
+
+	// a releasing its lock leaves the one of b.
+	c.Assert(a.unlock(suite.Ctx), IsNil)
+	ok, err = a.lock(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	c.Assert(b.unlock(suite.Ctx), IsNil)
+	ok, err = a.lock(suite.Ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+}
+
+// registered returns the registered migration of version.
+func registered(c *C, version int64) Migration {
+	for _, m := range All() {
+		if m.Version == version {
+			return m
+		}
+	}
+	c.Fatalf("migration %d isn't registered", version)
+	return Migration{}
+}
+
+// TestProductsCategoryIndex validates that the category index of the
+// products is dropped, whether it exists or not.
+func (suite *MigratorSuite) TestProductsCategoryIndex(c *C) {
+	m := registered(c, 20261016120000)
+	products := suite.Conn.DB.C(product.CollectionName)
+	products.DropCollection()
+	c.Assert(m.Up(suite.Ctx, suite.Conn.DB), IsNil)
+
+	hasIndex := func() bool {
+		indexes, err := products.Indexes()
+		c.Assert(err, IsNil)
+		for _, index := range indexes {
+			if index.Name == productsCategoryIndex {
+				return true
+			}
+		}
+		return false
+	}
+
+	c.Assert(m.Down(suite.Ctx, suite.Conn.DB), IsNil)
+	c.Assert(hasIndex(), Equals, true)
+	c.Assert(m.Up(suite.Ctx, suite.Conn.DB), IsNil)
+	c.Assert(hasIndex(), Equals, false)
+	c.Assert(m.Up(suite.Ctx, suite.Conn.DB), IsNil)
+}
@@ -44,3 +44,11 @@ func (conn *MongoConn) WithContext(ctx context.Context, f func(*mgo.Database) er
 		return err
 	}
 }
+
+// Ping checks that the MongoDB server is reachable.
+func (conn *MongoConn) Ping() error {
+	sess := conn.Session.Copy()
+	defer sess.Close()
+
+	return sess.Ping()
+}
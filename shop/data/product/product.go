@@ -0,0 +1,319 @@
+// Package product holds the catalog of the stores. A product is sold in
+// variants, the combinations of its options, e.g. the sizes and colors of a
+// shirt, each variant having its own SKU, price and stock.
+package product
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	CollectionName = "products"
+)
+
+// The statuses of a product.
+const (
+	// StatusDraft is the status of the products being prepared, only
+	// visible to the owner of the store.
+	StatusDraft = "draft"
+
+	// StatusPublished is the status of the products for sale.
+	StatusPublished = "published"
+
+	// StatusArchived is the status of the products no longer sold, only
+	// visible to the owner of the store.
+	StatusArchived = "archived"
+)
+
+// The limits of the products.
+const (
+	MaxTitleLength       = 120
+	MaxDescriptionLength = 5000
+	MaxCategoryLength    = 50
+	MaxSKULength         = 64
+	MaxImages            = 10
+	MaxOptions           = 3
+	MaxOptionValues      = 20
+	MaxVariants          = 100
+)
+
+// FieldError tells which field of a product is invalid, and why.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Option is a dimension the product varies along, e.g. "Size" with the
+// values "S", "M" and "L".
+type Option struct {
+	Name   string   `bson:"name" json:"name"`
+	Values []string `bson:"values" json:"values"`
+}
+
+// Variant is a combination of the option values of a product, it is what is
+// actually sold.
+type Variant struct {
+	SKU string `bson:"sku" json:"sku"`
+
+	// Options maps the name of every option of the product to one of its
+	// values, e.g. {"Size": "M", "Color": "Red"}.
+	Options map[string]string `bson:"options,omitempty" json:"options"`
+
+	// Price is in the smallest unit of the currency.
+	Price int64 `bson:"price" json:"price"`
+	Stock int   `bson:"stock" json:"stock"`
+}
+
+type Product struct {
+	Id          bson.ObjectId `bson:"_id,omitempty"`
+	StoreId     bson.ObjectId `bson:"store_id"`
+	Slug        string        `bson:"slug"`
+	Title       string        `bson:"title"`
+	Description string        `bson:"description,omitempty"`
+	Images      []string      `bson:"images,omitempty"`
+	Category    string        `bson:"category,omitempty"`
+
+	// Weight is in grams, it is used to compute the shipping costs.
+	Weight      int        `bson:"weight"`
+	Options     []Option   `bson:"options,omitempty"`
+	Variants    []Variant  `bson:"variants"`
+	Status      string     `bson:"status"`
+	CreatedAt   time.Time  `bson:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at"`
+	PublishedAt *time.Time `bson:"published_at,omitempty"`
+}
+
+type SerializeProduct struct {
+	Id          string     `json:"id"`
+	StoreId     string     `json:"store_id"`
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Images      []string   `json:"images"`
+	Category    string     `json:"category"`
+	Weight      int        `json:"weight"`
+	Options     []Option   `json:"options"`
+	Variants    []Variant  `json:"variants"`
+	PriceMin    int64      `json:"price_min"`
+	PriceMax    int64      `json:"price_max"`
+	InStock     bool       `json:"in_stock"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// Serialize returns the representation of the product sent to clients.
+func (p *Product) Serialize() *SerializeProduct {
+	sp := &SerializeProduct{
+		Id:          p.Id.Hex(),
+		StoreId:     p.StoreId.Hex(),
+		Slug:        p.Slug,
+		Title:       p.Title,
+		Description: p.Description,
+		Images:      p.Images,
+		Category:    p.Category,
+		Weight:      p.Weight,
+		Options:     p.Options,
+		Variants:    p.Variants,
+		Status:      p.Status,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+		PublishedAt: p.PublishedAt,
+	}
+	if sp.Images == nil {
+		sp.Images = []string{}
+	}
+	if sp.Options == nil {
+		sp.Options = []Option{}
+	}
+	for i, v := range p.Variants {
+		if i == 0 || v.Price < sp.PriceMin {
+			sp.PriceMin = v.Price
+		}
+		if v.Price > sp.PriceMax {
+			sp.PriceMax = v.Price
+		}
+		if v.Stock > 0 {
+			sp.InStock = true
+		}
+	}
+	return sp
+}
+
+// Published reports whether the product is for sale.
+func (p *Product) Published() bool {
+	return p.Status == StatusPublished
+}
+
+// SetStatus changes the status of the product, recording when it is first
+// published.
+func (p *Product) SetStatus(status string) {
+	p.Status = status
+	if status == StatusPublished && p.PublishedAt == nil {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		p.PublishedAt = &now
+	}
+}
+
+// ValidStatus reports whether status is a known status.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusDraft, StatusPublished, StatusArchived:
+		return true
+	}
+	return false
+}
+
+// Normalize trims the text fields of the product.
+func (p *Product) Normalize() {
+	p.Title = strings.TrimSpace(p.Title)
+	p.Description = strings.TrimSpace(p.Description)
+	p.Category = strings.ToLower(strings.TrimSpace(p.Category))
+	for i := range p.Images {
+		p.Images[i] = strings.TrimSpace(p.Images[i])
+	}
+	for i := range p.Options {
+		o := &p.Options[i]
+		o.Name = strings.TrimSpace(o.Name)
+		for j := range o.Values {
+			o.Values[j] = strings.TrimSpace(o.Values[j])
+		}
+	}
+	for i := range p.Variants {
+		v := &p.Variants[i]
+		v.SKU = strings.TrimSpace(v.SKU)
+		options := make(map[string]string, len(v.Options))
+		for name, value := range v.Options {
+			options[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		v.Options = options
+	}
+}
+
+// Validate checks the fields of the product, the slug excepted, returning a
+// *FieldError for the first invalid one. Every variant must combine a value
+// of each option, no two variants having the same combination or SKU.
+func (p *Product) Validate() error {
+	if p.Title == "" || utf8.RuneCountInString(p.Title) > MaxTitleLength {
+		return invalid("title", "the title must have 1 to %d characters", MaxTitleLength)
+	}
+	if utf8.RuneCountInString(p.Description) > MaxDescriptionLength {
+		return invalid("description", "the description must have at most %d characters", MaxDescriptionLength)
+	}
+	if utf8.RuneCountInString(p.Category) > MaxCategoryLength {
+		return invalid("category", "the category must have at most %d characters", MaxCategoryLength)
+	}
+	if p.Weight < 0 {
+		return invalid("weight", "the weight must be a positive number of grams")
+	}
+	if !ValidStatus(p.Status) {
+		return invalid("status", "the status must be draft, published or archived")
+	}
+
+	if len(p.Images) > MaxImages {
+		return invalid("images", "a product has at most %d images", MaxImages)
+	}
+	for _, image := range p.Images {
+		u, err := url.Parse(image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("images", "the images must be http or https URLs")
+		}
+	}
+
+	if len(p.Options) > MaxOptions {
+		return invalid("options", "a product has at most %d options", MaxOptions)
+	}
+	values := make(map[string]map[string]bool, len(p.Options))
+	for _, o := range p.Options {
+		if o.Name == "" || values[o.Name] != nil {
+			return invalid("options", "the options must have distinct, non empty, names")
+		}
+		if len(o.Values) == 0 || len(o.Values) > MaxOptionValues {
+			return invalid("options", "the option %s must have 1 to %d values", o.Name, MaxOptionValues)
+		}
+		values[o.Name] = make(map[string]bool, len(o.Values))
+		for _, value := range o.Values {
+			if value == "" || values[o.Name][value] {
+				return invalid("options", "the values of the option %s must be distinct and non empty", o.Name)
+			}
+			values[o.Name][value] = true
+		}
+	}
+
+	if len(p.Variants) == 0 || len(p.Variants) > MaxVariants {
+		return invalid("variants", "a product has 1 to %d variants", MaxVariants)
+	}
+	skus := make(map[string]bool, len(p.Variants))
+	combinations := make(map[string]bool, len(p.Variants))
+	for _, v := range p.Variants {
+		if v.SKU == "" || len(v.SKU) > MaxSKULength {
+			return invalid("variants", "the SKUs must have 1 to %d characters", MaxSKULength)
+		}
+		if skus[v.SKU] {
+			return invalid("variants", "the SKU %s is used by several variants", v.SKU)
+		}
+		skus[v.SKU] = true
+		if v.Price < 0 {
+			return invalid("variants", "the price of %s must be positive", v.SKU)
+		}
+		if v.Stock < 0 {
+			return invalid("variants", "the stock of %s must be positive", v.SKU)
+		}
+
+		if len(v.Options) != len(p.Options) {
+			return invalid("variants", "the variant %s must have a value for each option", v.SKU)
+		}
+		for name, value := range v.Options {
+			if !values[name][value] {
+				return invalid("variants", "the variant %s has an unknown option value %s: %s", v.SKU, name, value)
+			}
+		}
+		combination := v.combination()
+		if combinations[combination] {
+			return invalid("variants", "the variant %s has the same options as another variant", v.SKU)
+		}
+		combinations[combination] = true
+	}
+	return nil
+}
+
+// Variant returns the variant with the given SKU, or nil.
+func (p *Product) Variant(sku string) *Variant {
+	for i := range p.Variants {
+		if p.Variants[i].SKU == sku {
+			return &p.Variants[i]
+		}
+	}
+	return nil
+}
+
+// combination returns a key identifying the option values of the variant.
+func (v *Variant) combination() string {
+	names := make([]string, 0, len(v.Options))
+	for name := range v.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%q=%q;", name, v.Options[name])
+	}
+	return b.String()
+}
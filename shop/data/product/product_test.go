@@ -0,0 +1,99 @@
+package product
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type ProductSuite struct{}
+
+var _ = Suite(new(ProductSuite))
+
+func shirt() *Product {
+	return &Product{
+		Title:  " Kemeja Batik ",
+		Status: StatusDraft,
+		Options: []Option{
+			{Name: "Size", Values: []string{"M", "L"}},
+			{Name: "Color", Values: []string{"Red", "Blue"}},
+		},
+		Variants: []Variant{
+			{SKU: "KB-M-RED", Options: map[string]string{"Size": "M", "Color": "Red"}, Price: 150000, Stock: 0},
+			{SKU: "KB-L-RED", Options: map[string]string{"Size": "L", "Color": "Red"}, Price: 175000, Stock: 3},
+			{SKU: "KB-M-BLUE", Options: map[string]string{"Size": "M", "Color": " Blue "}, Price: 150000},
+		},
+	}
+}
+
+// TestValidate validates the checks of the option matrix and of the variants.
+func (suite *ProductSuite) TestValidate(c *C) {
+	p := shirt()
+	p.Normalize()
+	c.Assert(p.Validate(), IsNil)
+	c.Assert(p.Title, Equals, "Kemeja Batik")
+	c.Assert(p.Variant("KB-M-BLUE").Options["Color"], Equals, "Blue")
+	c.Assert(p.Variant("KB-XL-RED"), IsNil)
+
+	for _, tc := range []struct {
+		field  string
+		change func(p *Product)
+	}{
+		{"title", func(p *Product) { p.Title = "" }},
+		{"status", func(p *Product) { p.Status = "sold" }},
+		{"images", func(p *Product) { p.Images = []string{"javascript:alert(1)"} }},
+		{"options", func(p *Product) { p.Options[1].Name = "Size" }},
+		{"options", func(p *Product) { p.Options[0].Values = []string{"M", "M"} }},
+		{"variants", func(p *Product) { p.Variants = nil }},
+		{"variants", func(p *Product) { p.Variants[1].SKU = "KB-M-RED" }},
+		{"variants", func(p *Product) { p.Variants[1].Options["Size"] = "M" }},
+		{"variants", func(p *Product) { p.Variants[0].Options["Size"] = "XL" }},
+		{"variants", func(p *Product) { delete(p.Variants[0].Options, "Color") }},
+		{"variants", func(p *Product) { p.Variants[0].Price = -1 }},
+	} {
+		p := shirt()
+		tc.change(p)
+		p.Normalize()
+		err := p.Validate()
+		c.Assert(err, FitsTypeOf, &FieldError{})
+		c.Assert(err.(*FieldError).Field, Equals, tc.field, Commentf("error %v", err))
+	}
+
+	// A product without options is sold in a single variant.
+	p = &Product{Title: "Kopi", Status: StatusDraft, Variants: []Variant{{SKU: "KOPI", Price: 50000}}}
+	p.Normalize()
+	c.Assert(p.Validate(), IsNil)
+}
+
+// TestSerialize checks the price range and the availability of a product.
+func (suite *ProductSuite) TestSerialize(c *C) {
+	sp := shirt().Serialize()
+	c.Assert(sp.PriceMin, Equals, int64(150000))
+	c.Assert(sp.PriceMax, Equals, int64(175000))
+	c.Assert(sp.InStock, Equals, true)
+	c.Assert(sp.Images, NotNil)
+
+	p := shirt()
+	p.Variants[1].Stock = 0
+	c.Assert(p.Serialize().InStock, Equals, false)
+}
+
+// TestSetStatus checks that the publication date is kept when the product is
+// archived and published again.
+func (suite *ProductSuite) TestSetStatus(c *C) {
+	p := shirt()
+	p.SetStatus(StatusDraft)
+	c.Assert(p.PublishedAt, IsNil)
+
+	p.SetStatus(StatusPublished)
+	c.Assert(p.Published(), Equals, true)
+	c.Assert(p.PublishedAt, NotNil)
+	published := *p.PublishedAt
+
+	p.SetStatus(StatusArchived)
+	p.SetStatus(StatusPublished)
+	c.Assert(*p.PublishedAt, Equals, published)
+}
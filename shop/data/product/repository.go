@@ -0,0 +1,233 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	// ErrNotFound is returned when no product matches a lookup.
+	ErrNotFound = errors.New("product not found")
+
+	// ErrSlugExists is returned when the slug of a product being saved is
+	// used by another product of the store.
+	ErrSlugExists = errors.New("product slug already taken")
+
+	// ErrSKUExists is returned when a SKU of a product being saved is used
+	// by another product of the store.
+	ErrSKUExists = errors.New("SKU already used")
+)
+
+const (
+	// slugIndex and skuIndex name the unique indexes, to tell which one a
+	// duplicate key error comes from.
+	slugIndex = "store_slug"
+	skuIndex  = "store_sku"
+
+	// defaultListLimit is the number of products List returns if no limit
+	// is given.
+	defaultListLimit = 20
+)
+
+// Repository reads and writes the products in the MongoDB products
+// collection.
+type Repository struct {
+	conn *data.MongoConn
+}
+
+// NewRepository returns a Repository of the products stored in conn.
+func NewRepository(conn *data.MongoConn) *Repository {
+	return &Repository{conn: conn}
+}
+
+// EnsureIndexes creates the indexes of the products collection, if they don't
+// exist: the slugs and the SKUs are unique in a store, and the products of a
+// store are listed by status and creation date.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	return r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		c := db.C(CollectionName)
+		for _, index := range []mgo.Index{
+			{Key: []string{"store_id", "slug"}, Unique: true, Background: true, Name: slugIndex},
+			{Key: []string{"store_id", "variants.sku"}, Unique: true, Background: true, Name: skuIndex},
+			{Key: []string{"store_id", "status", "-created_at"}, Background: true},
+		} {
+			if err := c.EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindByID returns the product with the given hex encoded id, or ErrNotFound.
+func (r *Repository) FindByID(ctx context.Context, id string) (*Product, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	return r.findOne(ctx, bson.M{"_id": bson.ObjectIdHex(id)})
+}
+
+// FindBySlug returns the product of the store with the given slug, or
+// ErrNotFound.
+func (r *Repository) FindBySlug(ctx context.Context, storeID bson.ObjectId, slug string) (*Product, error) {
+	return r.findOne(ctx, bson.M{"store_id": storeID, "slug": slug})
+}
+
+func (r *Repository) findOne(ctx context.Context, query bson.M) (*Product, error) {
+	p := new(Product)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(query).One(p)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Insert stores a new product, generating its id and setting its creation
+// date. A product without status is a draft. It returns ErrSlugExists or
+// ErrSKUExists if its slug or one of its SKUs is used in the store.
+func (r *Repository) Insert(ctx context.Context, p *Product) error {
+	if p.Id == "" {
+		p.Id = bson.NewObjectId()
+	}
+	if p.CreatedAt.IsZero() {
+		// MongoDB stores milliseconds, keep the product as it is stored.
+		p.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	p.UpdatedAt = p.CreatedAt
+	if p.Status == "" {
+		p.Status = StatusDraft
+	}
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Insert(p)
+	})
+	return dupError(err)
+}
+
+// Update stores the given fields of p, by their BSON names such as "title",
+// matched by id, and sets its update date. The other fields are left as
+// stored: the variants, and their stock, are only replaced if "variants" is
+// given. It returns ErrNotFound if the product doesn't exist, and
+// ErrSlugExists or ErrSKUExists if its slug or one of its SKUs is used by
+// another product of the store.
+func (r *Repository) Update(ctx context.Context, p *Product, fields ...string) error {
+	p.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	update, err := data.SetFields(p, append(fields, "updated_at")...)
+	if err != nil {
+		return err
+	}
+	err = r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).UpdateId(p.Id, update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return dupError(err)
+}
+
+// Delete removes the product with the given id. It returns ErrNotFound if
+// the product doesn't exist.
+func (r *Repository) Delete(ctx context.Context, id bson.ObjectId) error {
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).RemoveId(id)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ListOptions filters and paginates the products returned by List.
+type ListOptions struct {
+	// StoreId matches the products of this store. Empty matches the
+	// products of every store.
+	StoreId bson.ObjectId
+
+	// Status matches the products with this status. Empty matches every
+	// product.
+	Status string
+
+	// Category matches the products of this category. Empty matches every
+	// product.
+	Category string
+
+	// Search matches the products whose title or SKUs contain it,
+	// case-insensitively. Empty matches every product.
+	Search string
+
+	// Offset is the number of matching products skipped.
+	Offset int
+
+	// Limit is the maximum number of products returned. Defaults to 20.
+	Limit int
+}
+
+// List returns a page of the products matching opts, most recent first, along
+// with the total number of matching products.
+func (r *Repository) List(ctx context.Context, opts ListOptions) ([]Product, int, error) {
+	query := bson.M{}
+	if opts.StoreId != "" {
+		query["store_id"] = opts.StoreId
+	}
+	if opts.Status != "" {
+		query["status"] = opts.Status
+	}
+	if opts.Category != "" {
+		query["category"] = strings.ToLower(opts.Category)
+	}
+	if search := strings.TrimSpace(opts.Search); search != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		query["$or"] = []bson.M{
+			{"title": pattern},
+			{"variants.sku": pattern},
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var (
+		products []Product
+		total    int
+	)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		q := db.C(CollectionName).Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		return q.Sort("-created_at", "-_id").Skip(opts.Offset).Limit(limit).All(&products)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return products, total, nil
+}
+
+// dupError translates the duplicate key errors of the unique indexes.
+func dupError(err error) error {
+	if !mgo.IsDup(err) {
+		return err
+	}
+	if strings.Contains(err.Error(), skuIndex) {
+		return ErrSKUExists
+	}
+	return ErrSlugExists
+}
@@ -0,0 +1,61 @@
+package product
+
+import (
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/datatest"
+)
+
+type RepositorySuite struct {
+	datatest.Suite
+	products *Repository
+}
+
+var _ = Suite(&RepositorySuite{Suite: datatest.Suite{Name: "products"}})
+
+func (suite *RepositorySuite) SetUpTest(c *C) {
+	suite.products = NewRepository(suite.Conn)
+	suite.Reset(c, suite.products, CollectionName)
+}
+
+// TestUpdateKeepsStock validates that an update leaving out the variants
+// doesn't undo a concurrent change of their stock.
+func (suite *RepositorySuite) TestUpdateKeepsStock(c *C) {
+	p := &Product{
+		StoreId:  bson.NewObjectId(),
+		Slug:     "kemeja",
+		Title:    "Kemeja",
+		Variants: []Variant{{SKU: "KMJ-M", Price: 150000, Stock: 5}},
+	}
+	c.Assert(suite.products.Insert(suite.Ctx, p), IsNil)
+
+	// a sale, while the owner edits the title.
+	err := suite.Conn.DB.C(CollectionName).Update(
+		bson.M{"_id": p.Id, "variants.sku": "KMJ-M"},
+		bson.M{"$inc": bson.M{"variants.$.stock": -1}})
+	c.Assert(err, IsNil)
+	p.Title = "Kemeja Batik"
+	c.Assert(suite.products.Update(suite.Ctx, p, "title"), IsNil)
+
+	stored, err := suite.products.FindByID(suite.Ctx, p.Id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(stored.Title, Equals, "Kemeja Batik")
+	c.Assert(stored.Variants[0].Stock, Equals, 4)
+}
+
+// TestUpdateSKUExists validates that a SKU can't be used by two products of
+// a store.
+func (suite *RepositorySuite) TestUpdateSKUExists(c *C) {
+	storeID := bson.NewObjectId()
+	c.Assert(suite.products.Insert(suite.Ctx, &Product{
+		StoreId: storeID, Slug: "kemeja", Variants: []Variant{{SKU: "KMJ-M"}},
+	}), IsNil)
+	p := &Product{StoreId: storeID, Slug: "celana", Variants: []Variant{{SKU: "CLN-M"}}}
+	c.Assert(suite.products.Insert(suite.Ctx, p), IsNil)
+
+	p.Variants[0].SKU = "KMJ-M"
+	c.Assert(suite.products.Update(suite.Ctx, p, "variants"), Equals, ErrSKUExists)
+	p.Slug = "kemeja"
+	c.Assert(suite.products.Update(suite.Ctx, p, "slug"), Equals, ErrSlugExists)
+}
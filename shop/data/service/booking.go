@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	BookingCollectionName = "bookings"
+)
+
+// The statuses of a booking.
+const (
+	// BookingPending is the status of the new and rescheduled bookings,
+	// waiting for the store to confirm them. They hold their slot.
+	BookingPending = "pending"
+
+	// BookingConfirmed is the status of the bookings accepted by the store.
+	BookingConfirmed = "confirmed"
+
+	// BookingCancelled is the status of the bookings cancelled by the
+	// customer or the store. Their slot is free again.
+	BookingCancelled = "cancelled"
+)
+
+// MaxNoteLength is the maximum length of the notes of the bookings, in
+// characters.
+const MaxNoteLength = 1000
+
+// MaxPendingBookings is the number of upcoming bookings a customer may have
+// pending at once, so that a single account can't hold every slot of a
+// service until the store turns them down. It is a soft limit, checked
+// before the bookings are written: concurrent requests may exceed it.
+const MaxPendingBookings = 5
+
+var (
+	// ErrBookingState is returned when a booking can't change to the
+	// requested state: it is cancelled, or its slot has begun.
+	ErrBookingState = errors.New("the booking can't be changed anymore")
+
+	// ErrNoteInvalid is returned when the note of a booking is too long.
+	ErrNoteInvalid = errors.New("the note must have at most 1000 characters")
+)
+
+// Booking is a slot of a service reserved by a customer.
+type Booking struct {
+	Id         bson.ObjectId `bson:"_id,omitempty"`
+	ServiceId  bson.ObjectId `bson:"service_id"`
+	StoreId    bson.ObjectId `bson:"store_id"`
+	CustomerId bson.ObjectId `bson:"customer_id"`
+	Start      time.Time     `bson:"start"`
+	End        time.Time     `bson:"end"`
+
+	// Price is the price of the service when it was booked.
+	Price  int64  `bson:"price"`
+	Note   string `bson:"note,omitempty"`
+	Status string `bson:"status"`
+
+	// Active and Steps are maintained by the repository. The unique index
+	// on the steps of the active bookings of a service, the Step long
+	// periods they cover, prevents overlapping bookings.
+	Active bool        `bson:"active"`
+	Steps  []time.Time `bson:"steps"`
+
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+type SerializeBooking struct {
+	Id         string    `json:"id"`
+	ServiceId  string    `json:"service_id"`
+	StoreId    string    `json:"store_id"`
+	CustomerId string    `json:"customer_id"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Price      int64     `json:"price"`
+	Note       string    `json:"note"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// NewBooking returns a pending booking of the slot of s starting at start.
+// The caller checks that the slot is available.
+func NewBooking(s *Service, customerID bson.ObjectId, start time.Time, note string) (*Booking, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > MaxNoteLength {
+		return nil, ErrNoteInvalid
+	}
+	start = start.UTC()
+	return &Booking{
+		ServiceId:  s.Id,
+		StoreId:    s.StoreId,
+		CustomerId: customerID,
+		Start:      start,
+		End:        start.Add(time.Duration(s.Duration) * time.Minute),
+		Price:      s.Price,
+		Note:       note,
+		Status:     BookingPending,
+	}, nil
+}
+
+// Serialize returns the representation of the booking sent to clients.
+func (b *Booking) Serialize() *SerializeBooking {
+	return &SerializeBooking{
+		Id:         b.Id.Hex(),
+		ServiceId:  b.ServiceId.Hex(),
+		StoreId:    b.StoreId.Hex(),
+		CustomerId: b.CustomerId.Hex(),
+		Start:      b.Start,
+		End:        b.End,
+		Price:      b.Price,
+		Note:       b.Note,
+		Status:     b.Status,
+		CreatedAt:  b.CreatedAt,
+		UpdatedAt:  b.UpdatedAt,
+	}
+}
+
+// IsCustomer reports whether the user with the given id made the booking.
+func (b *Booking) IsCustomer(id bson.ObjectId) bool {
+	return id != "" && b.CustomerId == id
+}
+
+// ValidBookingStatus reports whether status is a known booking status.
+func ValidBookingStatus(status string) bool {
+	switch status {
+	case BookingPending, BookingConfirmed, BookingCancelled:
+		return true
+	}
+	return false
+}
+
+// Confirm accepts the pending booking, before its slot begins.
+func (b *Booking) Confirm(now time.Time) error {
+	if b.Status != BookingPending || !now.Before(b.Start) {
+		return ErrBookingState
+	}
+	b.Status = BookingConfirmed
+	return nil
+}
+
+// Cancel frees the slot of the booking, before it begins.
+func (b *Booking) Cancel(now time.Time) error {
+	if b.Status == BookingCancelled || !now.Before(b.Start) {
+		return ErrBookingState
+	}
+	b.Status = BookingCancelled
+	return nil
+}
+
+// Reschedule moves the booking to the slot of s starting at start, before
+// its current slot begins. The booking is pending until the store confirms
+// the new slot. The caller checks that the slot is available.
+func (b *Booking) Reschedule(s *Service, start time.Time, now time.Time) error {
+	if b.Status == BookingCancelled || !now.Before(b.Start) {
+		return ErrBookingState
+	}
+	b.Start = start.UTC()
+	b.End = b.Start.Add(time.Duration(s.Duration) * time.Minute)
+	b.Status = BookingPending
+	return nil
+}
+
+// Overlaps reports whether the booking holds a slot overlapping the period
+// from start to end.
+func (b *Booking) Overlaps(start, end time.Time) bool {
+	return b.Status != BookingCancelled && b.Start.Before(end) && start.Before(b.End)
+}
+
+// lock sets the fields the unique index of the bookings relies on.
+func (b *Booking) lock() {
+	b.Active = b.Status != BookingCancelled
+	b.Steps = nil
+	if !b.Active {
+		return
+	}
+	for step := b.Start.Truncate(Step); step.Before(b.End); step = step.Add(Step) {
+		b.Steps = append(b.Steps, step)
+	}
+}
@@ -0,0 +1,398 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	// ErrNotFound is returned when no service matches a lookup.
+	ErrNotFound = errors.New("service not found")
+
+	// ErrSlugExists is returned when the slug of a service being saved is
+	// used by another service of the store.
+	ErrSlugExists = errors.New("service slug already taken")
+
+	// ErrBookingNotFound is returned when no booking matches a lookup.
+	ErrBookingNotFound = errors.New("booking not found")
+
+	// ErrSlotTaken is returned when a booking being saved overlaps another
+	// booking of the service.
+	ErrSlotTaken = errors.New("slot already booked")
+
+	// ErrBookingChanged is returned when a booking being updated was
+	// changed since it was read.
+	ErrBookingChanged = errors.New("booking changed concurrently")
+)
+
+// defaultListLimit is the number of services or bookings the lists return
+// if no limit is given.
+const defaultListLimit = 20
+
+// Repository reads and writes the services and their bookings in the MongoDB
+// services and bookings collections.
+type Repository struct {
+	conn *data.MongoConn
+}
+
+// NewRepository returns a Repository of the services stored in conn.
+func NewRepository(conn *data.MongoConn) *Repository {
+	return &Repository{conn: conn}
+}
+
+// EnsureIndexes creates the indexes of the services and bookings collections,
+// if they don't exist. The slugs of the services are unique in a store, and
+// the steps of the active bookings are unique for a service: this is what
+// prevents double bookings, whatever the number of concurrent requests.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	return r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		c := db.C(CollectionName)
+		for _, index := range []mgo.Index{
+			{Key: []string{"store_id", "slug"}, Unique: true, Background: true},
+			{Key: []string{"store_id", "status", "-created_at"}, Background: true},
+			{Key: []string{"status", "-created_at"}, Background: true},
+		} {
+			if err := c.EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+
+		c = db.C(BookingCollectionName)
+		for _, index := range []mgo.Index{
+			{
+				Key:           []string{"service_id", "steps"},
+				Unique:        true,
+				PartialFilter: bson.M{"active": true},
+				Background:    true,
+			},
+			{Key: []string{"service_id", "start"}, Background: true},
+			{Key: []string{"customer_id", "start"}, Background: true},
+			{Key: []string{"store_id", "start"}, Background: true},
+		} {
+			if err := c.EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindByID returns the service with the given hex encoded id, or ErrNotFound.
+func (r *Repository) FindByID(ctx context.Context, id string) (*Service, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	return r.findOne(ctx, bson.M{"_id": bson.ObjectIdHex(id)})
+}
+
+// FindBySlug returns the service of the store with the given slug, or
+// ErrNotFound.
+func (r *Repository) FindBySlug(ctx context.Context, storeID bson.ObjectId, slug string) (*Service, error) {
+	return r.findOne(ctx, bson.M{"store_id": storeID, "slug": slug})
+}
+
+func (r *Repository) findOne(ctx context.Context, query bson.M) (*Service, error) {
+	s := new(Service)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(query).One(s)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Insert stores a new service, generating its id and setting its creation
+// date. A service without status is a draft. It returns ErrSlugExists if its
+// slug is used in the store.
+func (r *Repository) Insert(ctx context.Context, s *Service) error {
+	if s.Id == "" {
+		s.Id = bson.NewObjectId()
+	}
+	if s.CreatedAt.IsZero() {
+		// MongoDB stores milliseconds, keep the service as it is stored.
+		s.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	s.UpdatedAt = s.CreatedAt
+	if s.Status == "" {
+		s.Status = StatusDraft
+	}
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Insert(s)
+	})
+	if mgo.IsDup(err) {
+		return ErrSlugExists
+	}
+	return err
+}
+
+// Update stores the given fields of s, by their BSON names such as "title",
+// matched by id, and sets its update date. The other fields are left as
+// stored. It returns ErrNotFound if the service doesn't exist and
+// ErrSlugExists if its new slug is used in the store.
+func (r *Repository) Update(ctx context.Context, s *Service, fields ...string) error {
+	s.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	update, err := data.SetFields(s, append(fields, "updated_at")...)
+	if err != nil {
+		return err
+	}
+	err = r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).UpdateId(s.Id, update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if mgo.IsDup(err) {
+		return ErrSlugExists
+	}
+	return err
+}
+
+// ListOptions filters and paginates the services returned by List.
+type ListOptions struct {
+	// StoreId matches the services of this store. Empty matches the
+	// services of every store.
+	StoreId bson.ObjectId
+
+	// Status matches the services with this status. Empty matches every
+	// service.
+	Status string
+
+	// Category matches the services of this category. Empty matches every
+	// service.
+	Category string
+
+	// Search matches the services whose title contains it,
+	// case-insensitively. Empty matches every service.
+	Search string
+
+	// Offset is the number of matching services skipped.
+	Offset int
+
+	// Limit is the maximum number of services returned. Defaults to 20.
+	Limit int
+}
+
+// List returns a page of the services matching opts, most recent first, along
+// with the total number of matching services.
+func (r *Repository) List(ctx context.Context, opts ListOptions) ([]Service, int, error) {
+	query := bson.M{}
+	if opts.StoreId != "" {
+		query["store_id"] = opts.StoreId
+	}
+	if opts.Status != "" {
+		query["status"] = opts.Status
+	}
+	if opts.Category != "" {
+		query["category"] = strings.ToLower(opts.Category)
+	}
+	if search := strings.TrimSpace(opts.Search); search != "" {
+		query["title"] = bson.RegEx{Pattern: regexp.QuoteMeta(search), Options: "i"}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var (
+		services []Service
+		total    int
+	)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		q := db.C(CollectionName).Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		return q.Sort("-created_at", "-_id").Skip(opts.Offset).Limit(limit).All(&services)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return services, total, nil
+}
+
+// FindBooking returns the booking with the given hex encoded id, or
+// ErrBookingNotFound.
+func (r *Repository) FindBooking(ctx context.Context, id string) (*Booking, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrBookingNotFound
+	}
+	b := new(Booking)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(BookingCollectionName).FindId(bson.ObjectIdHex(id)).One(b)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrBookingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// InsertBooking stores a new booking, generating its id and setting its
+// creation date. It returns ErrSlotTaken if the booking overlaps an active
+// booking of the service.
+func (r *Repository) InsertBooking(ctx context.Context, b *Booking) error {
+	if b.Id == "" {
+		b.Id = bson.NewObjectId()
+	}
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	b.UpdatedAt = b.CreatedAt
+	if b.Status == "" {
+		b.Status = BookingPending
+	}
+	b.lock()
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(BookingCollectionName).Insert(b)
+	})
+	if mgo.IsDup(err) {
+		return ErrSlotTaken
+	}
+	return err
+}
+
+// UpdateBooking replaces the stored booking with b, matched by id, and sets
+// its update date. It returns ErrBookingChanged if the booking was updated
+// since it was read, and ErrSlotTaken if it now overlaps another active
+// booking of the service.
+func (r *Repository) UpdateBooking(ctx context.Context, b *Booking) error {
+	read := b.UpdatedAt
+	b.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if !b.UpdatedAt.After(read) {
+		b.UpdatedAt = read.Add(time.Millisecond)
+	}
+	b.lock()
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(BookingCollectionName).Update(bson.M{"_id": b.Id, "updated_at": read}, b)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrBookingChanged
+	}
+	if mgo.IsDup(err) {
+		return ErrSlotTaken
+	}
+	return err
+}
+
+// CountPendingBookings returns the number of the pending bookings of the
+// customer ending after from.
+func (r *Repository) CountPendingBookings(ctx context.Context, customerID bson.ObjectId, from time.Time) (int, error) {
+	var n int
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		var err error
+		n, err = db.C(BookingCollectionName).Find(bson.M{
+			"customer_id": customerID,
+			"status":      BookingPending,
+			"end":         bson.M{"$gt": from},
+		}).Count()
+		return err
+	})
+	return n, err
+}
+
+// Booked returns the active bookings of the service overlapping the period
+// from start to end, by start.
+func (r *Repository) Booked(ctx context.Context, serviceID bson.ObjectId, start, end time.Time) ([]Booking, error) {
+	var bookings []Booking
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(BookingCollectionName).Find(bson.M{
+			"service_id": serviceID,
+			"active":     true,
+			"start":      bson.M{"$lt": end},
+			"end":        bson.M{"$gt": start},
+		}).Sort("start").All(&bookings)
+	})
+	return bookings, err
+}
+
+// BookingListOptions filters and paginates the bookings returned by
+// ListBookings. At least one of the ids should be given.
+type BookingListOptions struct {
+	// ServiceId matches the bookings of this service.
+	ServiceId bson.ObjectId
+
+	// StoreId matches the bookings of the services of this store.
+	StoreId bson.ObjectId
+
+	// CustomerId matches the bookings of this customer.
+	CustomerId bson.ObjectId
+
+	// Status matches the bookings with this status. Empty matches every
+	// booking.
+	Status string
+
+	// From matches the bookings ending after it. Zero matches every
+	// booking.
+	From time.Time
+
+	// Offset is the number of matching bookings skipped.
+	Offset int
+
+	// Limit is the maximum number of bookings returned. Defaults to 20.
+	Limit int
+}
+
+// ListBookings returns a page of the bookings matching opts, by start, along
+// with the total number of matching bookings.
+func (r *Repository) ListBookings(ctx context.Context, opts BookingListOptions) ([]Booking, int, error) {
+	query := bson.M{}
+	if opts.ServiceId != "" {
+		query["service_id"] = opts.ServiceId
+	}
+	if opts.StoreId != "" {
+		query["store_id"] = opts.StoreId
+	}
+	if opts.CustomerId != "" {
+		query["customer_id"] = opts.CustomerId
+	}
+	if opts.Status != "" {
+		query["status"] = opts.Status
+	}
+	if !opts.From.IsZero() {
+		query["end"] = bson.M{"$gt": opts.From}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var (
+		bookings []Booking
+		total    int
+	)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		q := db.C(BookingCollectionName).Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		return q.Sort("start", "_id").Skip(opts.Offset).Limit(limit).All(&bookings)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bookings, total, nil
+}
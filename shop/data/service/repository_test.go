@@ -0,0 +1,153 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/datatest"
+)
+
+type RepositorySuite struct {
+	datatest.Suite
+	services *Repository
+}
+
+var _ = Suite(&RepositorySuite{Suite: datatest.Suite{Name: "services"}})
+
+func (suite *RepositorySuite) SetUpTest(c *C) {
+	suite.services = NewRepository(suite.Conn)
+	suite.Reset(c, suite.services, CollectionName, BookingCollectionName)
+}
+
+// book inserts a booking of s for a new customer, starting at start.
+func (suite *RepositorySuite) book(c *C, s *Service, start string) (*Booking, error) {
+	b, err := NewBooking(s, bson.NewObjectId(), wib(start), "")
+	c.Assert(err, IsNil)
+	return b, suite.services.InsertBooking(suite.Ctx, b)
+}
+
+// session returns a service to book, as stored.
+func session() *Service {
+	s := photoSession()
+	s.Id = bson.NewObjectId()
+	s.StoreId = bson.NewObjectId()
+	return s
+}
+
+// TestOverlappingBookings validates that a booking overlapping another,
+// whatever its start, is refused.
+func (suite *RepositorySuite) TestOverlappingBookings(c *C) {
+	s := session()
+	_, err := suite.book(c, s, "2026-10-19T10:00:00")
+	c.Assert(err, IsNil)
+
+	_, err = suite.book(c, s, "2026-10-19T10:30:00")
+	c.Assert(err, Equals, ErrSlotTaken)
+	_, err = suite.book(c, s, "2026-10-19T09:15:00")
+	c.Assert(err, Equals, ErrSlotTaken)
+	_, err = suite.book(c, s, "2026-10-19T11:00:00")
+	c.Assert(err, IsNil)
+
+	// the same slot of another service is free.
+	_, err = suite.book(c, session(), "2026-10-19T10:30:00")
+	c.Assert(err, IsNil)
+}
+
+// TestConcurrentBookings validates that of the concurrent bookings of
+// overlapping slots, only one is stored.
+func (suite *RepositorySuite) TestConcurrentBookings(c *C) {
+	s := session()
+	starts := []string{"2026-10-19T10:00:00", "2026-10-19T10:15:00", "2026-10-19T10:30:00", "2026-10-19T10:45:00", "2026-10-19T10:00:00"}
+
+	errs := make([]error, len(starts))
+	var wg sync.WaitGroup
+	for i, start := range starts {
+		b, err := NewBooking(s, bson.NewObjectId(), wib(start), "")
+		c.Assert(err, IsNil)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = suite.services.InsertBooking(suite.Ctx, b)
+		}(i)
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, err := range errs {
+		if err == nil {
+			booked++
+		} else {
+			c.Assert(err, Equals, ErrSlotTaken)
+		}
+	}
+	c.Assert(booked, Equals, 1)
+}
+
+// TestCancelFreesSlot validates that the slot of a cancelled booking can be
+// booked again.
+func (suite *RepositorySuite) TestCancelFreesSlot(c *C) {
+	s := session()
+	b, err := suite.book(c, s, "2026-10-19T10:00:00")
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Cancel(wib("2026-10-18T12:00:00")), IsNil)
+	c.Assert(suite.services.UpdateBooking(suite.Ctx, b), IsNil)
+	_, err = suite.book(c, s, "2026-10-19T10:00:00")
+	c.Assert(err, IsNil)
+}
+
+// TestRescheduleTaken validates that a booking can't be moved to a slot
+// overlapping another booking.
+func (suite *RepositorySuite) TestRescheduleTaken(c *C) {
+	s := session()
+	_, err := suite.book(c, s, "2026-10-19T10:00:00")
+	c.Assert(err, IsNil)
+	b, err := suite.book(c, s, "2026-10-19T13:00:00")
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Reschedule(s, wib("2026-10-19T10:30:00"), wib("2026-10-18T12:00:00")), IsNil)
+	c.Assert(suite.services.UpdateBooking(suite.Ctx, b), Equals, ErrSlotTaken)
+}
+
+// TestRescheduleOverlapsItself validates that a booking can be moved to a
+// slot overlapping its own, and that it frees the rest of its old slot.
+func (suite *RepositorySuite) TestRescheduleOverlapsItself(c *C) {
+	s := session()
+	b, err := suite.book(c, s, "2026-10-19T10:00:00")
+	c.Assert(err, IsNil)
+
+	c.Assert(b.Reschedule(s, wib("2026-10-19T10:30:00"), wib("2026-10-18T12:00:00")), IsNil)
+	c.Assert(suite.services.UpdateBooking(suite.Ctx, b), IsNil)
+
+	stored, err := suite.services.FindBooking(suite.Ctx, b.Id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(stored.Start.Equal(wib("2026-10-19T10:30:00")), Equals, true)
+	_, err = suite.book(c, s, "2026-10-19T09:30:00")
+	c.Assert(err, IsNil)
+}
+
+// TestCountPendingBookings validates that only the upcoming pending bookings
+// of the customer are counted.
+func (suite *RepositorySuite) TestCountPendingBookings(c *C) {
+	s := session()
+	customer := bson.NewObjectId()
+	now := wib("2026-10-19T00:00:00")
+
+	for i, start := range []string{"2026-10-18T10:00:00", "2026-10-19T10:00:00", "2026-10-19T13:00:00", "2026-10-20T22:00:00"} {
+		b, err := NewBooking(s, customer, wib(start), "")
+		c.Assert(err, IsNil)
+		if i == 2 {
+			c.Assert(b.Confirm(now), IsNil)
+		}
+		c.Assert(suite.services.InsertBooking(suite.Ctx, b), IsNil)
+	}
+	other, err := NewBooking(s, bson.NewObjectId(), wib("2026-10-19T11:00:00"), "")
+	c.Assert(err, IsNil)
+	c.Assert(suite.services.InsertBooking(suite.Ctx, other), IsNil)
+
+	n, err := suite.services.CountPendingBookings(suite.Ctx, customer, now)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
@@ -0,0 +1,408 @@
+// Package service holds the services ("jasa") offered by the stores, e.g. a
+// photo session or a tailoring appointment. A service is booked by the
+// customers in slots of its duration, within its weekly availability.
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// The time zones of the services are loaded from the embedded
+	// database, the system one may be missing from the images.
+	_ "time/tzdata"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	CollectionName = "services"
+)
+
+// The statuses of a service.
+const (
+	// StatusDraft is the status of the services being prepared, only
+	// visible to the owner of the store.
+	StatusDraft = "draft"
+
+	// StatusPublished is the status of the services open to bookings.
+	StatusPublished = "published"
+
+	// StatusArchived is the status of the services no longer offered, only
+	// visible to the owner of the store. Their bookings are kept.
+	StatusArchived = "archived"
+)
+
+// Step is the granularity of the schedules: the availability hours and the
+// durations are multiples of it.
+const Step = 15 * time.Minute
+
+// DefaultTimeZone is the time zone of the services that don't set one.
+const DefaultTimeZone = "Asia/Jakarta"
+
+// The limits of the services.
+const (
+	MaxTitleLength       = 120
+	MaxDescriptionLength = 5000
+	MaxCategoryLength    = 50
+	MaxLocationLength    = 200
+	MaxImages            = 10
+	MaxExceptions        = 100
+
+	// MaxDuration is the maximum duration of a service, in minutes.
+	MaxDuration = 12 * 60
+)
+
+// FieldError tells which field of a service is invalid, and why.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Hours is a period of a day, from Start to End, both formatted as "15:04"
+// in the time zone of the service. End may be "24:00".
+type Hours struct {
+	Start string `bson:"start" json:"start"`
+	End   string `bson:"end" json:"end"`
+}
+
+// Rule gives the hours a service is available on a day of the week.
+type Rule struct {
+	Weekday time.Weekday `bson:"weekday" json:"weekday"`
+	Hours   []Hours      `bson:"hours" json:"hours"`
+}
+
+// Exception replaces the weekly hours of a service on a date, formatted as
+// "2006-01-02". An exception without hours closes the service that day.
+type Exception struct {
+	Date  string  `bson:"date" json:"date"`
+	Hours []Hours `bson:"hours,omitempty" json:"hours"`
+}
+
+// Availability tells when a service can be booked.
+type Availability struct {
+	Weekly     []Rule      `bson:"weekly" json:"weekly"`
+	Exceptions []Exception `bson:"exceptions,omitempty" json:"exceptions"`
+}
+
+type Service struct {
+	Id          bson.ObjectId `bson:"_id,omitempty"`
+	StoreId     bson.ObjectId `bson:"store_id"`
+	Slug        string        `bson:"slug"`
+	Title       string        `bson:"title"`
+	Description string        `bson:"description,omitempty"`
+	Images      []string      `bson:"images,omitempty"`
+	Category    string        `bson:"category,omitempty"`
+
+	// Duration is in minutes, it is the length of the slots.
+	Duration int `bson:"duration"`
+
+	// Price is in the smallest unit of the currency.
+	Price int64 `bson:"price"`
+
+	// Location is where the service takes place, it may be empty for the
+	// remote services.
+	Location     string       `bson:"location,omitempty"`
+	Remote       bool         `bson:"remote"`
+	TimeZone     string       `bson:"time_zone"`
+	Availability Availability `bson:"availability"`
+	Status       string       `bson:"status"`
+	CreatedAt    time.Time    `bson:"created_at"`
+	UpdatedAt    time.Time    `bson:"updated_at"`
+	PublishedAt  *time.Time   `bson:"published_at,omitempty"`
+}
+
+type SerializeService struct {
+	Id           string       `json:"id"`
+	StoreId      string       `json:"store_id"`
+	Slug         string       `json:"slug"`
+	Title        string       `json:"title"`
+	Description  string       `json:"description"`
+	Images       []string     `json:"images"`
+	Category     string       `json:"category"`
+	Duration     int          `json:"duration"`
+	Price        int64        `json:"price"`
+	Location     string       `json:"location"`
+	Remote       bool         `json:"remote"`
+	TimeZone     string       `json:"time_zone"`
+	Availability Availability `json:"availability"`
+	Status       string       `json:"status"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	PublishedAt  *time.Time   `json:"published_at,omitempty"`
+}
+
+// Serialize returns the representation of the service sent to clients.
+func (s *Service) Serialize() *SerializeService {
+	ss := &SerializeService{
+		Id:           s.Id.Hex(),
+		StoreId:      s.StoreId.Hex(),
+		Slug:         s.Slug,
+		Title:        s.Title,
+		Description:  s.Description,
+		Images:       s.Images,
+		Category:     s.Category,
+		Duration:     s.Duration,
+		Price:        s.Price,
+		Location:     s.Location,
+		Remote:       s.Remote,
+		TimeZone:     s.TimeZone,
+		Availability: s.Availability,
+		Status:       s.Status,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+		PublishedAt:  s.PublishedAt,
+	}
+	if ss.Images == nil {
+		ss.Images = []string{}
+	}
+	if ss.Availability.Weekly == nil {
+		ss.Availability.Weekly = []Rule{}
+	}
+	if ss.Availability.Exceptions == nil {
+		ss.Availability.Exceptions = []Exception{}
+	}
+	return ss
+}
+
+// Published reports whether the service is open to bookings.
+func (s *Service) Published() bool {
+	return s.Status == StatusPublished
+}
+
+// SetStatus changes the status of the service, recording when it is first
+// published.
+func (s *Service) SetStatus(status string) {
+	s.Status = status
+	if status == StatusPublished && s.PublishedAt == nil {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		s.PublishedAt = &now
+	}
+}
+
+// ValidStatus reports whether status is a known status.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusDraft, StatusPublished, StatusArchived:
+		return true
+	}
+	return false
+}
+
+// Normalize trims the text fields of the service and defaults its time zone.
+func (s *Service) Normalize() {
+	s.Title = strings.TrimSpace(s.Title)
+	s.Description = strings.TrimSpace(s.Description)
+	s.Category = strings.ToLower(strings.TrimSpace(s.Category))
+	s.Location = strings.TrimSpace(s.Location)
+	s.TimeZone = strings.TrimSpace(s.TimeZone)
+	if s.TimeZone == "" {
+		s.TimeZone = DefaultTimeZone
+	}
+	for i := range s.Images {
+		s.Images[i] = strings.TrimSpace(s.Images[i])
+	}
+}
+
+// Validate checks the fields of the service, the slug excepted, returning a
+// *FieldError for the first invalid one.
+func (s *Service) Validate() error {
+	if s.Title == "" || utf8.RuneCountInString(s.Title) > MaxTitleLength {
+		return invalid("title", "the title must have 1 to %d characters", MaxTitleLength)
+	}
+	if utf8.RuneCountInString(s.Description) > MaxDescriptionLength {
+		return invalid("description", "the description must have at most %d characters", MaxDescriptionLength)
+	}
+	if utf8.RuneCountInString(s.Category) > MaxCategoryLength {
+		return invalid("category", "the category must have at most %d characters", MaxCategoryLength)
+	}
+	if !ValidStatus(s.Status) {
+		return invalid("status", "the status must be draft, published or archived")
+	}
+
+	if len(s.Images) > MaxImages {
+		return invalid("images", "a service has at most %d images", MaxImages)
+	}
+	for _, image := range s.Images {
+		u, err := url.Parse(image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("images", "the images must be http or https URLs")
+		}
+	}
+
+	step := int(Step / time.Minute)
+	if s.Duration < step || s.Duration > MaxDuration || s.Duration%step != 0 {
+		return invalid("duration", "the duration must be a multiple of %d minutes, up to %d", step, MaxDuration)
+	}
+	if s.Price < 0 {
+		return invalid("price", "the price must be positive")
+	}
+	if utf8.RuneCountInString(s.Location) > MaxLocationLength {
+		return invalid("location", "the location must have at most %d characters", MaxLocationLength)
+	}
+	if s.Location == "" && !s.Remote {
+		return invalid("location", "the services that aren't remote must have a location")
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil || s.TimeZone == "Local" {
+		return invalid("time_zone", "unknown time zone %s", s.TimeZone)
+	}
+
+	return s.Availability.validate()
+}
+
+func (a *Availability) validate() error {
+	weekdays := make(map[time.Weekday]bool, len(a.Weekly))
+	for _, rule := range a.Weekly {
+		if rule.Weekday < time.Sunday || rule.Weekday > time.Saturday || weekdays[rule.Weekday] {
+			return invalid("availability", "the weekdays must be distinct, from 0 (Sunday) to 6 (Saturday)")
+		}
+		weekdays[rule.Weekday] = true
+		if _, err := minutes(rule.Hours); err != nil {
+			return invalid("availability", "%s: %v", rule.Weekday, err)
+		}
+	}
+
+	if len(a.Exceptions) > MaxExceptions {
+		return invalid("availability", "a service has at most %d exceptions", MaxExceptions)
+	}
+	dates := make(map[string]bool, len(a.Exceptions))
+	for _, exception := range a.Exceptions {
+		date, err := time.Parse("2006-01-02", exception.Date)
+		if err != nil || date.Format("2006-01-02") != exception.Date || dates[exception.Date] {
+			return invalid("availability", "the exceptions must have distinct dates, formatted as 2006-01-02")
+		}
+		dates[exception.Date] = true
+		if _, err := minutes(exception.Hours); err != nil {
+			return invalid("availability", "%s: %v", exception.Date, err)
+		}
+	}
+	return nil
+}
+
+// hours returns the hours the service is available on the day of date, in
+// minutes since midnight, the exceptions taking precedence over the weekly
+// rules.
+func (a *Availability) hours(date time.Time) [][2]int {
+	day := date.Format("2006-01-02")
+	for _, exception := range a.Exceptions {
+		if exception.Date == day {
+			periods, _ := minutes(exception.Hours)
+			return periods
+		}
+	}
+	for _, rule := range a.Weekly {
+		if rule.Weekday == date.Weekday() {
+			periods, _ := minutes(rule.Hours)
+			return periods
+		}
+	}
+	return nil
+}
+
+// minutes parses the hours into sorted periods, in minutes since midnight,
+// checking that they are aligned on Step and don't overlap.
+func minutes(hours []Hours) ([][2]int, error) {
+	periods := make([][2]int, len(hours))
+	for i, h := range hours {
+		start, err := clock(h.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := clock(h.End)
+		if err != nil {
+			return nil, err
+		}
+		if start >= end || start == 24*60 {
+			return nil, fmt.Errorf("the hours %s-%s end before they start", h.Start, h.End)
+		}
+		periods[i] = [2]int{start, end}
+	}
+
+	sort.Slice(periods, func(i, j int) bool { return periods[i][0] < periods[j][0] })
+	for i := 1; i < len(periods); i++ {
+		if periods[i][0] < periods[i-1][1] {
+			return nil, fmt.Errorf("the hours overlap")
+		}
+	}
+	return periods, nil
+}
+
+// clock parses a "15:04" time of the day, or "24:00", into minutes since
+// midnight.
+func clock(value string) (int, error) {
+	err := fmt.Errorf("the hour %q must be formatted as 15:04, in steps of %d minutes", value, Step/time.Minute)
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, err
+	}
+	h, herr := strconv.Atoi(parts[0])
+	m, merr := strconv.Atoi(parts[1])
+	if herr != nil || merr != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, err
+	}
+	if m%int(Step/time.Minute) != 0 {
+		return 0, err
+	}
+	return h*60 + m, nil
+}
+
+// Zone returns the time zone of the service.
+func (s *Service) Zone() *time.Location {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimeZone)
+	}
+	return loc
+}
+
+// Slots returns the start of the slots of the service beginning in between
+// from, included, and to, excluded, in its time zone. The slots follow each
+// other from the start of the available hours, the bookings aren't taken into
+// account.
+func (s *Service) Slots(from, to time.Time) []time.Time {
+	loc := s.Zone()
+	from, to = from.In(loc), to.In(loc)
+	duration := time.Duration(s.Duration) * time.Minute
+	if duration <= 0 {
+		return nil
+	}
+
+	var slots []time.Time
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, period := range s.Availability.hours(day) {
+			// time.Date rather than day.Add keeps the wall clock on the
+			// days the offset of the time zone changes.
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, period[0], 0, 0, loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), 0, period[1], 0, 0, loc)
+			for slot := start; !slot.Add(duration).After(end); slot = slot.Add(duration) {
+				if !slot.Before(from) && slot.Before(to) {
+					slots = append(slots, slot)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// Available reports whether a slot of the service starts at start.
+func (s *Service) Available(start time.Time) bool {
+	for _, slot := range s.Slots(start, start.Add(time.Minute)) {
+		if slot.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type ServiceSuite struct{}
+
+var _ = Suite(new(ServiceSuite))
+
+func photoSession() *Service {
+	return &Service{
+		Title:    "Sesi Foto",
+		Status:   StatusPublished,
+		Duration: 60,
+		Price:    250000,
+		Location: "Jl. Malioboro 1, Yogyakarta",
+		TimeZone: "Asia/Jakarta",
+		Availability: Availability{
+			Weekly: []Rule{
+				{Weekday: time.Monday, Hours: []Hours{{"13:00", "15:30"}, {"09:00", "12:00"}}},
+				{Weekday: time.Tuesday, Hours: []Hours{{"22:00", "24:00"}}},
+			},
+			Exceptions: []Exception{
+				{Date: "2026-10-26"},
+				{Date: "2026-10-25", Hours: []Hours{{"10:00", "11:00"}}},
+			},
+		},
+	}
+}
+
+func wib(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value+"+07:00")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// TestSlots checks the slots of the weekly rules and of the exceptions.
+func (suite *ServiceSuite) TestSlots(c *C) {
+	s := photoSession()
+	c.Assert(s.Validate(), IsNil)
+
+	var starts []string
+	for _, slot := range s.Slots(wib("2026-10-19T00:00:00"), wib("2026-10-28T00:00:00")) {
+		starts = append(starts, slot.Format("2006-01-02 15:04"))
+	}
+	c.Assert(starts, DeepEquals, []string{
+		"2026-10-19 09:00", "2026-10-19 10:00", "2026-10-19 11:00",
+		"2026-10-19 13:00", "2026-10-19 14:00",
+		"2026-10-20 22:00", "2026-10-20 23:00",
+		"2026-10-25 10:00",
+		"2026-10-27 22:00", "2026-10-27 23:00",
+	})
+
+	// The bounds apply to the start of the slots.
+	c.Assert(s.Slots(wib("2026-10-19T09:30:00"), wib("2026-10-19T11:00:00")), DeepEquals,
+		[]time.Time{wib("2026-10-19T10:00:00").In(s.Zone())})
+
+	c.Assert(s.Available(wib("2026-10-19T10:00:00")), Equals, true)
+	c.Assert(s.Available(wib("2026-10-19T10:00:00").UTC()), Equals, true)
+	c.Assert(s.Available(wib("2026-10-19T10:30:00")), Equals, false)
+	c.Assert(s.Available(wib("2026-10-19T15:00:00")), Equals, false)
+	c.Assert(s.Available(wib("2026-10-26T10:00:00")), Equals, false)
+}
+
+// TestValidate validates the checks of the fields and of the availability.
+func (suite *ServiceSuite) TestValidate(c *C) {
+	for _, tc := range []struct {
+		field  string
+		change func(s *Service)
+	}{
+		{"title", func(s *Service) { s.Title = "" }},
+		{"duration", func(s *Service) { s.Duration = 50 }},
+		{"duration", func(s *Service) { s.Duration = 0 }},
+		{"price", func(s *Service) { s.Price = -1 }},
+		{"location", func(s *Service) { s.Location = "" }},
+		{"time_zone", func(s *Service) { s.TimeZone = "Asia/Nowhere" }},
+		{"availability", func(s *Service) { s.Availability.Weekly[1].Weekday = time.Monday }},
+		{"availability", func(s *Service) { s.Availability.Weekly[0].Hours[0].End = "09:30" }},
+		{"availability", func(s *Service) { s.Availability.Weekly[0].Hours[0].End = "11:30" }},
+		{"availability", func(s *Service) { s.Availability.Weekly[0].Hours[0].Start = "13:10" }},
+		{"availability", func(s *Service) { s.Availability.Weekly[0].Hours[0].Start = "24:00" }},
+		{"availability", func(s *Service) { s.Availability.Exceptions[0].Date = "2026-02-30" }},
+		{"availability", func(s *Service) { s.Availability.Exceptions[0].Date = "2026-10-25" }},
+	} {
+		s := photoSession()
+		tc.change(s)
+		s.Normalize()
+		err := s.Validate()
+		c.Assert(err, FitsTypeOf, &FieldError{})
+		c.Assert(err.(*FieldError).Field, Equals, tc.field, Commentf("error %v", err))
+	}
+
+	// The remote services don't need a location.
+	s := photoSession()
+	s.Location, s.Remote, s.TimeZone = "", true, ""
+	s.Normalize()
+	c.Assert(s.Validate(), IsNil)
+	c.Assert(s.TimeZone, Equals, DefaultTimeZone)
+}
+
+// TestBooking checks the transitions of the bookings and the steps they
+// lock.
+func (suite *ServiceSuite) TestBooking(c *C) {
+	s := photoSession()
+	b, err := NewBooking(s, "", wib("2026-10-19T10:00:00"), " Foto keluarga ")
+	c.Assert(err, IsNil)
+	c.Assert(b.Note, Equals, "Foto keluarga")
+	c.Assert(b.End.Sub(b.Start), Equals, time.Hour)
+
+	b.lock()
+	c.Assert(b.Active, Equals, true)
+	c.Assert(b.Steps, HasLen, 4)
+	c.Assert(b.Steps[3].Equal(wib("2026-10-19T10:45:00")), Equals, true)
+
+	before := wib("2026-10-18T12:00:00")
+	c.Assert(b.Overlaps(wib("2026-10-19T10:30:00"), wib("2026-10-19T11:30:00")), Equals, true)
+	c.Assert(b.Overlaps(wib("2026-10-19T11:00:00"), wib("2026-10-19T12:00:00")), Equals, false)
+
+	c.Assert(b.Confirm(wib("2026-10-19T10:00:00")), Equals, ErrBookingState)
+	c.Assert(b.Confirm(before), IsNil)
+	c.Assert(b.Confirm(before), Equals, ErrBookingState)
+
+	c.Assert(b.Reschedule(s, wib("2026-10-19T13:00:00"), before), IsNil)
+	c.Assert(b.Status, Equals, BookingPending)
+	c.Assert(b.End.Equal(wib("2026-10-19T14:00:00")), Equals, true)
+
+	c.Assert(b.Cancel(before), IsNil)
+	c.Assert(b.Cancel(before), Equals, ErrBookingState)
+	c.Assert(b.Reschedule(s, wib("2026-10-19T14:00:00"), before), Equals, ErrBookingState)
+	c.Assert(b.Overlaps(b.Start, b.End), Equals, false)
+	b.lock()
+	c.Assert(b.Active, Equals, false)
+	c.Assert(b.Steps, HasLen, 0)
+}
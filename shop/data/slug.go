@@ -0,0 +1,56 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// MinSlugLength and MaxSlugLength bound the length of the slugs.
+	MinSlugLength = 3
+	MaxSlugLength = 50
+)
+
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// unaccented maps the common accented latin letters to their base letter.
+var unaccented = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y",
+	"ß", "ss", "æ", "ae", "œ", "oe",
+)
+
+// ValidSlug reports whether slug is made of lowercase letters, digits and
+// single dashes, starting and ending with a letter or a digit, and has a
+// length in between MinSlugLength and MaxSlugLength.
+func ValidSlug(slug string) bool {
+	return len(slug) >= MinSlugLength && len(slug) <= MaxSlugLength && slugRe.MatchString(slug)
+}
+
+// Slugify derives a slug from s, e.g. "Kopi Kenangan Café" gives
+// "kopi-kenangan-cafe". The common accents are removed and the other
+// characters replaced by dashes. The result may be too short to be valid.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range unaccented.Replace(strings.ToLower(s)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > MaxSlugLength {
+		slug = strings.TrimRight(slug[:MaxSlugLength], "-")
+	}
+	return slug
+}
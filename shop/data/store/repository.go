@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	// ErrNotFound is returned when no store matches a lookup.
+	ErrNotFound = errors.New("store not found")
+
+	// ErrSlugExists is returned when the slug of a store being saved is used
+	// by another store.
+	ErrSlugExists = errors.New("store slug already taken")
+)
+
+// defaultListLimit is the number of stores List returns if no limit is given.
+const defaultListLimit = 20
+
+// Repository reads and writes the stores in the MongoDB stores collection.
+type Repository struct {
+	conn *data.MongoConn
+}
+
+// NewRepository returns a Repository of the stores stored in conn.
+func NewRepository(conn *data.MongoConn) *Repository {
+	return &Repository{conn: conn}
+}
+
+// EnsureIndexes creates the indexes of the stores collection, if they don't
+// exist: the unique index of the slugs, the index of the owners and the one
+// of the statuses by creation date.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	return r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		c := db.C(CollectionName)
+		for _, index := range []mgo.Index{
+			{Key: []string{"slug"}, Unique: true, Background: true},
+			{Key: []string{"owner_id"}, Background: true},
+			{Key: []string{"status", "-created_at"}, Background: true},
+		} {
+			if err := c.EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindByID returns the store with the given hex encoded id, or ErrNotFound.
+func (r *Repository) FindByID(ctx context.Context, id string) (*Store, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+	return r.findOne(ctx, bson.M{"_id": bson.ObjectIdHex(id)})
+}
+
+// FindBySlug returns the store with the given slug, or ErrNotFound.
+func (r *Repository) FindBySlug(ctx context.Context, slug string) (*Store, error) {
+	return r.findOne(ctx, bson.M{"slug": slug})
+}
+
+func (r *Repository) findOne(ctx context.Context, query bson.M) (*Store, error) {
+	s := new(Store)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(query).One(s)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// FindByOwner returns the stores of the user with the given id, most recent
+// first.
+func (r *Repository) FindByOwner(ctx context.Context, ownerID bson.ObjectId) ([]Store, error) {
+	var stores []Store
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(bson.M{"owner_id": ownerID}).
+			Sort("-created_at", "-_id").All(&stores)
+	})
+	return stores, err
+}
+
+// Insert stores a new store, generating its id and setting its creation date.
+// A store without status is pending. It returns ErrSlugExists if the slug is
+// taken.
+func (r *Repository) Insert(ctx context.Context, s *Store) error {
+	if s.Id == "" {
+		s.Id = bson.NewObjectId()
+	}
+	if s.CreatedAt.IsZero() {
+		// MongoDB stores milliseconds, keep the store as it is stored.
+		s.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	s.UpdatedAt = s.CreatedAt
+	if s.Status == "" {
+		s.Status = StatusPending
+	}
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Insert(s)
+	})
+	if mgo.IsDup(err) {
+		return ErrSlugExists
+	}
+	return err
+}
+
+// Update stores the given fields of s, by their BSON names such as "name",
+// matched by id, and sets its update date. The other fields are left as
+// stored. It returns ErrNotFound if the store doesn't exist and ErrSlugExists
+// if its new slug is taken.
+func (r *Repository) Update(ctx context.Context, s *Store, fields ...string) error {
+	s.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	update, err := data.SetFields(s, append(fields, "updated_at")...)
+	if err != nil {
+		return err
+	}
+	err = r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).UpdateId(s.Id, update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if mgo.IsDup(err) {
+		return ErrSlugExists
+	}
+	return err
+}
+
+// UpdateStatus stores the status of s, and its update date. It returns
+// ErrNotFound if the store doesn't exist.
+func (r *Repository) UpdateStatus(ctx context.Context, s *Store) error {
+	return r.Update(ctx, s, "status")
+}
+
+// ListOptions filters and paginates the stores returned by List.
+type ListOptions struct {
+	// Status matches the stores with this status. Empty matches every
+	// store.
+	Status string
+
+	// Search matches the stores whose name or slug contain it,
+	// case-insensitively. Empty matches every store.
+	Search string
+
+	// Offset is the number of matching stores skipped.
+	Offset int
+
+	// Limit is the maximum number of stores returned. Defaults to 20.
+	Limit int
+}
+
+// List returns a page of the stores matching opts, most recent first, along
+// with the total number of matching stores.
+func (r *Repository) List(ctx context.Context, opts ListOptions) ([]Store, int, error) {
+	query := bson.M{}
+	if opts.Status != "" {
+		query["status"] = opts.Status
+	}
+	if search := strings.TrimSpace(opts.Search); search != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		query["$or"] = []bson.M{
+			{"name": pattern},
+			{"slug": pattern},
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var (
+		stores []Store
+		total  int
+	)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		q := db.C(CollectionName).Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		return q.Sort("-created_at", "-_id").Skip(opts.Offset).Limit(limit).All(&stores)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return stores, total, nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/datatest"
+)
+
+type RepositorySuite struct {
+	datatest.Suite
+	stores *Repository
+}
+
+var _ = Suite(&RepositorySuite{Suite: datatest.Suite{Name: "stores"}})
+
+func (suite *RepositorySuite) SetUpTest(c *C) {
+	suite.stores = NewRepository(suite.Conn)
+	suite.Reset(c, suite.stores, CollectionName)
+}
+
+// TestUpdateStatus validates that the staff updating the status of a store
+// and its owner updating its other fields don't undo each other.
+func (suite *RepositorySuite) TestUpdateStatus(c *C) {
+	s := &Store{OwnerId: bson.NewObjectId(), Slug: "batik-solo", Name: "Batik Solo", Description: "Batik tulis"}
+	c.Assert(suite.stores.Insert(suite.Ctx, s), IsNil)
+	owner, staff := *s, *s
+
+	staff.Status = StatusActive
+	c.Assert(suite.stores.UpdateStatus(suite.Ctx, &staff), IsNil)
+	owner.Name = "Batik Solo Asli"
+	owner.Description = ""
+	c.Assert(suite.stores.Update(suite.Ctx, &owner, "name", "description"), IsNil)
+
+	stored, err := suite.stores.FindByID(suite.Ctx, s.Id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(stored.Status, Equals, StatusActive)
+	c.Assert(stored.Name, Equals, "Batik Solo Asli")
+	c.Assert(stored.Description, Equals, "")
+	c.Assert(stored.Slug, Equals, "batik-solo")
+}
+
+// TestUpdateSlugExists validates that a store can't take the slug of
+// another.
+func (suite *RepositorySuite) TestUpdateSlugExists(c *C) {
+	c.Assert(suite.stores.Insert(suite.Ctx, &Store{OwnerId: bson.NewObjectId(), Slug: "batik-solo", Name: "Batik"}), IsNil)
+	s := &Store{OwnerId: bson.NewObjectId(), Slug: "kopi", Name: "Kopi"}
+	c.Assert(suite.stores.Insert(suite.Ctx, s), IsNil)
+
+	s.Slug = "batik-solo"
+	c.Assert(suite.stores.Update(suite.Ctx, s, "slug"), Equals, ErrSlugExists)
+	c.Assert(suite.stores.Update(suite.Ctx, &Store{Id: bson.NewObjectId()}, "name"), Equals, ErrNotFound)
+}
@@ -0,0 +1,170 @@
+// Package store holds the stores ("toko") of the marketplace. A store is
+// opened by a user, its owner, and is only shown to the public once a staff
+// member activated it.
+package store
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	CollectionName = "stores"
+)
+
+// The statuses of a store.
+const (
+	// StatusPending is the status of the new stores, waiting to be
+	// reviewed by the staff.
+	StatusPending = "pending"
+
+	// StatusActive is the status of the stores open to the public.
+	StatusActive = "active"
+
+	// StatusSuspended is the status of the stores closed by the staff.
+	StatusSuspended = "suspended"
+)
+
+const (
+	// MaxNameLength is the maximum length of the names, in characters.
+	MaxNameLength = 80
+
+	// MaxDescriptionLength is the maximum length of the descriptions, in
+	// characters.
+	MaxDescriptionLength = 2000
+)
+
+// reservedSlugs can't be used by the stores, they clash with the routes.
+var reservedSlugs = map[string]bool{
+	"mine":  true,
+	"new":   true,
+	"admin": true,
+}
+
+var (
+	// ErrNameInvalid is returned when the name is empty or too long.
+	ErrNameInvalid = errors.New("the name must have 1 to 80 characters")
+
+	// ErrDescriptionInvalid is returned when the description is too long.
+	ErrDescriptionInvalid = errors.New("the description must have at most 2000 characters")
+
+	// ErrLogoInvalid is returned when the logo isn't an http or https URL.
+	ErrLogoInvalid = errors.New("the logo must be an http or https URL")
+
+	// ErrStatusInvalid is returned when the status is unknown.
+	ErrStatusInvalid = errors.New("the status must be pending, active or suspended")
+)
+
+// Address is the postal address of a store.
+type Address struct {
+	Street     string `bson:"street,omitempty" json:"street"`
+	City       string `bson:"city,omitempty" json:"city"`
+	Province   string `bson:"province,omitempty" json:"province"`
+	PostalCode string `bson:"postal_code,omitempty" json:"postal_code"`
+	Country    string `bson:"country,omitempty" json:"country"`
+}
+
+type Store struct {
+	Id          bson.ObjectId `bson:"_id,omitempty"`
+	OwnerId     bson.ObjectId `bson:"owner_id"`
+	Slug        string        `bson:"slug"`
+	Name        string        `bson:"name"`
+	Description string        `bson:"description,omitempty"`
+	Logo        string        `bson:"logo,omitempty"`
+	Address     Address       `bson:"address"`
+	Status      string        `bson:"status"`
+	CreatedAt   time.Time     `bson:"created_at"`
+	UpdatedAt   time.Time     `bson:"updated_at"`
+}
+
+type SerializeStore struct {
+	Id          string    `json:"id"`
+	OwnerId     string    `json:"owner_id"`
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Logo        string    `json:"logo"`
+	Address     Address   `json:"address"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Serialize returns the representation of the store sent to clients.
+func (s *Store) Serialize() *SerializeStore {
+	return &SerializeStore{
+		Id:          s.Id.Hex(),
+		OwnerId:     s.OwnerId.Hex(),
+		Slug:        s.Slug,
+		Name:        s.Name,
+		Description: s.Description,
+		Logo:        s.Logo,
+		Address:     s.Address,
+		Status:      s.Status,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+	}
+}
+
+// IsOwner reports whether the user with the given id owns the store.
+func (s *Store) IsOwner(id bson.ObjectId) bool {
+	return id != "" && s.OwnerId == id
+}
+
+// Public reports whether the store is open to the public.
+func (s *Store) Public() bool {
+	return s.Status == StatusActive
+}
+
+// ValidSlug reports whether slug can be used by a store.
+func ValidSlug(slug string) bool {
+	return data.ValidSlug(slug) && !reservedSlugs[slug]
+}
+
+// ValidStatus reports whether status is a known status.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusActive, StatusSuspended:
+		return true
+	}
+	return false
+}
+
+// ValidateName trims the name and checks its length.
+func ValidateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxNameLength {
+		return "", ErrNameInvalid
+	}
+	return name, nil
+}
+
+// ValidateDescription trims the description and checks its length.
+func ValidateDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		return "", ErrDescriptionInvalid
+	}
+	return description, nil
+}
+
+// ValidateLogo checks that the logo, if any, is an absolute http or https
+// URL.
+func ValidateLogo(logo string) (string, error) {
+	logo = strings.TrimSpace(logo)
+	if logo == "" {
+		return "", nil
+	}
+	u, err := url.Parse(logo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrLogoInvalid
+	}
+	return logo, nil
+}
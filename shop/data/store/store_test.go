@@ -0,0 +1,68 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type StoreSuite struct{}
+
+var _ = Suite(new(StoreSuite))
+
+// TestSlug validates the derivation and the validation of the slugs.
+func (suite *StoreSuite) TestSlug(c *C) {
+	for _, tc := range []struct {
+		name, slug string
+		valid      bool
+	}{
+		{"Kopi Kenangan Café", "kopi-kenangan-cafe", true},
+		{"  Batik -- Solo!! ", "batik-solo", true},
+		{"Toko 99", "toko-99", true},
+		{"Å", "a", false},
+		{"Mine", "mine", false},
+		{strings.Repeat("toko ", 20), strings.TrimSuffix(strings.Repeat("toko-", 10), "-"), true},
+	} {
+		slug := data.Slugify(tc.name)
+		c.Assert(slug, Equals, tc.slug, Commentf("name %q", tc.name))
+		c.Assert(ValidSlug(slug), Equals, tc.valid, Commentf("slug %q", slug))
+	}
+
+	for _, slug := range []string{"Toko", "toko_99", "-toko", "toko-", "to--ko", "ab"} {
+		c.Assert(ValidSlug(slug), Equals, false, Commentf("slug %q", slug))
+	}
+}
+
+// TestValidate validates the checks of the fields.
+func (suite *StoreSuite) TestValidate(c *C) {
+	name, err := ValidateName("  Toko Batik ")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "Toko Batik")
+	_, err = ValidateName("   ")
+	c.Assert(err, Equals, ErrNameInvalid)
+	_, err = ValidateName(strings.Repeat("é", MaxNameLength+1))
+	c.Assert(err, Equals, ErrNameInvalid)
+
+	_, err = ValidateDescription(strings.Repeat("x", MaxDescriptionLength+1))
+	c.Assert(err, Equals, ErrDescriptionInvalid)
+
+	for logo, valid := range map[string]bool{
+		"":                                 true,
+		"https://cdn.example.com/logo.png": true,
+		"javascript:alert(1)":              false,
+		"/images/logo.png":                 false,
+		"ftp://example.com/logo.png":       false,
+	} {
+		_, err = ValidateLogo(logo)
+		c.Assert(err == nil, Equals, valid, Commentf("logo %q", logo))
+	}
+
+	c.Assert(ValidStatus(StatusSuspended), Equals, true)
+	c.Assert(ValidStatus("closed"), Equals, false)
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"github.com/globalsign/mgo/bson"
+)
+
+// SetFields returns the update document setting the named fields of doc, by
+// their BSON names, to their values in doc. The other fields are left as
+// stored, so that the concurrent updates of different fields don't undo
+// each other. The named fields doc omits, being empty, are unset.
+func SetFields(doc interface{}, names ...string) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err = bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for _, name := range names {
+		if value, ok := fields[name]; ok {
+			set[name] = value
+		} else {
+			unset[name] = ""
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
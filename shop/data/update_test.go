@@ -0,0 +1,41 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type UpdateSuite struct{}
+
+var _ = Suite(new(UpdateSuite))
+
+// TestSetFields validates that only the named fields are set, and that the
+// omitted ones are unset.
+func (suite *UpdateSuite) TestSetFields(c *C) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	doc := struct {
+		Name        string    `bson:"name"`
+		Description string    `bson:"description,omitempty"`
+		Stock       int       `bson:"stock"`
+		UpdatedAt   time.Time `bson:"updated_at"`
+	}{Name: "Batik", Stock: 3, UpdatedAt: now}
+
+	update, err := SetFields(doc, "name", "description", "updated_at")
+	c.Assert(err, IsNil)
+	set := update["$set"].(bson.M)
+	c.Assert(set, HasLen, 2)
+	c.Assert(set["name"], Equals, "Batik")
+	// the time is decoded in the local time zone.
+	c.Assert(set["updated_at"].(time.Time).Equal(now), Equals, true)
+	c.Assert(update["$unset"], DeepEquals, bson.M{"description": ""})
+
+	update, err = SetFields(doc, "stock")
+	c.Assert(err, IsNil)
+	c.Assert(update, DeepEquals, bson.M{"$set": bson.M{"stock": 3}})
+}
@@ -0,0 +1,248 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+
+	"github.com/syaiful6/thatique/shop/data"
+)
+
+var (
+	// ErrNotFound is returned when no user matches a lookup.
+	ErrNotFound = errors.New("user not found")
+
+	// ErrEmailExists is returned when the email of a user being saved is
+	// registered to another user.
+	ErrEmailExists = errors.New("email already registered")
+
+	// ErrChanged is returned when a user being updated was changed since it
+	// was read.
+	ErrChanged = errors.New("user changed concurrently")
+)
+
+// emailCollation compares the emails case-insensitively. The queries on the
+// email must use it to be served by the unique index.
+var emailCollation = &mgo.Collation{Locale: "en", Strength: 2}
+
+// defaultListLimit is the number of users List returns if no limit is given.
+const defaultListLimit = 20
+
+// Repository reads and writes the users in the MongoDB users collection.
+type Repository struct {
+	conn *data.MongoConn
+}
+
+// NewRepository returns a Repository of the users stored in conn.
+func NewRepository(conn *data.MongoConn) *Repository {
+	return &Repository{conn: conn}
+}
+
+// EnsureIndexes creates the indexes of the users collection, if they don't
+// exist: the unique, case-insensitive, index of the emails and the index of
+// the creation dates. It fails if two users share an email.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	return r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		c := db.C(CollectionName)
+		err := c.EnsureIndex(mgo.Index{
+			Key:        []string{"email"},
+			Unique:     true,
+			Background: true,
+			Collation:  emailCollation,
+		})
+		if err != nil {
+			return err
+		}
+		return c.EnsureIndex(mgo.Index{
+			Key:        []string{"-created_at"},
+			Background: true,
+		})
+	})
+}
+
+// FindByID returns the user with the given hex encoded id, or ErrNotFound.
+func (r *Repository) FindByID(ctx context.Context, id string) (*User, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrNotFound
+	}
+
+	u := new(User)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).FindId(bson.ObjectIdHex(id)).One(u)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// FindByEmail returns the user with the given email, compared
+// case-insensitively, or ErrNotFound.
+func (r *Repository) FindByEmail(ctx context.Context, email string) (*User, error) {
+	u := new(User)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Find(bson.M{"email": strings.TrimSpace(email)}).
+			Collation(emailCollation).One(u)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Insert stores a new user, generating its id and setting its creation date
+// if they are empty. The email is lowercased. It returns ErrEmailExists if the
+// email is registered already.
+func (r *Repository) Insert(ctx context.Context, u *User) error {
+	if u.Id == "" {
+		u.Id = bson.NewObjectId()
+	}
+	if u.CreatedAt.IsZero() {
+		// MongoDB stores milliseconds, keep the user as it is stored.
+		u.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	u.Email = normalizeEmail(u.Email)
+
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Insert(u)
+	})
+	if mgo.IsDup(err) {
+		return ErrEmailExists
+	}
+	return err
+}
+
+// Update stores the given fields of u, by their BSON names such as
+// "is_disabled", matched by id. The other fields are left as stored, so that
+// a concurrent password reset or verification isn't undone. It returns
+// ErrNotFound if the user doesn't exist and ErrEmailExists if its new email
+// is registered to another user.
+func (r *Repository) Update(ctx context.Context, u *User, fields ...string) error {
+	u.Email = normalizeEmail(u.Email)
+
+	update, err := data.SetFields(u, fields...)
+	if err != nil {
+		return err
+	}
+	err = r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).UpdateId(u.Id, update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	if mgo.IsDup(err) {
+		return ErrEmailExists
+	}
+	return err
+}
+
+// Verify marks the user as verified. It returns ErrChanged if the stored user
+// is verified already or its email isn't the one of u anymore, this way a
+// verification link is used once.
+func (r *Repository) Verify(ctx context.Context, u *User) error {
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Update(
+			bson.M{"_id": u.Id, "email": u.Email, "is_verified": false},
+			bson.M{"$set": bson.M{"is_verified": true}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	u.Verified = true
+	return nil
+}
+
+// UpdatePassword stores the password hash of u, replacing old. It returns
+// ErrChanged if the stored hash isn't old anymore.
+func (r *Repository) UpdatePassword(ctx context.Context, u *User, old string) error {
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).Update(
+			bson.M{"_id": u.Id, "password": old},
+			bson.M{"$set": bson.M{"password": u.Password}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrChanged
+	}
+	return err
+}
+
+// Delete removes the user with the given id. It returns ErrNotFound if the
+// user doesn't exist.
+func (r *Repository) Delete(ctx context.Context, id bson.ObjectId) error {
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		return db.C(CollectionName).RemoveId(id)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ListOptions filters and paginates the users returned by List.
+type ListOptions struct {
+	// Search matches the users whose email or profile name contain it,
+	// case-insensitively. Empty matches every user.
+	Search string
+
+	// Offset is the number of matching users skipped.
+	Offset int
+
+	// Limit is the maximum number of users returned. Defaults to 20.
+	Limit int
+}
+
+// List returns a page of the users matching opts, most recent first, along
+// with the total number of matching users.
+func (r *Repository) List(ctx context.Context, opts ListOptions) ([]User, int, error) {
+	query := bson.M{}
+	if search := strings.TrimSpace(opts.Search); search != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		query["$or"] = []bson.M{
+			{"email": pattern},
+			{"profile.name": pattern},
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	var (
+		users []User
+		total int
+	)
+	err := r.conn.WithContext(ctx, func(db *mgo.Database) error {
+		q := db.C(CollectionName).Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		return q.Sort("-created_at", "-_id").Skip(opts.Offset).Limit(limit).All(&users)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/data/datatest"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type RepositorySuite struct {
+	datatest.Suite
+	users *Repository
+}
+
+var _ = Suite(&RepositorySuite{Suite: datatest.Suite{Name: "users"}})
+
+func (suite *RepositorySuite) SetUpTest(c *C) {
+	suite.users = NewRepository(suite.Conn)
+	suite.Reset(c, suite.users, CollectionName)
+}
+
+func (suite *RepositorySuite) insert(c *C, email string) *User {
+	u, err := Create(email, "password")
+	c.Assert(err, IsNil)
+	c.Assert(suite.users.Insert(suite.Ctx, u), IsNil)
+	return u
+}
+
+// TestDuplicateEmail validates that the emails are unique, regardless of
+// their case, on insert and update.
+func (suite *RepositorySuite) TestDuplicateEmail(c *C) {
+	suite.insert(c, "alice@example.com")
+
+	u, err := Create("Alice@Example.com", "password")
+	c.Assert(err, IsNil)
+	c.Assert(suite.users.Insert(suite.Ctx, u), Equals, ErrEmailExists)
+
+	bob := suite.insert(c, "bob@example.com")
+	bob.Email = "ALICE@example.com"
+	c.Assert(suite.users.Update(suite.Ctx, bob, "email"), Equals, ErrEmailExists)
+}
+
+// TestFindByEmail validates that the emails are compared case-insensitively.
+func (suite *RepositorySuite) TestFindByEmail(c *C) {
+	alice := suite.insert(c, " Alice@Example.com ")
+	c.Assert(alice.Email, Equals, "alice@example.com")
+
+	u, err := suite.users.FindByEmail(suite.Ctx, "ALICE@example.COM")
+	c.Assert(err, IsNil)
+	c.Assert(u.Id, Equals, alice.Id)
+
+	_, err = suite.users.FindByEmail(suite.Ctx, "bob@example.com")
+	c.Assert(err, Equals, ErrNotFound)
+}
+
+// TestList validates the pagination and the search of the users, most
+// recent first.
+func (suite *RepositorySuite) TestList(c *C) {
+	created := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		u, err := Create(fmt.Sprintf("user%d@example.com", i), "password")
+		c.Assert(err, IsNil)
+		u.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		c.Assert(suite.users.Insert(suite.Ctx, u), IsNil)
+	}
+
+	users, total, err := suite.users.List(suite.Ctx, ListOptions{Offset: 1, Limit: 2})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, 5)
+	c.Assert(users, HasLen, 2)
+	c.Assert(users[0].Email, Equals, "user3@example.com")
+	c.Assert(users[1].Email, Equals, "user2@example.com")
+
+	users, total, err = suite.users.List(suite.Ctx, ListOptions{Search: "USER4"})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, 1)
+	c.Assert(users, HasLen, 1)
+	c.Assert(users[0].Email, Equals, "user4@example.com")
+}
+
+// TestVerify validates that a user is verified once.
+func (suite *RepositorySuite) TestVerify(c *C) {
+	u := suite.insert(c, "alice@example.com")
+	stale := *u
+
+	c.Assert(suite.users.Verify(suite.Ctx, u), IsNil)
+	c.Assert(u.Verified, Equals, true)
+	c.Assert(suite.users.Verify(suite.Ctx, &stale), Equals, ErrChanged)
+}
+
+// TestUpdateKeepsPassword validates that an update leaving out the password
+// doesn't restore the password it read.
+func (suite *RepositorySuite) TestUpdateKeepsPassword(c *C) {
+	u := suite.insert(c, "alice@example.com")
+	stale := *u
+
+	c.Assert(u.SetPassword("new password"), IsNil)
+	c.Assert(suite.users.UpdatePassword(suite.Ctx, u, stale.Password), IsNil)
+	stale.Disabled = true
+	c.Assert(suite.users.Update(suite.Ctx, &stale, "is_disabled"), IsNil)
+
+	stored, err := suite.users.FindByEmail(suite.Ctx, "alice@example.com")
+	c.Assert(err, IsNil)
+	c.Assert(stored.Disabled, Equals, true)
+	c.Assert(stored.VerifyPassword("new password"), Equals, true)
+}
+
+// TestUpdatePassword validates that the password is only replaced if it
+// wasn't changed since it was read.
+func (suite *RepositorySuite) TestUpdatePassword(c *C) {
+	u := suite.insert(c, "alice@example.com")
+	old := u.Password
+
+	c.Assert(u.SetPassword("new password"), IsNil)
+	c.Assert(suite.users.UpdatePassword(suite.Ctx, u, old), IsNil)
+	c.Assert(suite.users.UpdatePassword(suite.Ctx, u, old), Equals, ErrChanged)
+
+	stored, err := suite.users.FindByID(suite.Ctx, u.Id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(stored.VerifyPassword("new password"), Equals, true)
+}
@@ -13,6 +13,9 @@ var (
 	CollectionName = "users"
 )
 
+// MinPasswordLength is the minimum length of the accepted passwords.
+const MinPasswordLength = 8
+
 type Profile struct {
 	Name    string `bson:"name,omitempty" json:"name,omitempty"`
 	Picture string `bson:"picture,omitempty" json:"picture"`
@@ -27,6 +30,8 @@ type User struct {
 	Password  string        `bson:"password"`
 	Superuser bool          `bson:"is_superuser"`
 	Staff     bool          `bson:"is_staff"`
+	Verified  bool          `bson:"is_verified"`
+	Disabled  bool          `bson:"is_disabled"`
 	CreatedAt time.Time     `bson:"created_at"`
 }
 
@@ -36,17 +41,16 @@ type SerializeUser struct {
 	Email     string    `json:"email"`
 	Superuser bool      `json:"is_superuser"`
 	Staff     bool      `json:"is_staff"`
+	Verified  bool      `json:"is_verified"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 func Create(email, password string) (*User, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(password), 11)
+	str, err := hashPassword(password)
 	if err != nil {
-		return nil, fmt.Errorf("error bcrypting password: %v", err)
+		return nil, err
 	}
 
-	str := base64.URLEncoding.EncodeToString(b)
-
 	return &User{
 		Email: email,
 		Password: str,
@@ -55,6 +59,60 @@ func Create(email, password string) (*User, error) {
 	}, nil
 }
 
+// SetPassword replaces the user's password hash with the hash of pswd.
+func (user *User) SetPassword(pswd string) error {
+	str, err := hashPassword(pswd)
+	if err != nil {
+		return err
+	}
+
+	user.Password = str
+	return nil
+}
+
+// IsStaff reports whether the user is a staff member. Superusers are staff
+// too, even if their staff flag isn't set.
+func (user *User) IsStaff() bool {
+	return user.Staff || user.Superuser
+}
+
+// passwordCost is the bcrypt cost of the password hashes.
+const passwordCost = 11
+
+// dummyPassword is a password hash of passwordCost, compared against when
+// there is no user to compare against.
+const dummyPassword = "JDJhJDExJHZrR1VoM0pnNHFQaXM5ZlNGRXhlaWU3S2RaOEVkaGhoN3BJbWV6cWtiMEV1ZmdaZGFrVW95"
+
+func hashPassword(pswd string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(pswd), passwordCost)
+	if err != nil {
+		return "", fmt.Errorf("error bcrypting password: %v", err)
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Serialize returns the representation of the user that is safe to send
+// to clients.
+func (user *User) Serialize() *SerializeUser {
+	return &SerializeUser{
+		Id:        user.Id.Hex(),
+		Profile:   user.Profile,
+		Email:     user.Email,
+		Superuser: user.Superuser,
+		Staff:     user.Staff,
+		Verified:  user.Verified,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// VerifyDummyPassword compares pswd against a fixed hash, taking as long as
+// VerifyPassword. It is called when no user has the email logging in, so that
+// the response time doesn't tell which emails are registered.
+func VerifyDummyPassword(pswd string) {
+	(&User{Password: dummyPassword}).VerifyPassword(pswd)
+}
+
 func (user *User) VerifyPassword(pswd string) bool {
 	b, err := base64.URLEncoding.DecodeString(user.Password)
 	if err != nil {
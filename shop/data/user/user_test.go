@@ -0,0 +1,22 @@
+package user
+
+import (
+	"encoding/base64"
+
+	"golang.org/x/crypto/bcrypt"
+	. "gopkg.in/check.v1"
+)
+
+type UserSuite struct{}
+
+var _ = Suite(new(UserSuite))
+
+// TestDummyPassword validates that the dummy password hash costs as much as
+// the hashes of the users.
+func (suite *UserSuite) TestDummyPassword(c *C) {
+	b, err := base64.URLEncoding.DecodeString(dummyPassword)
+	c.Assert(err, IsNil)
+	cost, err := bcrypt.Cost(b)
+	c.Assert(err, IsNil)
+	c.Assert(cost, Equals, passwordCost)
+}
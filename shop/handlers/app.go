@@ -4,69 +4,313 @@ import (
 	"context"
 	cryptorand "crypto/rand"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
 
 	"github.com/syaiful6/thatique/configuration"
 	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/health"
+	"github.com/syaiful6/thatique/health/checks"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/csrf"
 	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/migrations"
+	"github.com/syaiful6/thatique/shop/data/product"
+	"github.com/syaiful6/thatique/shop/data/service"
+	"github.com/syaiful6/thatique/shop/data/store"
+	"github.com/syaiful6/thatique/shop/data/user"
+	"github.com/syaiful6/thatique/shop/mail"
+	"github.com/syaiful6/thatique/shop/ratelimit"
 	tredis "github.com/syaiful6/thatique/shop/redis"
+	"github.com/syaiful6/thatique/shop/sessions"
+	"github.com/syaiful6/thatique/shop/static"
 )
 
 // randomSecretSize is the number of random bytes to generate if no secret
 // was specified.
 const randomSecretSize = 32
 
+// randomSessionKeySize is the number of random bytes to generate if no session
+// key was specified. It is split in half for the signing and encryption keys.
+const randomSessionKeySize = 64
+
+// defaultSessionName is the session cookie name used if none was configured.
+const defaultSessionName = "thatiq_session"
+
+// defaultSessionMaxAge is the session lifetime used if none was configured.
+const defaultSessionMaxAge = 14 * 24 * time.Hour
+
+// defaultVerificationTimeout is how long email verification links are valid if
+// no timeout was configured.
+const defaultVerificationTimeout = 72 * time.Hour
+
+// defaultPasswordResetTimeout is how long password reset links are valid if no
+// timeout was configured.
+const defaultPasswordResetTimeout = time.Hour
+
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultCheckTimeout is the default timeout of a single health check
+const defaultCheckTimeout = 5 * time.Second
+
 // App is a global thatiq application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
 type App struct {
 	context.Context
 
+	// cancel cancels the context of the app, stopping its background work.
+	cancel context.CancelFunc
+
 	Config *configuration.Configuration
 
 	router *mux.Router
 
 	redis *redis.Pool
 	mongo *data.MongoConn
+
+	sessionStore sessions.Store
+	sessionName  string
+
+	users         *user.Repository
+	stores        *store.Repository
+	products      *product.Repository
+	services      *service.Repository
+	authenticator *auth.Authenticator
+
+	// csrf checks the unsafe requests against cross-site request forgery.
+	csrf *csrf.Protector
+
+	// verificationTokens makes the tokens sent to verify email addresses.
+	verificationTokens *auth.TokenGenerator
+
+	// passwordResetTokens makes the tokens sent to reset forgotten passwords.
+	passwordResetTokens *auth.TokenGenerator
+
+	// unverifiedRestricted contains the names of the routes unverified users
+	// are denied access to.
+	unverifiedRestricted map[string]bool
+
+	// templates renders the HTML pages.
+	templates *renderer
+
+	// static serves the static files, ahead of the router.
+	static *static.Assets
+
+	// mailer is nil if mail is not configured.
+	mailer *mail.Mailer
+
+	// liveness holds the checks that tell whether the process must be
+	// restarted, readiness whether it can serve requests.
+	liveness  *health.Registry
+	readiness *health.Registry
+
+	// headers holds the http.Header added to every response. It is replaced
+	// when the configuration is reloaded.
+	headers atomic.Value
+
+	// limiter counts the requests to the routes that have rate limits,
+	// limits holds the limits by route name. They are replaced when the
+	// configuration is reloaded.
+	limiter rateLimiter
+	limits  atomic.Value
+
+	// trustedProxies are the networks whose proxy headers tell the client
+	// IP.
+	trustedProxies []*net.IPNet
 }
 
 func NewApp(ctx context.Context, config *configuration.Configuration) (*App, error) {
-	redisPool, err := tredis.NewRedisPool(config.Redis)
+	redisPool, err := tredis.NewRedisPool(config.Redis[configuration.DefaultConnection])
 	if err != nil {
 		return nil, err
 	}
 
 	// connect to mongodb
-	mongodb, err := data.Dial(config.MongoDB.URI, config.MongoDB.Name)
+	mongoConfig := config.MongoDB[configuration.DefaultConnection]
+	mongodb, err := data.Dial(mongoConfig.URI, mongoConfig.Name)
 	if err != nil {
 		return nil, err
 	}
 
 	app := &App{
-		Config:  config,
-		Context: ctx,
-		router:  RouterWithPrefix(config.HTTP.Prefix),
-		redis:   redisPool,
-		mongo:   mongodb,
+		Config:   config,
+		router:   RouterWithPrefix(config.HTTP.Prefix),
+		redis:    redisPool,
+		mongo:    mongodb,
+		users:    user.NewRepository(mongodb),
+		stores:   store.NewRepository(mongodb),
+		products: product.NewRepository(mongodb),
+		services: service.NewRepository(mongodb),
+		limiter:  ratelimit.New(redisPool),
+	}
+	app.Context, app.cancel = context.WithCancel(ctx)
+
+	if err = app.users.EnsureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("error creating the users indexes: %v", err)
+	}
+	if err = app.stores.EnsureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("error creating the stores indexes: %v", err)
+	}
+	if err = app.products.EnsureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("error creating the products indexes: %v", err)
+	}
+	if err = app.services.EnsureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("error creating the services indexes: %v", err)
+	}
+	pending, err := migrations.NewMigrator(mongodb).Pending(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the migrations: %v", err)
+	}
+	if len(pending) > 0 {
+		scontext.GetLogger(ctx).Warnf("%d database migrations are pending, run `shop migrate up`", len(pending))
 	}
 
 	// Register the handler dispatchers.
 	app.handle("/", func(ctx *Context, r *http.Request) http.Handler {
 		return http.HandlerFunc(homeHandlerFunc)
 	}).Name("home")
-
+	app.handle("/auth/signup", signupDispatcher).Name("auth.signup")
+	app.handle("/auth/login", loginDispatcher).Name("auth.login")
+	app.handle("/auth/logout", logoutDispatcher).Name("auth.logout")
+	app.handle("/auth/me", meDispatcher).Name("auth.me")
+	app.handle("/auth/csrf", csrfDispatcher).Name("auth.csrf")
+	app.handle("/auth/verify", verificationRequestDispatcher).Name("auth.verify.request")
+	app.handle("/auth/verify/{token}", verificationDispatcher).Name("auth.verify")
+	app.handle("/auth/password/forgot", passwordForgotDispatcher).Name("auth.password.forgot")
+	app.handle("/auth/password/reset/{token}", passwordResetDispatcher).Name("auth.password.reset")
+	app.handle("/stores", storesDispatcher).Name("stores")
+	app.handle("/stores/mine", myStoresDispatcher).Name("stores.mine")
+	app.handle("/stores/{slug}", storeDispatcher).Name("store")
+	app.handle("/stores/{slug}/status", storeStatusDispatcher).Name("store.status")
+	app.handle("/stores/{slug}/products", productsDispatcher).Name("store.products")
+	app.handle("/stores/{slug}/products/{product}", productDispatcher).Name("store.product")
+	app.handle("/stores/{slug}/services", servicesDispatcher).Name("store.services")
+	app.handle("/stores/{slug}/services/{service}", serviceDispatcher).Name("store.service")
+	app.handle("/stores/{slug}/services/{service}/slots", serviceSlotsDispatcher).Name("store.service.slots")
+	app.handle("/stores/{slug}/services/{service}/bookings", serviceBookingsDispatcher).Name("store.service.bookings")
+	app.handle("/bookings", bookingsDispatcher).Name("bookings")
+	app.handle("/bookings/{booking}", bookingDispatcher).Name("booking")
+	app.handle("/bookings/{booking}/confirm", bookingConfirmDispatcher).Name("booking.confirm")
+	app.handle("/bookings/{booking}/cancel", bookingCancelDispatcher).Name("booking.cancel")
+	app.handle("/bookings/{booking}/reschedule", bookingRescheduleDispatcher).Name("booking.reschedule")
+	app.handle("/toko", storePagesDispatcher).Name("toko")
+	app.handle("/toko/{slug}", storePageDispatcher).Name("toko.show")
+	app.handle("/jasa", servicePagesDispatcher).Name("jasa")
+	app.handle("/jasa/{slug}/{service}", servicePageDispatcher).Name("jasa.show")
+
+	// The liveness registry has no checks of its own: /debug/health/live
+	// answers 200 as long as the server handles requests, restarting the
+	// process doesn't fix a backend being down.
+	app.liveness = health.NewRegistry()
+	app.readiness = health.NewRegistry()
+	app.router.HandleFunc("/debug/health", app.readiness.StatusHandler).Name("health")
+	app.router.HandleFunc("/debug/health/ready", app.readiness.StatusHandler).Name("health.ready")
+	app.router.HandleFunc("/debug/health/live", app.liveness.StatusHandler).Name("health.live")
+
+	app.headers.Store(config.HTTP.Headers)
+	app.storeRateLimits(config.RateLimits)
+	if app.trustedProxies, err = config.HTTP.TrustedNetworks(); err != nil {
+		return nil, fmt.Errorf("http.trustedproxies: %v", err)
+	}
 	app.configureSecret(config)
+	if err = app.configureSessions(config); err != nil {
+		return nil, err
+	}
+	app.configureAuth(config)
+	app.configureCSRF(config)
+	app.configureMail(config)
+	if err = app.configureStatic(config); err != nil {
+		return nil, err
+	}
+	if err = app.configureTemplates(config); err != nil {
+		return nil, err
+	}
 
 	return app, err
 }
 
+// Reload applies the parameters of config that can change while serving. The
+// others are left untouched, it's up to the caller to check they are the same.
+func (app *App) Reload(config *configuration.Configuration) {
+	app.headers.Store(config.HTTP.Headers)
+	app.storeRateLimits(config.RateLimits)
+}
+
+// Shutdown stops the background work of the app, the periodic health checks.
+func (app *App) Shutdown() {
+	app.cancel()
+}
+
+// RegisterHealthChecks registers the checks of the app's backends and the
+// configured disk checkers. By default checks are registered with the
+// readiness registry served at /debug/health, an alternate registry may be
+// given instead. The checks run until the app is shut down.
+func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
+	if len(healthRegistries) > 1 {
+		panic("RegisterHealthChecks called with more than one registry")
+	}
+	healthRegistry := app.readiness
+	if len(healthRegistries) == 1 {
+		healthRegistry = healthRegistries[0]
+	}
+
+	register := func(name string, conf configuration.HealthCheck, check health.Checker) {
+		if conf.Disabled {
+			return
+		}
+
+		interval := conf.Interval
+		if interval == 0 {
+			interval = defaultCheckInterval
+		}
+		timeout := conf.Timeout
+		if timeout == 0 {
+			timeout = defaultCheckTimeout
+		}
+		check = checks.Timeout(check, timeout)
+
+		if conf.Threshold != 0 {
+			scontext.GetLogger(app).Infof("configuring %s health check interval=%v threshold=%d", name, interval, conf.Threshold)
+			healthRegistry.Register(name, health.PeriodicThresholdChecker(app, check, interval, conf.Threshold))
+		} else {
+			scontext.GetLogger(app).Infof("configuring %s health check interval=%v", name, interval)
+			healthRegistry.Register(name, health.PeriodicChecker(app, check, interval))
+		}
+	}
+
+	healthConfig := app.Config.Health
+	register("redis", healthConfig.Redis, health.CheckFunc(func() error {
+		_, err := tredis.Ping(app.redis)
+		return err
+	}))
+	register("mongodb", healthConfig.MongoDB, health.CheckFunc(app.mongo.Ping))
+
+	if smtpAddr := app.Config.Mail[configuration.DefaultConnection].SMTP.Addr; smtpAddr != "" {
+		timeout := healthConfig.SMTP.Timeout
+		if timeout == 0 {
+			timeout = defaultCheckTimeout
+		}
+		register("smtp", healthConfig.SMTP, checks.TCPChecker(smtpAddr, timeout))
+	}
+
+	for _, diskChecker := range healthConfig.Disk {
+		register("disk:"+diskChecker.Path, configuration.HealthCheck{
+			Interval:  diskChecker.Interval,
+			Threshold: diskChecker.Threshold,
+		}, checks.DiskSpaceChecker(diskChecker.Path, diskChecker.MinFree))
+	}
+}
+
 func RouterWithPrefix(prefix string) *mux.Router {
 	rootRouter := mux.NewRouter()
 	router := rootRouter
@@ -92,14 +336,151 @@ func (app *App) configureSecret(configuration *configuration.Configuration) {
 	}
 }
 
+// configureSessions sets up the redis backed session store, generating a
+// random session key if one wasn't included in the configuration.
+func (app *App) configureSessions(configuration *configuration.Configuration) error {
+	key := []byte(configuration.HTTP.SessionKey)
+	if len(key) == 0 {
+		key = securecookie.GenerateRandomKey(randomSessionKeySize)
+		if key == nil {
+			panic("could not generate random bytes for session key")
+		}
+		configuration.HTTP.SessionKey = key
+		scontext.GetLogger(app).Warn("No session key provided - generated random key. Sessions will not survive a restart nor be shared between instances. To provide a shared key, run `shop session generate` and fill in http.session_key in the configuration file or set the THATIQ_HTTP_SESSIONKEY environment variable.")
+	}
+
+	// The first half of the key signs the cookie, the second half encrypts
+	// it with AES-128, 192 or 256. configuration.Validate checks the length
+	// too.
+	var keyPairs [][]byte
+	switch n := len(key); n {
+	case 32, 48, 64:
+		keyPairs = append(keyPairs, key[:n/2], key[n/2:])
+	default:
+		return fmt.Errorf("http.session_key must decode to 32, 48 or 64 bytes, got %d", len(key))
+	}
+
+	sessConfig := configuration.HTTP.Session
+	sameSite, err := sameSiteMode(sessConfig.SameSite)
+	if err != nil {
+		return err
+	}
+
+	maxAge := sessConfig.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultSessionMaxAge
+	}
+
+	store := sessions.NewRedisStore(app.redis, keyPairs...)
+	store.SetMaxAge(int(maxAge / time.Second))
+	if sessConfig.Path != "" {
+		store.Options.Path = sessConfig.Path
+	}
+	store.Options.Domain = sessConfig.Domain
+	store.Options.Secure = sessConfig.Secure
+	store.Options.SameSite = sameSite
+
+	app.sessionStore = store
+	app.sessionName = sessConfig.Name
+	if app.sessionName == "" {
+		app.sessionName = defaultSessionName
+	}
+
+	return nil
+}
+
+// configureAuth sets up the authenticator and email verification. It must be
+// called after configureSecret and configureSessions.
+func (app *App) configureAuth(configuration *configuration.Configuration) {
+	app.authenticator = auth.NewAuthenticator(app.sessionStore, app.sessionName, auth.NewMongoUserStore(app.users))
+	app.router.Use(app.authenticator.Middleware)
+
+	verification := configuration.Auth.Verification
+	timeout := verification.Timeout
+	if timeout == 0 {
+		timeout = defaultVerificationTimeout
+	}
+	app.verificationTokens = auth.NewTokenGenerator(configuration.HTTP.Secret,
+		"auth.verify", timeout, func(u *user.User) string {
+			return fmt.Sprintf("%s:%t", u.Email, u.Verified)
+		})
+
+	resetTimeout := configuration.Auth.PasswordReset.Timeout
+	if resetTimeout == 0 {
+		resetTimeout = defaultPasswordResetTimeout
+	}
+	app.passwordResetTokens = auth.NewTokenGenerator(configuration.HTTP.Secret,
+		"auth.password.reset", resetTimeout, func(u *user.User) string {
+			return u.Password
+		})
+
+	app.unverifiedRestricted = make(map[string]bool)
+	for _, name := range verification.Restrict {
+		if app.router.Get(name) == nil {
+			scontext.GetLogger(app).Warnf("auth.verification.restrict: unknown route %q", name)
+		}
+		app.unverifiedRestricted[name] = true
+	}
+}
+
+// configureCSRF sets up the protection against cross-site request forgery. It
+// must be called after configureAuth, the checks don't run before the user is
+// loaded.
+func (app *App) configureCSRF(configuration *configuration.Configuration) {
+	csrfConfig := configuration.HTTP.CSRF
+	app.csrf = csrf.New(app.sessionStore, app.sessionName, configuration.HTTP.Secret,
+		configuration.HTTP.Host, csrfConfig.TrustedOrigins)
+	for _, name := range csrfConfig.Exempt {
+		if app.router.Get(name) == nil {
+			scontext.GetLogger(app).Warnf("http.csrf.exempt: unknown route %q", name)
+		}
+		app.csrf.Exempt(name)
+	}
+	app.router.Use(app.csrf.Middleware)
+}
+
+// configureMail sets up the mailer if an SMTP server was configured.
+func (app *App) configureMail(config *configuration.Configuration) {
+	mailConfig := config.Mail[configuration.DefaultConnection]
+	if mailConfig.SMTP.Addr == "" {
+		scontext.GetLogger(app).Warn("No SMTP server configured - emails, such as email verification, won't be sent. To send emails, fill in mail.default.smtp in the configuration file.")
+		return
+	}
+	app.mailer = mail.New(mailConfig)
+}
+
+func sameSiteMode(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return http.SameSite(0), nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return http.SameSite(0), fmt.Errorf("unsupported session samesite mode: %q", mode)
+	}
+}
+
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close() // ensure that request body is always closed.
 
+	// the static files need neither the session nor the user.
+	if strings.HasPrefix(r.URL.Path, app.static.Prefix()) {
+		app.writeHeaders(w)
+		app.static.ServeHTTP(w, r)
+		return
+	}
+
 	// Prepare the context with our own little decorations.
 	ctx := r.Context()
 	ctx = scontext.WithRequest(ctx, r)
 	ctx, w = scontext.WithResponseWriter(ctx, w)
 	ctx = scontext.WithLogger(ctx, scontext.GetRequestLogger(ctx))
+	ctx = sessions.WithRegistry(ctx, r)
+	ctx = errcode.WithErrorWriter(ctx, app.writeError)
 	r = r.WithContext(ctx)
 
 	defer func() {
@@ -126,20 +507,48 @@ type DispatchFunc func(ctx *Context, r *http.Request) http.Handler
 // handler, using the dispatch factory function.
 func (app *App) dispatcher(dispatch DispatchFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for headerName, headerValues := range app.Config.HTTP.Headers {
-			for _, value := range headerValues {
-				w.Header().Add(headerName, value)
-			}
-		}
+		app.writeHeaders(w)
 
 		context := app.context(w, r)
 
 		// sync up context on the request.
 		r = r.WithContext(context)
-		dispatch(context, r).ServeHTTP(w, r)
+		route := mux.CurrentRoute(r)
+		if route == nil || app.checkRateLimits(context, w, r, route.GetName()) {
+			handler := dispatch(context, r)
+			if route != nil && app.unverifiedRestricted[route.GetName()] {
+				handler = auth.RequireVerified(handler)
+			}
+			handler.ServeHTTP(w, r)
+		}
+
+		// Automated error response handling here. Handlers may write their
+		// own error response if they need different behavior.
+		if context.Errors.Len() > 0 {
+			context.serveErrors(w, r, context.Errors)
+		}
 	})
 }
 
+// writeError is the errcode.ErrorWriter of the requests, used by the
+// middleware that rejects them before or around the handlers.
+func (app *App) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	errs, ok := err.(errcode.Errors)
+	if !ok {
+		errs = errcode.Errors{err}
+	}
+	app.context(w, r).serveErrors(w, r, errs)
+}
+
+// writeHeaders adds the configured headers to the response.
+func (app *App) writeHeaders(w http.ResponseWriter) {
+	for headerName, headerValues := range app.headers.Load().(http.Header) {
+		for _, value := range headerValues {
+			w.Header().Add(headerName, value)
+		}
+	}
+}
+
 // context constructs the context object for the application. This only be
 // called once per request.
 func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
@@ -149,10 +558,55 @@ func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 		"vars.name",
 		"vars.uuid"))
 
+	session, err := app.sessionStore.Get(r, app.sessionName)
+	if err != nil {
+		// a tampered or expired cookie, or the store is unreachable. The
+		// request continues with a fresh session.
+		scontext.GetLogger(ctx).Warnf("error loading session: %v", err)
+	}
+
 	return &Context{
 		App:     app,
 		Context: ctx,
+		Session: session,
+	}
+}
+
+// sendMail sends the email in the background, logging the failures. It does
+// nothing if mail isn't configured.
+func (app *App) sendMail(ctx context.Context, to []string, subject, message string) {
+	if app.mailer == nil {
+		scontext.GetLogger(ctx).Warnf("mail not configured, not sending %q", subject)
+		return
 	}
+
+	logger := scontext.GetLogger(ctx)
+	go func() {
+		if err := app.mailer.SendMail(to, subject, message); err != nil {
+			logger.Errorf("error sending mail %q: %v", subject, err)
+		}
+	}()
+}
+
+// absoluteURL builds the absolute URL of the named route, on the configured
+// http.host. The host of the request is never used: the links are mailed, and
+// a forged Host header would send the tokens they hold to another site.
+func (app *App) absoluteURL(name string, pairs ...string) (string, error) {
+	base := strings.TrimRight(app.Config.HTTP.Host, "/")
+	if base == "" {
+		return "", fmt.Errorf("http.host is not configured, can't link to %q", name)
+	}
+
+	route := app.router.Get(name)
+	if route == nil {
+		return "", fmt.Errorf("unknown route %q", name)
+	}
+	u, err := route.URL(pairs...)
+	if err != nil {
+		return "", err
+	}
+
+	return base + u.String(), nil
 }
 
 func homeHandlerFunc(w http.ResponseWriter, r *http.Request) {
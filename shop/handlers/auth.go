@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// credentials is the request body of the signup and login endpoints.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name,omitempty"`
+}
+
+// authHandler handles the authentication endpoints.
+type authHandler struct {
+	*Context
+}
+
+func signupDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ah := &authHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(ah.Signup),
+	}
+}
+
+func loginDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ah := &authHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(ah.Login),
+	}
+}
+
+func logoutDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ah := &authHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(ah.Logout),
+	}
+}
+
+func meDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ah := &authHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": auth.RequireLogin(http.HandlerFunc(ah.Me)),
+	}
+}
+
+// Signup creates a new account and logs it in.
+func (ah *authHandler) Signup(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	if err := decodeJSON(r, &creds); err != nil {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+
+	email, ok := normalizeEmail(creds.Email)
+	if !ok {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeEmailInvalid)
+	}
+	if len(creds.Password) < user.MinPasswordLength {
+		ah.Errors = append(ah.Errors, v1.ErrorCodePasswordInvalid.WithArgs(user.MinPasswordLength))
+	}
+	if ah.Errors.Len() > 0 {
+		return
+	}
+
+	u, err := user.Create(email, creds.Password)
+	if err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	u.Profile.Name = creds.Name
+
+	err = ah.users.Insert(ah, u)
+	if err == user.ErrEmailExists {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeEmailExists)
+		return
+	}
+	if err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if _, err := ah.authenticator.Login(u, w, r); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	scontext.GetLogger(ah).Infof("new user signed up: %s", u.Id.Hex())
+	if err := ah.sendVerification(u); err != nil {
+		scontext.GetLogger(ah).Errorf("error sending verification email: %v", err)
+	}
+	serveJSON(w, http.StatusCreated, u.Serialize())
+}
+
+// Login authenticates the user with email and password.
+func (ah *authHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	if err := decodeJSON(r, &creds); err != nil {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+
+	email, ok := normalizeEmail(creds.Email)
+	if !ok || creds.Password == "" {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeCredentialsInvalid)
+		return
+	}
+
+	u, err := ah.users.FindByEmail(ah, email)
+	if err == user.ErrNotFound {
+		user.VerifyDummyPassword(creds.Password)
+	}
+	if err == user.ErrNotFound || (err == nil && !u.VerifyPassword(creds.Password)) {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeCredentialsInvalid)
+		return
+	}
+	if err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if u.Disabled {
+		ah.Errors = append(ah.Errors, v1.ErrorCodeAccountDisabled)
+		return
+	}
+
+	if _, err := ah.authenticator.Login(u, w, r); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serveJSON(w, http.StatusOK, u.Serialize())
+}
+
+// Logout logs out the current user, destroying the session.
+func (ah *authHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if _, err := ah.authenticator.Logout(w, r); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me returns the current user.
+func (ah *authHandler) Me(w http.ResponseWriter, r *http.Request) {
+	serveJSON(w, http.StatusOK, auth.GetUser(r.Context()).Serialize())
+}
+
+// normalizeEmail lowercases the email and reports whether it is a valid
+// bare address.
+func normalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", false
+	}
+	return email, true
+}
+
+// decodeJSON decodes the JSON request body into v.
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// serveJSON writes v as JSON response with the given status code.
+func serveJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		errcode.ServeJSON(w, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
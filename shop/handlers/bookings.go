@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data/service"
+	"github.com/syaiful6/thatique/shop/data/store"
+)
+
+// bookingRequest is the request body of the booking endpoint.
+type bookingRequest struct {
+	Start time.Time `json:"start"`
+	Note  string    `json:"note"`
+}
+
+// rescheduleRequest is the request body of the booking reschedule endpoint.
+type rescheduleRequest struct {
+	Start time.Time `json:"start"`
+}
+
+// bookingList is the response body of the booking lists.
+type bookingList struct {
+	Bookings []*service.SerializeBooking `json:"bookings"`
+	Total    int                         `json:"total"`
+}
+
+// bookingHandler handles the booking endpoints. A booking is visible to its
+// customer and to the owner of the store and the staff, who confirm it.
+type bookingHandler struct {
+	*Context
+}
+
+func serviceBookingsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":  auth.RequireLogin(http.HandlerFunc(bh.List)),
+		"POST": auth.RequireLogin(http.HandlerFunc(bh.Book)),
+	}
+}
+
+func bookingsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": auth.RequireLogin(http.HandlerFunc(bh.Mine)),
+	}
+}
+
+func bookingDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": auth.RequireLogin(http.HandlerFunc(bh.Get)),
+	}
+}
+
+func bookingConfirmDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": auth.RequireLogin(http.HandlerFunc(bh.Confirm)),
+	}
+}
+
+func bookingCancelDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": auth.RequireLogin(http.HandlerFunc(bh.Cancel)),
+	}
+}
+
+func bookingRescheduleDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bh := &bookingHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": auth.RequireLogin(http.HandlerFunc(bh.Reschedule)),
+	}
+}
+
+// List returns a page of the bookings of the service, by start. It is
+// restricted to the owner of the store and the staff.
+func (bh *bookingHandler) List(w http.ResponseWriter, r *http.Request) {
+	s, ok := bh.visibleStore(r)
+	if !ok {
+		return
+	}
+	if !canManage(r, s) {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeDenied)
+		return
+	}
+	svc, ok := bh.visibleService(r, s)
+	if !ok {
+		return
+	}
+
+	opts, ok := bh.listOptions(r)
+	if !ok {
+		return
+	}
+	opts.ServiceId = svc.Id
+	bh.serveBookings(w, opts)
+}
+
+// Mine returns a page of the bookings of the current user, by start.
+func (bh *bookingHandler) Mine(w http.ResponseWriter, r *http.Request) {
+	opts, ok := bh.listOptions(r)
+	if !ok {
+		return
+	}
+	opts.CustomerId = auth.GetUser(r.Context()).Id
+	bh.serveBookings(w, opts)
+}
+
+// Book reserves a slot of a published service for the current user. The
+// booking is pending until the store confirms it, a customer holds at most
+// service.MaxPendingBookings of them, a soft limit. Of the concurrent requests for the same
+// slot, only one succeeds, the others get v1.ErrorCodeSlotUnavailable.
+func (bh *bookingHandler) Book(w http.ResponseWriter, r *http.Request) {
+	s, ok := bh.visibleStore(r)
+	if !ok {
+		return
+	}
+	svc, ok := bh.visibleService(r, s)
+	if !ok {
+		return
+	}
+	if !s.Public() || !svc.Published() {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeServiceNotFound)
+		return
+	}
+
+	var req bookingRequest
+	if err := decodeJSON(r, &req); err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if !bookable(svc, req.Start) {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeSlotUnavailable)
+		return
+	}
+
+	customerID := auth.GetUser(r.Context()).Id
+	if !bh.canHoldPending(customerID) {
+		return
+	}
+
+	b, err := service.NewBooking(svc, customerID, req.Start, req.Note)
+	if err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("note").WithDetail(err.Error()))
+		return
+	}
+	if !bh.save(b, bh.services.InsertBooking) {
+		return
+	}
+
+	scontext.GetLogger(bh).Infof("service %s of store %s booked at %s by %s",
+		svc.Slug, s.Slug, b.Start.Format(time.RFC3339), b.CustomerId.Hex())
+	serveJSON(w, http.StatusCreated, b.Serialize())
+}
+
+// Get returns the booking.
+func (bh *bookingHandler) Get(w http.ResponseWriter, r *http.Request) {
+	b, _, ok := bh.booking(r)
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, b.Serialize())
+}
+
+// Confirm accepts a pending booking. It is restricted to the owner of the
+// store and the staff.
+func (bh *bookingHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	b, s, ok := bh.booking(r)
+	if !ok {
+		return
+	}
+	if !canManage(r, s) {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeDenied)
+		return
+	}
+
+	if err := b.Confirm(time.Now()); err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingState.WithDetail(err.Error()))
+		return
+	}
+	if !bh.save(b, bh.services.UpdateBooking) {
+		return
+	}
+
+	scontext.GetLogger(bh).Infof("booking %s confirmed by %s", b.Id.Hex(), auth.GetUser(r.Context()).Id.Hex())
+	serveJSON(w, http.StatusOK, b.Serialize())
+}
+
+// Cancel cancels the booking, freeing its slot. Both the customer and the
+// store may cancel it, before it begins.
+func (bh *bookingHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	b, _, ok := bh.booking(r)
+	if !ok {
+		return
+	}
+
+	if err := b.Cancel(time.Now()); err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingState.WithDetail(err.Error()))
+		return
+	}
+	if !bh.save(b, bh.services.UpdateBooking) {
+		return
+	}
+
+	scontext.GetLogger(bh).Infof("booking %s cancelled by %s", b.Id.Hex(), auth.GetUser(r.Context()).Id.Hex())
+	serveJSON(w, http.StatusOK, b.Serialize())
+}
+
+// Reschedule moves the booking to another slot of its service, as long as
+// the store and the service are open to bookings. Both the customer and the
+// store may reschedule it, before it begins; it is then pending until the
+// store confirms the new slot, and counts in the pending bookings of the
+// customer.
+func (bh *bookingHandler) Reschedule(w http.ResponseWriter, r *http.Request) {
+	b, s, ok := bh.booking(r)
+	if !ok {
+		return
+	}
+
+	var req rescheduleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+
+	svc, err := bh.services.FindByID(bh, b.ServiceId.Hex())
+	if err != nil && err != service.ErrNotFound {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if err == service.ErrNotFound || !s.Public() || !svc.Published() {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeServiceNotFound)
+		return
+	}
+	if !bookable(svc, req.Start) {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeSlotUnavailable)
+		return
+	}
+	// a confirmed booking becomes pending again.
+	if b.Status != service.BookingPending && !bh.canHoldPending(b.CustomerId) {
+		return
+	}
+
+	if err := b.Reschedule(svc, req.Start, time.Now()); err != nil {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingState.WithDetail(err.Error()))
+		return
+	}
+	if !bh.save(b, bh.services.UpdateBooking) {
+		return
+	}
+
+	scontext.GetLogger(bh).Infof("booking %s rescheduled at %s by %s",
+		b.Id.Hex(), b.Start.Format(time.RFC3339), auth.GetUser(r.Context()).Id.Hex())
+	serveJSON(w, http.StatusOK, b.Serialize())
+}
+
+// canHoldPending reports whether the customer has less than
+// service.MaxPendingBookings upcoming pending bookings, adding
+// v1.ErrorCodeTooManyPendingBookings to the errors otherwise. The count isn't
+// atomic with the write that follows, so concurrent requests of a customer
+// may all pass it: the cap is a soft limit.
+func (bh *bookingHandler) canHoldPending(customerID bson.ObjectId) bool {
+	pending, err := bh.services.CountPendingBookings(bh, customerID, time.Now())
+	if err != nil {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	if pending >= service.MaxPendingBookings {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeTooManyPendingBookings)
+		return false
+	}
+	return true
+}
+
+// booking returns the booking of the request and the store of its service,
+// if the current user made it or manages the store, adding
+// v1.ErrorCodeBookingNotFound to the errors otherwise.
+func (bh *bookingHandler) booking(r *http.Request) (*service.Booking, *store.Store, bool) {
+	b, err := bh.services.FindBooking(bh, getBookingID(bh))
+	if err != nil && err != service.ErrBookingNotFound {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, nil, false
+	}
+	if err == service.ErrBookingNotFound {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingNotFound)
+		return nil, nil, false
+	}
+
+	s, err := bh.stores.FindByID(bh, b.StoreId.Hex())
+	if err != nil && err != store.ErrNotFound {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, nil, false
+	}
+	if !b.IsCustomer(auth.GetUser(r.Context()).Id) && (s == nil || !canManage(r, s)) {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingNotFound)
+		return nil, nil, false
+	}
+	if s == nil {
+		// The store is gone, only the customer sees the booking.
+		s = &store.Store{Id: b.StoreId}
+	}
+	return b, s, true
+}
+
+// listOptions returns the pagination and the status query parameter of the
+// booking lists, adding the errors if they aren't valid. The upcoming query
+// parameter, when set, leaves out the bookings that are over.
+func (bh *bookingHandler) listOptions(r *http.Request) (service.BookingListOptions, bool) {
+	offset, limit, ok := bh.pagination(r)
+	if !ok {
+		return service.BookingListOptions{}, false
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && !service.ValidBookingStatus(status) {
+		bh.Errors = append(bh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("status").
+			WithDetail("the status must be pending, confirmed or cancelled"))
+		return service.BookingListOptions{}, false
+	}
+
+	opts := service.BookingListOptions{Status: status, Offset: offset, Limit: limit}
+	if query.Get("upcoming") != "" {
+		opts.From = time.Now()
+	}
+	return opts, true
+}
+
+// serveBookings writes the page of bookings matching opts.
+func (bh *bookingHandler) serveBookings(w http.ResponseWriter, opts service.BookingListOptions) {
+	bookings, total, err := bh.services.ListBookings(bh, opts)
+	if err != nil {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serialized := make([]*service.SerializeBooking, len(bookings))
+	for i := range bookings {
+		serialized[i] = bookings[i].Serialize()
+	}
+	serveJSON(w, http.StatusOK, bookingList{Bookings: serialized, Total: total})
+}
+
+// save inserts or updates the booking, adding the errors if it fails.
+func (bh *bookingHandler) save(b *service.Booking, save func(ctx context.Context, b *service.Booking) error) bool {
+	switch err := save(bh, b); err {
+	case nil:
+		return true
+	case service.ErrSlotTaken:
+		bh.Errors = append(bh.Errors, v1.ErrorCodeSlotUnavailable)
+	case service.ErrBookingChanged:
+		bh.Errors = append(bh.Errors, v1.ErrorCodeBookingState.WithDetail(err.Error()))
+	default:
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+	return false
+}
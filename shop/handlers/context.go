@@ -4,6 +4,8 @@ import (
 	"context"
 
 	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/sessions"
 )
 
 // Context should contain the request specific context for use in across
@@ -12,6 +14,16 @@ import (
 type Context struct {
 	*App
 	context.Context
+
+	// Errors is a collection of errors encountered during the request to be
+	// returned to the client API. If errors are added to the collection, the
+	// handler *must not* start the response via http.ResponseWriter.
+	Errors errcode.Errors
+
+	// Session is the session of the current request, it is never nil.
+	// Handlers must call Session.Save before writing the response body to
+	// persist any change.
+	Session *sessions.Session
 }
 
 // Value overrides context.Context.Value to ensure that calls are routed to
@@ -23,3 +35,23 @@ func (ctx *Context) Value(key interface{}) interface{} {
 func getName(ctx context.Context) (name string) {
 	return scontext.GetStringValue(ctx, "vars.name")
 }
+
+func getToken(ctx context.Context) string {
+	return scontext.GetStringValue(ctx, "vars.token")
+}
+
+func getSlug(ctx context.Context) string {
+	return scontext.GetStringValue(ctx, "vars.slug")
+}
+
+func getProductSlug(ctx context.Context) string {
+	return scontext.GetStringValue(ctx, "vars.product")
+}
+
+func getServiceSlug(ctx context.Context) string {
+	return scontext.GetStringValue(ctx, "vars.service")
+}
+
+func getBookingID(ctx context.Context) string {
+	return scontext.GetStringValue(ctx, "vars.booking")
+}
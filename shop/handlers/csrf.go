@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+)
+
+// csrfResponse is the response body of the CSRF token endpoint.
+type csrfResponse struct {
+	Token string `json:"token"`
+}
+
+func csrfDispatcher(ctx *Context, r *http.Request) http.Handler {
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(ctx.serveCSRFToken),
+	}
+}
+
+// serveCSRFToken hands a CSRF token to the API clients, to be sent back in
+// the X-CSRF-Token header of the unsafe requests.
+func (ctx *Context) serveCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, err := ctx.CSRFToken(w, r)
+	if err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	serveJSON(w, http.StatusOK, csrfResponse{Token: token})
+}
+
+// CSRFToken returns the CSRF token of the session, to be included in the
+// forms rendered by the templates. It must be called before the response is
+// written, the session may have to be saved.
+func (ctx *Context) CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	token, err := ctx.csrf.Token(w, r)
+	if err != nil {
+		scontext.GetLogger(ctx).Errorf("error issuing CSRF token: %v", err)
+	}
+	return token, err
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/gob"
+	"net/http"
+
+	scontext "github.com/syaiful6/thatique/context"
+)
+
+// flashSessionKey stores the flash messages not rendered yet.
+const flashSessionKey = "flash.messages"
+
+// Flash is a message shown once, on the next page rendered, such as the
+// outcome of a form posted before a redirect. The flashes are queued in the
+// session values, under flashSessionKey.
+type Flash struct {
+	// Level is one of info, success, warning or error.
+	Level   string
+	Message string
+}
+
+func init() {
+	// the session values are gob encoded.
+	gob.Register([]Flash{})
+}
+
+// flashes removes the queued messages from the session and returns them.
+func (ctx *Context) flashes(w http.ResponseWriter, r *http.Request) []Flash {
+	flashes, ok := ctx.Session.Values[flashSessionKey].([]Flash)
+	if !ok {
+		return nil
+	}
+
+	delete(ctx.Session.Values, flashSessionKey)
+	if err := ctx.Session.Save(r, w); err != nil {
+		scontext.GetLogger(ctx).Errorf("error saving session: %v", err)
+	}
+	return flashes
+}
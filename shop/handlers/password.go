@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// passwordHandler handles the forgotten password endpoints.
+type passwordHandler struct {
+	*Context
+
+	Token string
+}
+
+func passwordForgotDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ph := &passwordHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(ph.Forgot),
+	}
+}
+
+func passwordResetDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ph := &passwordHandler{
+		Context: ctx,
+		Token:   getToken(ctx),
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(ph.Reset),
+	}
+}
+
+// Forgot mails a password reset link to the given email address. The
+// response is the same whether the email is registered or not.
+func (ph *passwordHandler) Forgot(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+
+	email, ok := normalizeEmail(body.Email)
+	if !ok {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeEmailInvalid)
+		return
+	}
+
+	u, err := ph.users.FindByEmail(ph, email)
+	switch {
+	case err == nil && u.Disabled:
+		scontext.GetLogger(ph).Infof("password reset requested for disabled user %s", u.Id.Hex())
+	case err == nil:
+		if err = ph.sendPasswordReset(u); err != nil {
+			scontext.GetLogger(ph).Errorf("error sending password reset email: %v", err)
+		}
+	case err == user.ErrNotFound:
+		scontext.GetLogger(ph).Infof("password reset requested for unknown email")
+	default:
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Reset sets a new password for the user the token was made for. Every
+// existing session of the user is invalidated.
+func (ph *passwordHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if len(body.Password) < user.MinPasswordLength {
+		ph.Errors = append(ph.Errors, v1.ErrorCodePasswordInvalid.WithArgs(user.MinPasswordLength))
+		return
+	}
+
+	uid, err := ph.passwordResetTokens.UserID(ph.Token)
+	if err != nil {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+
+	u, err := ph.users.FindByID(ph, uid)
+	if err == user.ErrNotFound {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if err = ph.passwordResetTokens.Check(u, ph.Token); err != nil {
+		scontext.GetLogger(ph).Infof("rejected password reset token for user %s: %v", uid, err)
+		ph.Errors = append(ph.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+
+	oldPassword := u.Password
+	if err = u.SetPassword(body.Password); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	// the token is bound to the old password, only replace it if it wasn't
+	// changed concurrently.
+	err = ph.users.UpdatePassword(ph, u, oldPassword)
+	if err == user.ErrChanged {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	scontext.GetLogger(ph).Infof("password reset for user %s", uid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendPasswordReset mails a password reset link to the user u.
+func (ctx *Context) sendPasswordReset(u *user.User) error {
+	link, err := ctx.absoluteURL("auth.password.reset", "token", ctx.passwordResetTokens.Make(u))
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Hi,\r\n\r\n"+
+		"Someone asked to reset the password of your account. To choose a new\r\n"+
+		"password, open the link below:\r\n\r\n"+
+		"%s\r\n\r\n"+
+		"If you didn't ask for it, you can ignore this email.\r\n", link)
+	ctx.sendMail(ctx, []string{u.Email}, "Reset your password", message)
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/product"
+	"github.com/syaiful6/thatique/shop/data/store"
+)
+
+// productRequest is the request body of the product creation and update
+// endpoints. The fields left out aren't updated.
+type productRequest struct {
+	Slug        *string            `json:"slug"`
+	Title       *string            `json:"title"`
+	Description *string            `json:"description"`
+	Images      *[]string          `json:"images"`
+	Category    *string            `json:"category"`
+	Weight      *int               `json:"weight"`
+	Options     *[]product.Option  `json:"options"`
+	Variants    *[]product.Variant `json:"variants"`
+	Status      *string            `json:"status"`
+}
+
+// fields returns the BSON names of the fields the request updates. The
+// variants sent replace the stored ones, stock included.
+func (req *productRequest) fields() []string {
+	var fields []string
+	for name, set := range map[string]bool{
+		"slug":        req.Slug != nil,
+		"title":       req.Title != nil,
+		"description": req.Description != nil,
+		"images":      req.Images != nil,
+		"category":    req.Category != nil,
+		"weight":      req.Weight != nil,
+		"options":     req.Options != nil,
+		"variants":    req.Variants != nil,
+		"status":      req.Status != nil,
+		// publishing sets the publication date.
+		"published_at": req.Status != nil,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// productList is the response body of the product lists.
+type productList struct {
+	Products []*product.SerializeProduct `json:"products"`
+	Total    int                         `json:"total"`
+}
+
+// productHandler handles the product endpoints of a store.
+type productHandler struct {
+	*Context
+}
+
+func productsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ph := &productHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(ph.List),
+		"POST": auth.RequireLogin(http.HandlerFunc(ph.Create)),
+	}
+}
+
+func productDispatcher(ctx *Context, r *http.Request) http.Handler {
+	ph := &productHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":    http.HandlerFunc(ph.Get),
+		"PATCH":  auth.RequireLogin(http.HandlerFunc(ph.Update)),
+		"DELETE": auth.RequireLogin(http.HandlerFunc(ph.Delete)),
+	}
+}
+
+// List returns a page of the products of the store. The public gets the
+// published products, the owner and the staff may filter them by status.
+func (ph *productHandler) List(w http.ResponseWriter, r *http.Request) {
+	s, ok := ph.visibleStore(r)
+	if !ok {
+		return
+	}
+	offset, limit, ok := ph.pagination(r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := product.StatusPublished
+	if canManage(r, s) {
+		status = query.Get("status")
+		if status != "" && !product.ValidStatus(status) {
+			ph.Errors = append(ph.Errors, v1.ErrorCodeFieldInvalid.WithArgs("status").
+				WithDetail("the status must be draft, published or archived"))
+			return
+		}
+	}
+
+	products, total, err := ph.products.List(ph, product.ListOptions{
+		StoreId:  s.Id,
+		Status:   status,
+		Category: query.Get("category"),
+		Search:   query.Get("q"),
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serialized := make([]*product.SerializeProduct, len(products))
+	for i := range products {
+		serialized[i] = products[i].Serialize()
+	}
+	serveJSON(w, http.StatusOK, productList{Products: serialized, Total: total})
+}
+
+// Create adds a product to the store of the current user. The product is a
+// draft unless another status is given. The slug is derived from the title if
+// not given.
+func (ph *productHandler) Create(w http.ResponseWriter, r *http.Request) {
+	s, ok := ph.ownStore(r)
+	if !ok {
+		return
+	}
+
+	var req productRequest
+	if err := decodeJSON(r, &req); err != nil {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if req.Slug == nil && req.Title != nil {
+		slug := data.Slugify(*req.Title)
+		req.Slug = &slug
+	}
+
+	p := &product.Product{StoreId: s.Id, Status: product.StatusDraft}
+	if !ph.apply(p, &req) {
+		return
+	}
+	if !ph.save(p, ph.products.Insert) {
+		return
+	}
+
+	scontext.GetLogger(ph).Infof("product %s added to store %s", p.Slug, s.Slug)
+	serveJSON(w, http.StatusCreated, p.Serialize())
+}
+
+// Get returns the product. The products that aren't published are only
+// visible to the owner of the store and the staff.
+func (ph *productHandler) Get(w http.ResponseWriter, r *http.Request) {
+	s, ok := ph.visibleStore(r)
+	if !ok {
+		return
+	}
+	p, ok := ph.product(r, s)
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, p.Serialize())
+}
+
+// Update changes a product of the store of the current user.
+func (ph *productHandler) Update(w http.ResponseWriter, r *http.Request) {
+	s, ok := ph.ownStore(r)
+	if !ok {
+		return
+	}
+	p, ok := ph.product(r, s)
+	if !ok {
+		return
+	}
+
+	var req productRequest
+	if err := decodeJSON(r, &req); err != nil {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if !ph.apply(p, &req) {
+		return
+	}
+	update := func(ctx context.Context, p *product.Product) error {
+		return ph.products.Update(ctx, p, req.fields()...)
+	}
+	if !ph.save(p, update) {
+		return
+	}
+
+	serveJSON(w, http.StatusOK, p.Serialize())
+}
+
+// Delete removes a product of the store of the current user. The products
+// that were sold should rather be archived.
+func (ph *productHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	s, ok := ph.ownStore(r)
+	if !ok {
+		return
+	}
+	p, ok := ph.product(r, s)
+	if !ok {
+		return
+	}
+
+	err := ph.products.Delete(ph, p.Id)
+	if err != nil && err != product.ErrNotFound {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	scontext.GetLogger(ph).Infof("product %s removed from store %s", p.Slug, s.Slug)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// product returns the product of the request, in the store s, if the current
+// user can see it, adding v1.ErrorCodeProductNotFound to the errors
+// otherwise.
+func (ph *productHandler) product(r *http.Request, s *store.Store) (*product.Product, bool) {
+	p, err := ph.products.FindBySlug(ph, s.Id, getProductSlug(ph))
+	if err != nil && err != product.ErrNotFound {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, false
+	}
+	if err == product.ErrNotFound || !(p.Published() || canManage(r, s)) {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeProductNotFound)
+		return nil, false
+	}
+	return p, true
+}
+
+// apply sets the fields of req on p and validates the product. It reports
+// whether it is valid, adding the errors otherwise.
+func (ph *productHandler) apply(p *product.Product, req *productRequest) bool {
+	if req.Slug != nil {
+		if !data.ValidSlug(*req.Slug) {
+			ph.Errors = append(ph.Errors, v1.ErrorCodeSlugInvalid)
+			return false
+		}
+		p.Slug = *req.Slug
+	}
+	if req.Title != nil {
+		p.Title = *req.Title
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.Images != nil {
+		p.Images = *req.Images
+	}
+	if req.Category != nil {
+		p.Category = *req.Category
+	}
+	if req.Weight != nil {
+		p.Weight = *req.Weight
+	}
+	if req.Options != nil {
+		p.Options = *req.Options
+	}
+	if req.Variants != nil {
+		p.Variants = *req.Variants
+	}
+	if req.Status != nil {
+		p.SetStatus(*req.Status)
+	}
+
+	p.Normalize()
+	if err := p.Validate(); err != nil {
+		detail := err.Error()
+		field := "product"
+		if fe, ok := err.(*product.FieldError); ok {
+			field, detail = fe.Field, fe.Reason
+		}
+		ph.Errors = append(ph.Errors, v1.ErrorCodeFieldInvalid.WithArgs(field).WithDetail(detail))
+		return false
+	}
+	if p.Slug == "" {
+		ph.Errors = append(ph.Errors, v1.ErrorCodeSlugInvalid)
+		return false
+	}
+	return true
+}
+
+// save inserts or updates the product, adding the errors if it fails.
+func (ph *productHandler) save(p *product.Product, save func(ctx context.Context, p *product.Product) error) bool {
+	switch err := save(ph, p); err {
+	case nil:
+		return true
+	case product.ErrSlugExists:
+		ph.Errors = append(ph.Errors, v1.ErrorCodeSlugExists)
+	case product.ErrSKUExists:
+		ph.Errors = append(ph.Errors, v1.ErrorCodeSKUExists)
+	case product.ErrNotFound:
+		ph.Errors = append(ph.Errors, v1.ErrorCodeProductNotFound)
+	default:
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+	return false
+}
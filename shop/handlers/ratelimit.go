@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syaiful6/thatique/configuration"
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/ratelimit"
+)
+
+// rateLimiter counts the events against a limit, it is implemented by
+// *ratelimit.Limiter.
+type rateLimiter interface {
+	Allow(key string, limit configuration.RateLimit) (ratelimit.Result, error)
+}
+
+// rateLimits returns the configured limits of the named route.
+func (app *App) rateLimits(name string) []configuration.RateLimit {
+	limits, _ := app.limits.Load().(map[string][]configuration.RateLimit)
+	return limits[name]
+}
+
+// storeRateLimits replaces the limits of the routes, warning about the
+// unknown route names.
+func (app *App) storeRateLimits(limits map[string][]configuration.RateLimit) {
+	for name := range limits {
+		if app.router.Get(name) == nil {
+			scontext.GetLogger(app).Warnf("ratelimits: unknown route %q", name)
+		}
+	}
+	app.limits.Store(limits)
+}
+
+// checkRateLimits counts the request against the limits of the named route.
+// It sets the RateLimit-* headers of the most restrictive limit and reports
+// whether the request is allowed, adding ErrorCodeTooManyRequests to the
+// errors otherwise. The requests are allowed if redis is unavailable.
+func (app *App) checkRateLimits(ctx *Context, w http.ResponseWriter, r *http.Request, name string) bool {
+	var (
+		tightest *ratelimit.Result
+		denied   *ratelimit.Result
+	)
+	for i, limit := range app.rateLimits(name) {
+		if !limitsMethod(limit, r.Method) {
+			continue
+		}
+
+		algorithm := limit.Algorithm
+		if algorithm == "" {
+			algorithm = ratelimit.SlidingWindow
+		}
+		key := fmt.Sprintf("%s:%d:%s:%s", name, i, algorithm, rateLimitKey(ctx, limit, r))
+		res, err := app.limiter.Allow(key, limit)
+		if err != nil {
+			scontext.GetLogger(ctx).Errorf("error checking the rate limit of %s: %v", name, err)
+			continue
+		}
+
+		if tightest == nil || res.Remaining < tightest.Remaining {
+			tightest = &res
+		}
+		if !res.Allowed && (denied == nil || res.RetryAfter > denied.RetryAfter) {
+			denied = &res
+		}
+	}
+
+	if denied != nil {
+		tightest = denied
+	}
+	if tightest != nil {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+	}
+	if denied != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(denied.RetryAfter)))
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail(map[string]interface{}{
+			"retry_after": seconds(denied.RetryAfter),
+		}))
+		scontext.GetLogger(ctx).Infof("rate limit of %s exceeded", name)
+		return false
+	}
+	return true
+}
+
+// limitsMethod reports whether limit applies to the requests of method.
+func limitsMethod(limit configuration.RateLimit, method string) bool {
+	if len(limit.Methods) == 0 {
+		return true
+	}
+	for _, m := range limit.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey returns what the request is counted by. Only the credentials
+// that were authenticated are used, anyone can send new ones to get a fresh
+// limit. They are hashed so they aren't stored in redis.
+func rateLimitKey(ctx *Context, limit configuration.RateLimit, r *http.Request) string {
+	if u := auth.GetUser(r.Context()); u != nil {
+		switch limit.Key {
+		case "user":
+			return "user:" + u.Id.Hex()
+		case "token":
+			if ctx.Session != nil && ctx.Session.ID != "" {
+				sum := sha256.Sum256([]byte(ctx.Session.ID))
+				return "token:" + hex.EncodeToString(sum[:16])
+			}
+		}
+	}
+	return "ip:" + scontext.TrustedRemoteIP(r, ctx.trustedProxies)
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
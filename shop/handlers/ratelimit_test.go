@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/configuration"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data/user"
+	"github.com/syaiful6/thatique/shop/ratelimit"
+	"github.com/syaiful6/thatique/shop/sessions"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+// fakeLimiter answers the results it is given, in order, and records the
+// keys counted.
+type fakeLimiter struct {
+	results []ratelimit.Result
+	err     error
+	keys    []string
+}
+
+func (l *fakeLimiter) Allow(key string, limit configuration.RateLimit) (ratelimit.Result, error) {
+	l.keys = append(l.keys, key)
+	if l.err != nil {
+		return ratelimit.Result{}, l.err
+	}
+	res := l.results[0]
+	l.results = l.results[1:]
+	return res, nil
+}
+
+type RateLimitSuite struct {
+	app     *App
+	limiter *fakeLimiter
+}
+
+var _ = Suite(new(RateLimitSuite))
+
+func (suite *RateLimitSuite) SetUpTest(c *C) {
+	suite.limiter = &fakeLimiter{}
+	suite.app = &App{
+		Context: context.Background(),
+		router:  RouterWithPrefix(""),
+		limiter: suite.limiter,
+	}
+	suite.app.router.NewRoute().Path("/auth/login").Name("auth.login")
+}
+
+// check runs checkRateLimits on a request of method to auth.login.
+func (suite *RateLimitSuite) check(method string, limits ...configuration.RateLimit) (bool, *Context, *httptest.ResponseRecorder) {
+	suite.app.storeRateLimits(map[string][]configuration.RateLimit{"auth.login": limits})
+	r := httptest.NewRequest(method, "/auth/login", nil)
+	ctx := &Context{App: suite.app, Context: r.Context()}
+	w := httptest.NewRecorder()
+	return suite.app.checkRateLimits(ctx, w, r, "auth.login"), ctx, w
+}
+
+// TestAllowed validates that the headers tell the most restrictive limit.
+func (suite *RateLimitSuite) TestAllowed(c *C) {
+	suite.limiter.results = []ratelimit.Result{
+		{Allowed: true, Limit: 10, Remaining: 7, Reset: 30 * time.Second},
+		{Allowed: true, Limit: 5, Remaining: 2, Reset: 1500 * time.Millisecond},
+	}
+	allowed, ctx, w := suite.check("POST",
+		configuration.RateLimit{Requests: 10, Period: time.Minute},
+		configuration.RateLimit{Algorithm: ratelimit.TokenBucket, Requests: 5, Period: time.Second})
+
+	c.Assert(allowed, Equals, true)
+	c.Assert(ctx.Errors, HasLen, 0)
+	c.Assert(suite.limiter.keys, DeepEquals, []string{
+		"auth.login:0:slidingwindow:ip:192.0.2.1",
+		"auth.login:1:tokenbucket:ip:192.0.2.1",
+	})
+	c.Assert(w.Header().Get("RateLimit-Limit"), Equals, "5")
+	c.Assert(w.Header().Get("RateLimit-Remaining"), Equals, "2")
+	c.Assert(w.Header().Get("RateLimit-Reset"), Equals, "2")
+	c.Assert(w.Header().Get("Retry-After"), Equals, "")
+}
+
+// TestDenied validates that a request over a limit is answered with a 429
+// telling when to retry.
+func (suite *RateLimitSuite) TestDenied(c *C) {
+	suite.limiter.results = []ratelimit.Result{
+		{Allowed: true, Limit: 10, Remaining: 0, Reset: time.Minute},
+		{Allowed: false, Limit: 5, Remaining: 0, RetryAfter: 2500 * time.Millisecond, Reset: 10 * time.Second},
+	}
+	allowed, ctx, w := suite.check("POST",
+		configuration.RateLimit{Requests: 10, Period: time.Minute},
+		configuration.RateLimit{Requests: 5, Period: 10 * time.Second})
+
+	c.Assert(allowed, Equals, false)
+	c.Assert(w.Header().Get("RateLimit-Limit"), Equals, "5")
+	c.Assert(w.Header().Get("RateLimit-Remaining"), Equals, "0")
+	c.Assert(w.Header().Get("RateLimit-Reset"), Equals, "10")
+	c.Assert(w.Header().Get("Retry-After"), Equals, "3")
+
+	c.Assert(errcode.ServeJSON(w, ctx.Errors), IsNil)
+	c.Assert(w.Code, Equals, http.StatusTooManyRequests)
+	var body struct {
+		Errors []struct {
+			Code   string
+			Detail map[string]int
+		}
+	}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &body), IsNil)
+	c.Assert(body.Errors, HasLen, 1)
+	c.Assert(body.Errors[0].Code, Equals, "TOOMANYREQUESTS")
+	c.Assert(body.Errors[0].Detail["retry_after"], Equals, 3)
+}
+
+// TestMethods validates that a limit only counts the requests of its
+// methods.
+func (suite *RateLimitSuite) TestMethods(c *C) {
+	allowed, _, w := suite.check("GET",
+		configuration.RateLimit{Requests: 5, Period: time.Minute, Methods: []string{"post"}})
+
+	c.Assert(allowed, Equals, true)
+	c.Assert(suite.limiter.keys, HasLen, 0)
+	c.Assert(w.Header().Get("RateLimit-Limit"), Equals, "")
+}
+
+// TestRedisDown validates that the requests are allowed when the limits
+// can't be checked.
+func (suite *RateLimitSuite) TestRedisDown(c *C) {
+	suite.limiter.err = errors.New("connection refused")
+	allowed, ctx, w := suite.check("POST", configuration.RateLimit{Requests: 5, Period: time.Minute})
+
+	c.Assert(allowed, Equals, true)
+	c.Assert(ctx.Errors, HasLen, 0)
+	c.Assert(w.Header().Get("RateLimit-Limit"), Equals, "")
+}
+
+// TestKeys validates that the requests are only counted by user or token
+// once authenticated, and by IP otherwise.
+func (suite *RateLimitSuite) TestKeys(c *C) {
+	r := httptest.NewRequest("POST", "/auth/login", nil)
+	r.Header.Set("Authorization", "Bearer forged")
+	session := sessions.NewSession(nil, "thatiq")
+	session.ID = "session id"
+	ctx := &Context{App: suite.app, Context: r.Context(), Session: session}
+
+	byUser := configuration.RateLimit{Key: "user"}
+	byToken := configuration.RateLimit{Key: "token"}
+	c.Assert(rateLimitKey(ctx, byUser, r), Equals, "ip:192.0.2.1")
+	c.Assert(rateLimitKey(ctx, byToken, r), Equals, "ip:192.0.2.1")
+
+	u := &user.User{Id: bson.NewObjectId()}
+	r = r.WithContext(auth.WithUser(r.Context(), u))
+	c.Assert(rateLimitKey(ctx, byUser, r), Equals, "user:"+u.Id.Hex())
+	c.Assert(rateLimitKey(ctx, byToken, r), Equals, "token:1cc1c70c03d3fa98125ac304150c6b2a")
+}
+
+// TestTrustedProxies validates that the proxy headers are only believed
+// when set by a trusted proxy.
+func (suite *RateLimitSuite) TestTrustedProxies(c *C) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	byIP := configuration.RateLimit{Key: "ip"}
+
+	for _, t := range []struct {
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		trusted    []*net.IPNet
+		key        string
+	}{
+		{"192.0.2.1:1234", "198.51.100.1", "", nil, "ip:192.0.2.1"},
+		{"10.0.0.2:1234", "198.51.100.1", "", nil, "ip:10.0.0.2"},
+		{"192.0.2.1:1234", "198.51.100.1", "", []*net.IPNet{trusted}, "ip:192.0.2.1"},
+		{"10.0.0.2:1234", "198.51.100.1, 10.0.0.3", "", []*net.IPNet{trusted}, "ip:198.51.100.1"},
+		{"10.0.0.2:1234", "203.0.113.6, 198.51.100.1", "", []*net.IPNet{trusted}, "ip:198.51.100.1"},
+		{"10.0.0.2:1234", "10.0.0.4, 10.0.0.3", "", []*net.IPNet{trusted}, "ip:10.0.0.4"},
+		{"10.0.0.2:1234", "", "198.51.100.1", []*net.IPNet{trusted}, "ip:198.51.100.1"},
+		{"10.0.0.2:1234", "", "", []*net.IPNet{trusted}, "ip:10.0.0.2"},
+	} {
+		r := httptest.NewRequest("POST", "/auth/login", nil)
+		r.RemoteAddr = t.remoteAddr
+		if t.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", t.forwarded)
+		}
+		if t.realIP != "" {
+			r.Header.Set("X-Real-Ip", t.realIP)
+		}
+		suite.app.trustedProxies = t.trusted
+		ctx := &Context{App: suite.app, Context: r.Context()}
+		c.Assert(rateLimitKey(ctx, byIP, r), Equals, t.key, Commentf("%+v", t))
+	}
+}
@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/service"
+	"github.com/syaiful6/thatique/shop/data/store"
+)
+
+const (
+	// defaultSlotDays and maxSlotDays are the default and maximum number of
+	// days of slots listed at once.
+	defaultSlotDays = 7
+	maxSlotDays     = 31
+
+	// bookingHorizon is how far ahead the slots can be booked.
+	bookingHorizon = 90 * 24 * time.Hour
+)
+
+// serviceRequest is the request body of the service creation and update
+// endpoints. The fields left out aren't updated.
+type serviceRequest struct {
+	Slug         *string               `json:"slug"`
+	Title        *string               `json:"title"`
+	Description  *string               `json:"description"`
+	Images       *[]string             `json:"images"`
+	Category     *string               `json:"category"`
+	Duration     *int                  `json:"duration"`
+	Price        *int64                `json:"price"`
+	Location     *string               `json:"location"`
+	Remote       *bool                 `json:"remote"`
+	TimeZone     *string               `json:"time_zone"`
+	Availability *service.Availability `json:"availability"`
+	Status       *string               `json:"status"`
+}
+
+// fields returns the BSON names of the fields the request updates.
+func (req *serviceRequest) fields() []string {
+	var fields []string
+	for name, set := range map[string]bool{
+		"slug":         req.Slug != nil,
+		"title":        req.Title != nil,
+		"description":  req.Description != nil,
+		"images":       req.Images != nil,
+		"category":     req.Category != nil,
+		"duration":     req.Duration != nil,
+		"price":        req.Price != nil,
+		"location":     req.Location != nil,
+		"remote":       req.Remote != nil,
+		"time_zone":    req.TimeZone != nil,
+		"availability": req.Availability != nil,
+		"status":       req.Status != nil,
+		// publishing sets the publication date.
+		"published_at": req.Status != nil,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// serviceList is the response body of the service lists.
+type serviceList struct {
+	Services []*service.SerializeService `json:"services"`
+	Total    int                         `json:"total"`
+}
+
+// slotList is the response body of the free slots of a service.
+type slotList struct {
+	Slots []time.Time `json:"slots"`
+}
+
+// serviceItem is a service listed on the services page, along with its
+// store.
+type serviceItem struct {
+	Service *service.SerializeService
+	Store   *store.SerializeStore
+}
+
+// servicePage is the data of the page of a service.
+type servicePage struct {
+	Service *service.SerializeService
+	Store   *store.SerializeStore
+	Slots   []time.Time
+}
+
+// serviceHandler handles the service endpoints of a store and the service
+// pages.
+type serviceHandler struct {
+	*Context
+}
+
+func servicesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &serviceHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(sh.List),
+		"POST": auth.RequireLogin(http.HandlerFunc(sh.Create)),
+	}
+}
+
+func serviceDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &serviceHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":   http.HandlerFunc(sh.Get),
+		"PATCH": auth.RequireLogin(http.HandlerFunc(sh.Update)),
+	}
+}
+
+func serviceSlotsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &serviceHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.Slots),
+	}
+}
+
+func servicePagesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &serviceHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.IndexPage),
+	}
+}
+
+func servicePageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &serviceHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.ShowPage),
+	}
+}
+
+// List returns a page of the services of the store. The public gets the
+// published services, the owner and the staff may filter them by status.
+func (sh *serviceHandler) List(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	offset, limit, ok := sh.pagination(r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := service.StatusPublished
+	if canManage(r, s) {
+		status = query.Get("status")
+		if status != "" && !service.ValidStatus(status) {
+			sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("status").
+				WithDetail("the status must be draft, published or archived"))
+			return
+		}
+	}
+
+	services, total, err := sh.services.List(sh, service.ListOptions{
+		StoreId:  s.Id,
+		Status:   status,
+		Category: query.Get("category"),
+		Search:   query.Get("q"),
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serialized := make([]*service.SerializeService, len(services))
+	for i := range services {
+		serialized[i] = services[i].Serialize()
+	}
+	serveJSON(w, http.StatusOK, serviceList{Services: serialized, Total: total})
+}
+
+// Create adds a service to the store of the current user. The service is a
+// draft unless another status is given. The slug is derived from the title if
+// not given.
+func (sh *serviceHandler) Create(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.ownStore(r)
+	if !ok {
+		return
+	}
+
+	var req serviceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if req.Slug == nil && req.Title != nil {
+		slug := data.Slugify(*req.Title)
+		req.Slug = &slug
+	}
+
+	svc := &service.Service{StoreId: s.Id, Status: service.StatusDraft}
+	if !sh.apply(svc, &req) {
+		return
+	}
+	if !sh.save(svc, sh.services.Insert) {
+		return
+	}
+
+	scontext.GetLogger(sh).Infof("service %s added to store %s", svc.Slug, s.Slug)
+	serveJSON(w, http.StatusCreated, svc.Serialize())
+}
+
+// Get returns the service. The services that aren't published are only
+// visible to the owner of the store and the staff.
+func (sh *serviceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	svc, ok := sh.visibleService(r, s)
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, svc.Serialize())
+}
+
+// Update changes a service of the store of the current user. The services
+// aren't deleted, they are archived to keep their bookings.
+func (sh *serviceHandler) Update(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.ownStore(r)
+	if !ok {
+		return
+	}
+	svc, ok := sh.visibleService(r, s)
+	if !ok {
+		return
+	}
+
+	var req serviceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if !sh.apply(svc, &req) {
+		return
+	}
+	update := func(ctx context.Context, svc *service.Service) error {
+		return sh.services.Update(ctx, svc, req.fields()...)
+	}
+	if !sh.save(svc, update) {
+		return
+	}
+
+	serveJSON(w, http.StatusOK, svc.Serialize())
+}
+
+// Slots returns the free slots of the service, for the days query parameter
+// number of days starting at the from query parameter, formatted as
+// 2006-01-02 in the time zone of the service. It defaults to the next 7
+// days.
+func (sh *serviceHandler) Slots(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	svc, ok := sh.visibleService(r, s)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from := time.Now().In(svc.Zone())
+	if raw := query.Get("from"); raw != "" {
+		date, err := time.ParseInLocation("2006-01-02", raw, svc.Zone())
+		if err != nil {
+			sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("from").
+				WithDetail("the from date must be formatted as 2006-01-02"))
+			return
+		}
+		from = date
+	}
+	days := defaultSlotDays
+	if raw := query.Get("days"); raw != "" {
+		if days, _ = strconv.Atoi(raw); days < 1 || days > maxSlotDays {
+			sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("days").
+				WithDetail(fmt.Sprintf("the number of days must be in between 1 and %d", maxSlotDays)))
+			return
+		}
+	}
+
+	slots, ok := sh.freeSlots(svc, from, from.AddDate(0, 0, days))
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, slotList{Slots: slots})
+}
+
+// IndexPage renders the page listing the published services of the active
+// stores.
+func (sh *serviceHandler) IndexPage(w http.ResponseWriter, r *http.Request) {
+	offset, limit, ok := sh.pagination(r)
+	if !ok {
+		return
+	}
+
+	services, total, err := sh.services.List(sh, service.ListOptions{
+		Status:   service.StatusPublished,
+		Category: r.URL.Query().Get("category"),
+		Search:   r.URL.Query().Get("q"),
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	// The services of the stores that aren't active are left out of the
+	// page, they are few enough not to matter to the pagination.
+	stores := make(map[bson.ObjectId]*store.Store)
+	items := make([]serviceItem, 0, len(services))
+	for i := range services {
+		s, seen := stores[services[i].StoreId]
+		if !seen {
+			s, err = sh.stores.FindByID(sh, services[i].StoreId.Hex())
+			if err != nil && err != store.ErrNotFound {
+				sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+			stores[services[i].StoreId] = s
+		}
+		if s == nil || !s.Public() {
+			continue
+		}
+		items = append(items, serviceItem{Service: services[i].Serialize(), Store: s.Serialize()})
+	}
+
+	sh.renderHTML(w, r, http.StatusOK, "jasa/index", &Page{
+		Title: "Jasa",
+		Data: struct {
+			Services []serviceItem
+			Total    int
+		}{items, total},
+	})
+}
+
+// ShowPage renders the page of a service, with its free slots of the coming
+// week.
+func (sh *serviceHandler) ShowPage(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	svc, ok := sh.visibleService(r, s)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	slots, ok := sh.freeSlots(svc, now, now.AddDate(0, 0, defaultSlotDays))
+	if !ok {
+		return
+	}
+
+	sh.renderHTML(w, r, http.StatusOK, "jasa/show", &Page{
+		Title:       svc.Title,
+		Description: svc.Description,
+		Data:        servicePage{Service: svc.Serialize(), Store: s.Serialize(), Slots: slots},
+	})
+}
+
+// apply sets the fields of req on svc and validates the service. It reports
+// whether it is valid, adding the errors otherwise.
+func (sh *serviceHandler) apply(svc *service.Service, req *serviceRequest) bool {
+	if req.Slug != nil {
+		if !data.ValidSlug(*req.Slug) {
+			sh.Errors = append(sh.Errors, v1.ErrorCodeSlugInvalid)
+			return false
+		}
+		svc.Slug = *req.Slug
+	}
+	if req.Title != nil {
+		svc.Title = *req.Title
+	}
+	if req.Description != nil {
+		svc.Description = *req.Description
+	}
+	if req.Images != nil {
+		svc.Images = *req.Images
+	}
+	if req.Category != nil {
+		svc.Category = *req.Category
+	}
+	if req.Duration != nil {
+		svc.Duration = *req.Duration
+	}
+	if req.Price != nil {
+		svc.Price = *req.Price
+	}
+	if req.Location != nil {
+		svc.Location = *req.Location
+	}
+	if req.Remote != nil {
+		svc.Remote = *req.Remote
+	}
+	if req.TimeZone != nil {
+		svc.TimeZone = *req.TimeZone
+	}
+	if req.Availability != nil {
+		svc.Availability = *req.Availability
+	}
+	if req.Status != nil {
+		svc.SetStatus(*req.Status)
+	}
+
+	svc.Normalize()
+	if err := svc.Validate(); err != nil {
+		detail := err.Error()
+		field := "service"
+		if fe, ok := err.(*service.FieldError); ok {
+			field, detail = fe.Field, fe.Reason
+		}
+		sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs(field).WithDetail(detail))
+		return false
+	}
+	if svc.Slug == "" {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeSlugInvalid)
+		return false
+	}
+	return true
+}
+
+// save inserts or updates the service, adding the errors if it fails.
+func (sh *serviceHandler) save(svc *service.Service, save func(ctx context.Context, svc *service.Service) error) bool {
+	switch err := save(sh, svc); err {
+	case nil:
+		return true
+	case service.ErrSlugExists:
+		sh.Errors = append(sh.Errors, v1.ErrorCodeSlugExists)
+	case service.ErrNotFound:
+		sh.Errors = append(sh.Errors, v1.ErrorCodeServiceNotFound)
+	default:
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+	return false
+}
+
+// visibleService returns the service of the request, in the store s, if the
+// current user can see it, adding v1.ErrorCodeServiceNotFound to the errors
+// otherwise.
+func (ctx *Context) visibleService(r *http.Request, s *store.Store) (*service.Service, bool) {
+	svc, err := ctx.services.FindBySlug(ctx, s.Id, getServiceSlug(ctx))
+	if err != nil && err != service.ErrNotFound {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, false
+	}
+	if err == service.ErrNotFound || !(svc.Published() || canManage(r, s)) {
+		ctx.Errors = append(ctx.Errors, v1.ErrorCodeServiceNotFound)
+		return nil, false
+	}
+	return svc, true
+}
+
+// freeSlots returns the slots of svc starting in between from and to that
+// can be booked: they are to come, within the booking horizon, and no active
+// booking overlaps them.
+func (ctx *Context) freeSlots(svc *service.Service, from, to time.Time) ([]time.Time, bool) {
+	now := time.Now()
+	if from.Before(now) {
+		from = now
+	}
+	if horizon := now.Add(bookingHorizon); to.After(horizon) {
+		to = horizon
+	}
+	slots := []time.Time{}
+	if !from.Before(to) {
+		return slots, true
+	}
+
+	duration := time.Duration(svc.Duration) * time.Minute
+	booked, err := ctx.services.Booked(ctx, svc.Id, from, to.Add(duration))
+	if err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, false
+	}
+	for _, slot := range svc.Slots(from, to) {
+		free := true
+		for i := range booked {
+			if booked[i].Overlaps(slot, slot.Add(duration)) {
+				free = false
+				break
+			}
+		}
+		if free {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, true
+}
+
+// bookable reports whether a slot of svc starting at start can be booked:
+// it is to come, within the booking horizon. The repository checks that no
+// other booking holds it.
+func bookable(svc *service.Service, start time.Time) bool {
+	now := time.Now()
+	return start.After(now) && start.Before(now.Add(bookingHorizon)) && svc.Available(start)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/syaiful6/thatique/assets"
+	"github.com/syaiful6/thatique/configuration"
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/static"
+)
+
+// defaultStaticDirectory is the directory of the static files used if none
+// was configured.
+const defaultStaticDirectory = "assets/static"
+
+// errNotEmbedded is returned when the embedded assets are configured but the
+// binary was built without them.
+var errNotEmbedded = errors.New("static.embedded is set but the shop was built without the embedassets tag")
+
+// configureStatic hashes the static files served under /static/, the
+// embedded ones if static.embedded is set. It must be called before
+// configureTemplates, the templates link to the static files.
+func (app *App) configureStatic(config *configuration.Configuration) error {
+	dir := config.Static.Directory
+	if dir == "" {
+		dir = defaultStaticDirectory
+	}
+	fsys := os.DirFS(dir)
+	if config.Static.Embedded {
+		if assets.FS == nil {
+			return errNotEmbedded
+		}
+		dir = "embedded static files"
+		fsys, _ = fs.Sub(assets.FS, "static")
+	}
+
+	prefix := path.Join("/", config.HTTP.Prefix, "static") + "/"
+	files, err := static.New(fsys, prefix, config.Static.Reload)
+	if err != nil {
+		return fmt.Errorf("error hashing the static files of %s: %v", dir, err)
+	}
+	scontext.GetLogger(app).Infof("serving the static files of %s under %s", dir, prefix)
+	app.static = files
+	return nil
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/store"
+)
+
+// maxListLimit is the maximum number of items a page of a list has.
+const maxListLimit = 100
+
+// storeRequest is the request body of the store creation and update
+// endpoints. The fields left out aren't updated.
+type storeRequest struct {
+	Slug        *string        `json:"slug"`
+	Name        *string        `json:"name"`
+	Description *string        `json:"description"`
+	Logo        *string        `json:"logo"`
+	Address     *store.Address `json:"address"`
+}
+
+// fields returns the BSON names of the fields the request updates.
+func (req *storeRequest) fields() []string {
+	var fields []string
+	for name, set := range map[string]bool{
+		"slug":        req.Slug != nil,
+		"name":        req.Name != nil,
+		"description": req.Description != nil,
+		"logo":        req.Logo != nil,
+		"address":     req.Address != nil,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// storeStatusRequest is the request body of the store status endpoint.
+type storeStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// storeList is the response body of the store lists.
+type storeList struct {
+	Stores []*store.SerializeStore `json:"stores"`
+	Total  int                     `json:"total"`
+}
+
+// storeHandler handles the store endpoints and pages.
+type storeHandler struct {
+	*Context
+}
+
+func storesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":  http.HandlerFunc(sh.List),
+		"POST": auth.RequireLogin(http.HandlerFunc(sh.Create)),
+	}
+}
+
+func myStoresDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": auth.RequireLogin(http.HandlerFunc(sh.Mine)),
+	}
+}
+
+func storeDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET":   http.HandlerFunc(sh.Get),
+		"PATCH": auth.RequireLogin(http.HandlerFunc(sh.Update)),
+	}
+}
+
+func storeStatusDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"PUT": auth.RequireStaff(http.HandlerFunc(sh.UpdateStatus)),
+	}
+}
+
+func storePagesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.IndexPage),
+	}
+}
+
+func storePageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sh := &storeHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.ShowPage),
+	}
+}
+
+// List returns a page of the active stores.
+func (sh *storeHandler) List(w http.ResponseWriter, r *http.Request) {
+	stores, total, ok := sh.listActive(r)
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, storeList{Stores: serializeStores(stores), Total: total})
+}
+
+// Mine returns the stores of the current user, whatever their status.
+func (sh *storeHandler) Mine(w http.ResponseWriter, r *http.Request) {
+	stores, err := sh.stores.FindByOwner(sh, auth.GetUser(r.Context()).Id)
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	serveJSON(w, http.StatusOK, storeList{Stores: serializeStores(stores), Total: len(stores)})
+}
+
+// Create opens a store owned by the current user. The store is pending until
+// the staff activates it. The slug is derived from the name if not given.
+func (sh *storeHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req storeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if req.Name == nil {
+		req.Name = new(string)
+	}
+	if req.Slug == nil {
+		slug := data.Slugify(*req.Name)
+		req.Slug = &slug
+	}
+
+	s := &store.Store{OwnerId: auth.GetUser(r.Context()).Id}
+	if !sh.apply(s, &req) {
+		return
+	}
+
+	err := sh.stores.Insert(sh, s)
+	if err == store.ErrSlugExists {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeSlugExists)
+		return
+	}
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	scontext.GetLogger(sh).Infof("store %s opened by %s", s.Slug, s.OwnerId.Hex())
+	serveJSON(w, http.StatusCreated, s.Serialize())
+}
+
+// Get returns the store. The stores that aren't active are only visible to
+// their owner and the staff.
+func (sh *storeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	serveJSON(w, http.StatusOK, s.Serialize())
+}
+
+// Update changes the store of the current user.
+func (sh *storeHandler) Update(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.ownStore(r)
+	if !ok {
+		return
+	}
+
+	var req storeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if !sh.apply(s, &req) {
+		return
+	}
+
+	err := sh.stores.Update(sh, s, req.fields()...)
+	if err == store.ErrSlugExists {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeSlugExists)
+		return
+	}
+	if err == store.ErrNotFound {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeStoreNotFound)
+		return
+	}
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serveJSON(w, http.StatusOK, s.Serialize())
+}
+
+// UpdateStatus activates, suspends or puts back in review a store. It is
+// restricted to the staff.
+func (sh *storeHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	s, err := sh.stores.FindBySlug(sh, getSlug(sh))
+	if err == store.ErrNotFound {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeStoreNotFound)
+		return
+	}
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	var req storeStatusRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeBodyMalformed.WithDetail(err.Error()))
+		return
+	}
+	if !store.ValidStatus(req.Status) {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs("status").WithDetail(store.ErrStatusInvalid.Error()))
+		return
+	}
+
+	s.Status = req.Status
+	if err = sh.stores.UpdateStatus(sh, s); err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	scontext.GetLogger(sh).Infof("store %s set %s by %s", s.Slug, s.Status, auth.GetUser(r.Context()).Id.Hex())
+	serveJSON(w, http.StatusOK, s.Serialize())
+}
+
+// IndexPage renders the page listing the active stores.
+func (sh *storeHandler) IndexPage(w http.ResponseWriter, r *http.Request) {
+	stores, total, ok := sh.listActive(r)
+	if !ok {
+		return
+	}
+	sh.renderHTML(w, r, http.StatusOK, "toko/index", &Page{
+		Title: "Toko",
+		Data:  storeList{Stores: serializeStores(stores), Total: total},
+	})
+}
+
+// ShowPage renders the page of a store.
+func (sh *storeHandler) ShowPage(w http.ResponseWriter, r *http.Request) {
+	s, ok := sh.visibleStore(r)
+	if !ok {
+		return
+	}
+	sh.renderHTML(w, r, http.StatusOK, "toko/show", &Page{
+		Title:       s.Name,
+		Description: s.Description,
+		Data:        s.Serialize(),
+	})
+}
+
+// listActive returns the page of active stores requested by the offset,
+// limit and q query parameters.
+func (sh *storeHandler) listActive(r *http.Request) ([]store.Store, int, bool) {
+	offset, limit, ok := sh.pagination(r)
+	if !ok {
+		return nil, 0, false
+	}
+
+	stores, total, err := sh.stores.List(sh, store.ListOptions{
+		Status: store.StatusActive,
+		Search: r.URL.Query().Get("q"),
+		Offset: offset,
+		Limit:  limit,
+	})
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, 0, false
+	}
+	return stores, total, true
+}
+
+// visibleStore returns the store of the request if the current user can see
+// it, adding v1.ErrorCodeStoreNotFound to the errors otherwise.
+func (ctx *Context) visibleStore(r *http.Request) (*store.Store, bool) {
+	s, err := ctx.stores.FindBySlug(ctx, getSlug(ctx))
+	if err != nil && err != store.ErrNotFound {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return nil, false
+	}
+	if err == nil && !s.Public() && !canManage(r, s) {
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound {
+		ctx.Errors = append(ctx.Errors, v1.ErrorCodeStoreNotFound)
+		return nil, false
+	}
+	return s, true
+}
+
+// ownStore returns the store of the request if the current user owns it,
+// adding the relevant error to the errors otherwise.
+func (ctx *Context) ownStore(r *http.Request) (*store.Store, bool) {
+	s, ok := ctx.visibleStore(r)
+	if !ok {
+		return nil, false
+	}
+	if !s.IsOwner(auth.GetUser(r.Context()).Id) {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDenied)
+		return nil, false
+	}
+	return s, true
+}
+
+// apply validates the fields of req and sets them on s. It reports whether
+// they are all valid, adding the errors otherwise.
+func (sh *storeHandler) apply(s *store.Store, req *storeRequest) bool {
+	invalid := func(field string, err error) {
+		sh.Errors = append(sh.Errors, v1.ErrorCodeFieldInvalid.WithArgs(field).WithDetail(err.Error()))
+	}
+
+	if req.Slug != nil {
+		if !store.ValidSlug(*req.Slug) {
+			sh.Errors = append(sh.Errors, v1.ErrorCodeSlugInvalid)
+		} else {
+			s.Slug = *req.Slug
+		}
+	}
+	if req.Name != nil {
+		if name, err := store.ValidateName(*req.Name); err != nil {
+			invalid("name", err)
+		} else {
+			s.Name = name
+		}
+	}
+	if req.Description != nil {
+		if description, err := store.ValidateDescription(*req.Description); err != nil {
+			invalid("description", err)
+		} else {
+			s.Description = description
+		}
+	}
+	if req.Logo != nil {
+		if logo, err := store.ValidateLogo(*req.Logo); err != nil {
+			invalid("logo", err)
+		} else {
+			s.Logo = logo
+		}
+	}
+	if req.Address != nil {
+		s.Address = *req.Address
+	}
+	return sh.Errors.Len() == 0
+}
+
+// pagination returns the offset and limit query parameters, adding
+// v1.ErrorCodeFieldInvalid to the errors if they aren't valid.
+func (ctx *Context) pagination(r *http.Request) (offset, limit int, ok bool) {
+	query := r.URL.Query()
+	if raw := query.Get("offset"); raw != "" {
+		if offset, _ = strconv.Atoi(raw); offset < 1 && raw != "0" {
+			ctx.Errors = append(ctx.Errors, v1.ErrorCodeFieldInvalid.WithArgs("offset").
+				WithDetail("the offset must be a positive integer"))
+			return 0, 0, false
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		if limit, _ = strconv.Atoi(raw); limit < 1 || limit > maxListLimit {
+			ctx.Errors = append(ctx.Errors, v1.ErrorCodeFieldInvalid.WithArgs("limit").
+				WithDetail(fmt.Sprintf("the limit must be in between 1 and %d", maxListLimit)))
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+func serializeStores(stores []store.Store) []*store.SerializeStore {
+	serialized := make([]*store.SerializeStore, len(stores))
+	for i := range stores {
+		serialized[i] = stores[i].Serialize()
+	}
+	return serialized
+}
+
+// canManage reports whether the current user manages the store s, being its
+// owner or a staff member, superusers included.
+func canManage(r *http.Request, s *store.Store) bool {
+	u := auth.GetUser(r.Context())
+	return u != nil && (s.IsOwner(u.Id) || u.IsStaff())
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/syaiful6/thatique/assets"
+	"github.com/syaiful6/thatique/configuration"
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/csrf"
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// defaultTemplatesDirectory is the root of the templates tree used if none
+// was configured.
+const defaultTemplatesDirectory = "assets/templates"
+
+const (
+	// baseTemplate is the layout the pages extend by default.
+	baseTemplate = "base.html"
+
+	// partialsDirectory holds the templates defined in every page, such
+	// as the shared snippets.
+	partialsDirectory = "partials"
+
+	// errorTemplate is the page rendering the errcode errors.
+	errorTemplate = "errors/error"
+)
+
+// extendsRe matches the comment a template starts with to extend another
+// layout than base.html, e.g. {{/* extends "layouts/account.html" */}}.
+var extendsRe = regexp.MustCompile(`^\s*{{-?\s*/\*\s*extends\s+"([^"]+)"\s*\*/\s*-?}}`)
+
+// Page is the data the page templates are executed with.
+type Page struct {
+	// Title and Description fill the title and the description meta tag
+	// of the layout.
+	Title       string
+	Description string
+
+	// User is the logged in user, nil for the anonymous visitors.
+	User *user.User
+
+	// CSRFToken is the token the forms must include in the csrf_token
+	// field, see CSRFField.
+	CSRFToken string
+
+	// Version is the version of the shop.
+	Version string
+
+	// Flashes are the messages added to the session since the last page
+	// rendered.
+	Flashes []Flash
+
+	// Data is the data specific to the page.
+	Data interface{}
+}
+
+// CSRFField returns the hidden input carrying the CSRF token, to be included
+// in the forms.
+func (p *Page) CSRFField() template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		csrf.FieldName, template.HTMLEscapeString(p.CSRFToken)))
+}
+
+// renderer executes the pages of a templates tree. Each page is parsed along
+// with the layouts it extends, its blocks overriding theirs, and the
+// partials. A page is named after its path, relative to the root of the tree
+// and without extension, e.g. "errors/error".
+type renderer struct {
+	fsys   fs.FS
+	dir    string
+	reload bool
+	funcs  template.FuncMap
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+
+	// stamp identifies the state of the tree the pages were parsed from,
+	// to tell when they must be parsed again.
+	stamp string
+}
+
+// newRenderer parses the templates tree of fsys, dir naming it in the errors.
+// If reload is set, the tree is parsed again when a file changes.
+func newRenderer(fsys fs.FS, dir string, reload bool, funcs template.FuncMap) (*renderer, error) {
+	r := &renderer{
+		fsys:   fsys,
+		dir:    dir,
+		reload: reload,
+		funcs:  funcs,
+	}
+	stamp, err := r.stampTree()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.parse(stamp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// render executes the named page with data, buffering the output so that
+// nothing is written if it fails.
+func (r *renderer) render(name string, data interface{}) ([]byte, error) {
+	t, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *renderer) lookup(name string) (*template.Template, error) {
+	if r.reload {
+		stamp, err := r.stampTree()
+		if err != nil {
+			return nil, err
+		}
+		r.mu.RLock()
+		changed := stamp != r.stamp
+		r.mu.RUnlock()
+		if changed {
+			if err = r.parse(stamp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("template %q not found in %s", name, r.dir)
+	}
+	return t, nil
+}
+
+// parse parses every page of the tree, replacing the pages only if they are
+// all valid.
+func (r *renderer) parse(stamp string) error {
+	files := make(map[string]string)
+	err := r.walk(func(name string, fi fs.FileInfo) error {
+		content, err := fs.ReadFile(r.fsys, name)
+		if err != nil {
+			return err
+		}
+		files[name] = string(content)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, ok := files[baseTemplate]; !ok {
+		return fmt.Errorf("templates: %s not found in %s", baseTemplate, r.dir)
+	}
+
+	var partials []string
+	for name := range files {
+		if strings.HasPrefix(name, partialsDirectory+"/") {
+			partials = append(partials, name)
+		}
+	}
+
+	pages := make(map[string]*template.Template)
+	for name := range files {
+		if name == baseTemplate || strings.HasPrefix(name, partialsDirectory+"/") {
+			continue
+		}
+		chain, err := r.chain(files, name)
+		if err != nil {
+			return err
+		}
+
+		// the root layout is executed, the blocks of its descendants
+		// parsed after it override its own.
+		t := template.New("").Funcs(r.funcs)
+		for _, file := range append(append([]string(nil), partials...), chain...) {
+			if _, err = t.New(file).Parse(files[file]); err != nil {
+				return fmt.Errorf("templates: %v", err)
+			}
+		}
+		pages[strings.TrimSuffix(name, path.Ext(name))] = t.Lookup(chain[0])
+	}
+
+	r.mu.Lock()
+	r.pages, r.stamp = pages, stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// chain returns the layouts the page name extends, from the root layout down
+// to the page itself.
+func (r *renderer) chain(files map[string]string, name string) ([]string, error) {
+	chain := []string{name}
+	seen := map[string]bool{name: true}
+	for current := name; current != baseTemplate; {
+		parent := baseTemplate
+		if m := extendsRe.FindStringSubmatch(files[current]); m != nil {
+			parent = path.Clean(m[1])
+		}
+		if _, ok := files[parent]; !ok {
+			return nil, fmt.Errorf("templates: %s extends unknown template %q", current, parent)
+		}
+		if seen[parent] {
+			return nil, fmt.Errorf("templates: %s extends %s in a loop", current, parent)
+		}
+		seen[parent] = true
+		chain = append([]string{parent}, chain...)
+		current = parent
+	}
+	return chain, nil
+}
+
+// stampTree returns the modification times and sizes of the templates, which
+// change when a template is edited, added or removed.
+func (r *renderer) stampTree() (string, error) {
+	var buf bytes.Buffer
+	err := r.walk(func(name string, fi fs.FileInfo) error {
+		fmt.Fprintf(&buf, "%s:%d:%d;", name, fi.ModTime().UnixNano(), fi.Size())
+		return nil
+	})
+	return buf.String(), err
+}
+
+// walk calls f with the slash separated name, relative to the root, of every
+// template of the tree.
+func (r *renderer) walk(f func(name string, fi fs.FileInfo) error) error {
+	return fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != ".html" {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return f(name, fi)
+	})
+}
+
+// templateFuncs returns the functions available to the templates.
+func (app *App) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// static returns the URL of the named static file, under its
+		// hashed name, e.g. {{ static "css/application.css" }}
+		"static": func(name string) string {
+			return app.static.URL(name)
+		},
+		// url builds the path of the named route, e.g.
+		// {{ url "auth.verify" "token" .Token }}
+		"url": func(name string, pairs ...string) (string, error) {
+			route := app.router.Get(name)
+			if route == nil {
+				return "", fmt.Errorf("unknown route %q", name)
+			}
+			u, err := route.URL(pairs...)
+			if err != nil {
+				return "", err
+			}
+			return u.String(), nil
+		},
+	}
+}
+
+// configureTemplates parses the templates tree, the embedded one if
+// static.embedded is set.
+func (app *App) configureTemplates(config *configuration.Configuration) error {
+	dir := config.Templates.Directory
+	if dir == "" {
+		dir = defaultTemplatesDirectory
+	}
+	fsys := os.DirFS(dir)
+	if config.Static.Embedded {
+		if assets.FS == nil {
+			return errNotEmbedded
+		}
+		dir = "embedded templates"
+		fsys, _ = fs.Sub(assets.FS, "templates")
+	}
+
+	renderer, err := newRenderer(fsys, dir, config.Templates.Reload, app.templateFuncs())
+	if err != nil {
+		return fmt.Errorf("error parsing the templates: %v", err)
+	}
+	if config.Templates.Reload {
+		scontext.GetLogger(app).Infof("templates of %s are reloaded when they change", dir)
+	}
+	app.templates = renderer
+	return nil
+}
+
+// renderHTML renders the named page with the given status code. The common
+// data of page, the user, CSRF token, version and flash messages, is filled
+// in. It must be called before the response is written, the session may have
+// to be saved.
+func (ctx *Context) renderHTML(w http.ResponseWriter, r *http.Request, status int, name string, page *Page) {
+	page.User = auth.GetUser(r.Context())
+	page.Version = scontext.GetVersion(ctx.App)
+	if token, err := ctx.CSRFToken(w, r); err == nil {
+		page.CSRFToken = token
+	}
+	page.Flashes = ctx.flashes(w, r)
+
+	body, err := ctx.templates.render(name, page)
+	if err != nil {
+		scontext.GetLogger(ctx).Errorf("error rendering template %s: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// errorPageData is the data of the error page.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Errors     []errcode.Error
+}
+
+// serveErrorPage renders the errors as an HTML page, with the status code of
+// the first one, like errcode.ServeJSON. The details of the unknown errors
+// are hidden, they may leak internals.
+func (ctx *Context) serveErrorPage(w http.ResponseWriter, r *http.Request, errs errcode.Errors) {
+	data := errorPageData{Status: http.StatusInternalServerError}
+	for i, err := range errs {
+		var e errcode.Error
+		switch err := err.(type) {
+		case errcode.ErrorCode:
+			e = err.WithDetail(nil)
+		case errcode.Error:
+			e = err
+		default:
+			e = errcode.ErrorCodeUnknown.WithDetail(nil)
+		}
+		if e.Message == "" {
+			e.Message = e.Code.Message()
+		}
+		if e.Code == errcode.ErrorCodeUnknown {
+			e.Detail = nil
+		}
+		if status := e.Code.Descriptor().HTTPStatusCode; i == 0 && status != 0 {
+			data.Status = status
+		}
+		data.Errors = append(data.Errors, e)
+	}
+	data.StatusText = http.StatusText(data.Status)
+
+	ctx.renderHTML(w, r, data.Status, errorTemplate, &Page{
+		Title: data.StatusText,
+		Data:  data,
+	})
+}
+
+// serveErrors writes errs as the response, an HTML page to the browsers and
+// JSON to the API clients.
+func (ctx *Context) serveErrors(w http.ResponseWriter, r *http.Request, errs errcode.Errors) {
+	if acceptsHTML(r) {
+		ctx.serveErrorPage(w, r, errs)
+	} else if err := errcode.ServeJSON(w, errs); err != nil {
+		scontext.GetLogger(ctx).Errorf("error serving error json: %v (from %v)", err, errs)
+	}
+}
+
+// acceptsHTML reports whether the client prefers HTML over JSON, as browsers
+// do. The API clients, which send no Accept header or accept anything, get
+// JSON.
+func acceptsHTML(r *http.Request) bool {
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			mediaType = part[:i]
+			for _, param := range strings.Split(part[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					fmt.Sscanf(param[2:], "%g", &q)
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html", "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case "application/json", "application/*":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	return htmlQ > 0 && htmlQ >= jsonQ
+}
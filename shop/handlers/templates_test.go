@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing/fstest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/csrf"
+	"github.com/syaiful6/thatique/shop/sessions"
+)
+
+// memoryStore serves a single session to every request, counting the saves.
+type memoryStore struct {
+	session *sessions.Session
+	saved   int
+}
+
+func (s *memoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.session, nil
+}
+
+func (s *memoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.session, nil
+}
+
+func (s *memoryStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	s.saved++
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	return nil
+}
+
+// templates is a templates tree with a layout, a partial and a page
+// extending the layout.
+func templates() fstest.MapFS {
+	return fstest.MapFS{
+		"base.html": {Data: []byte(
+			`<title>{{ .Title }}</title>{{range .Flashes}}[{{ .Level }}: {{ .Message }}]{{end}}{{block "content" .}}base{{end}}`)},
+		"partials/greeting.html": {Data: []byte(`{{define "greeting"}}hello {{.}}{{end}}`)},
+		"layouts/account.html": {Data: []byte(
+			`{{define "content"}}<nav>account</nav>{{block "account" .}}{{end}}{{end}}`)},
+		"account/show.html": {Data: []byte(
+			`{{/* extends "layouts/account.html" */}}{{define "account"}}{{template "greeting" .Data}}{{end}}`)},
+		"errors/error.html": {Data: []byte(
+			`{{define "content"}}{{ .Data.Status }}{{range .Data.Errors}} {{ .Message }}{{end}}{{end}}`)},
+	}
+}
+
+type TemplatesSuite struct {
+	store *memoryStore
+	app   *App
+}
+
+var _ = Suite(new(TemplatesSuite))
+
+func (suite *TemplatesSuite) SetUpTest(c *C) {
+	suite.store = new(memoryStore)
+	suite.store.session = sessions.NewSession(suite.store, "thatiq")
+
+	renderer, err := newRenderer(templates(), "test templates", false, nil)
+	c.Assert(err, IsNil)
+	suite.app = &App{
+		Context:      context.Background(),
+		router:       RouterWithPrefix(""),
+		sessionStore: suite.store,
+		sessionName:  "thatiq",
+		csrf:         csrf.New(suite.store, "thatiq", "secret", "", nil),
+		templates:    renderer,
+	}
+}
+
+func (suite *TemplatesSuite) context(r *http.Request) *Context {
+	return &Context{App: suite.app, Context: r.Context(), Session: suite.store.session}
+}
+
+// TestRender validates that a page is executed within the layouts it
+// extends, with the partials.
+func (suite *TemplatesSuite) TestRender(c *C) {
+	body, err := suite.app.templates.render("account/show", &Page{Title: "Account", Data: "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, `<title>Account</title><nav>account</nav>hello alice`)
+
+	_, err = suite.app.templates.render("account/missing", &Page{})
+	c.Assert(err, ErrorMatches, `template "account/missing" not found in test templates`)
+}
+
+// TestParseErrors validates that the trees with a missing layout or a loop
+// of layouts are rejected.
+func (suite *TemplatesSuite) TestParseErrors(c *C) {
+	fsys := templates()
+	delete(fsys, "base.html")
+	_, err := newRenderer(fsys, "test templates", false, nil)
+	c.Assert(err, ErrorMatches, "templates: base.html not found in test templates")
+
+	fsys = templates()
+	fsys["layouts/account.html"] = &fstest.MapFile{Data: []byte(`{{/* extends "account/show.html" */}}`)}
+	_, err = newRenderer(fsys, "test templates", false, nil)
+	c.Assert(err, ErrorMatches, "templates: .* extends .* in a loop")
+
+	fsys = templates()
+	fsys["account/show.html"] = &fstest.MapFile{Data: []byte(`{{/* extends "layouts/missing.html" */}}`)}
+	_, err = newRenderer(fsys, "test templates", false, nil)
+	c.Assert(err, ErrorMatches, `templates: account/show.html extends unknown template "layouts/missing.html"`)
+}
+
+// TestReload validates that the pages are parsed again when a template
+// changes, and only if reload is set.
+func (suite *TemplatesSuite) TestReload(c *C) {
+	for _, reload := range []bool{false, true} {
+		fsys := templates()
+		renderer, err := newRenderer(fsys, "test templates", reload, nil)
+		c.Assert(err, IsNil)
+
+		fsys["partials/greeting.html"] = &fstest.MapFile{
+			Data:    []byte(`{{define "greeting"}}welcome {{.}}{{end}}`),
+			ModTime: time.Now(),
+		}
+		body, err := renderer.render("account/show", &Page{Data: "alice"})
+		c.Assert(err, IsNil)
+		if reload {
+			c.Assert(string(body), Matches, ".*welcome alice")
+		} else {
+			c.Assert(string(body), Matches, ".*hello alice")
+		}
+	}
+}
+
+// TestAcceptsHTML validates that the browsers get HTML and the API clients
+// JSON.
+func (suite *TemplatesSuite) TestAcceptsHTML(c *C) {
+	for accept, html := range map[string]bool{
+		"":                 false,
+		"*/*":              false,
+		"application/json": false,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": true,
+		"application/json, text/html;q=0.5":                               false,
+		"application/json;q=0.5, text/html":                               true,
+		"TEXT/HTML":                                                       true,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		c.Assert(acceptsHTML(r), Equals, html, Commentf("%q", accept))
+	}
+}
+
+// TestFlashes validates that the flash messages are rendered once, on the
+// next page.
+func (suite *TemplatesSuite) TestFlashes(c *C) {
+	r := httptest.NewRequest("GET", "/", nil)
+	ctx := suite.context(r)
+	ctx.Session.Values[flashSessionKey] = []Flash{
+		{Level: "success", Message: "Saved"},
+		{Level: "error", Message: "But not sent"},
+	}
+
+	w := httptest.NewRecorder()
+	ctx.renderHTML(w, r, http.StatusOK, "account/show", &Page{Title: "Account", Data: "alice"})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(w.Body.String(), Equals,
+		`<title>Account</title>[success: Saved][error: But not sent]<nav>account</nav>hello alice`)
+	c.Assert(suite.store.saved > 0, Equals, true)
+
+	c.Assert(ctx.flashes(w, r), HasLen, 0)
+}
+
+// TestErrorWriter validates that the middleware errors are answered with an
+// HTML page to the browsers and JSON to the API clients.
+func (suite *TemplatesSuite) TestErrorWriter(c *C) {
+	handler := auth.RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Error("the handler must not run")
+	}))
+	serve := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/bookings", nil)
+		r.Header.Set("Accept", accept)
+		r = r.WithContext(errcode.WithErrorWriter(r.Context(), suite.app.writeError))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("text/html")
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(w.Body.String(), Equals,
+		"<title>Unauthorized</title>401 "+errcode.ErrorCodeUnauthorized.Message())
+
+	w = serve("application/json")
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json; charset=utf-8")
+	var body errcode.Errors
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &body), IsNil)
+	c.Assert(body, HasLen, 1)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+
+	scontext "github.com/syaiful6/thatique/context"
+	"github.com/syaiful6/thatique/shop/api/errcode"
+	"github.com/syaiful6/thatique/shop/api/v1"
+	"github.com/syaiful6/thatique/shop/auth"
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// verificationHandler handles the email verification endpoints.
+type verificationHandler struct {
+	*Context
+
+	Token string
+}
+
+func verificationRequestDispatcher(ctx *Context, r *http.Request) http.Handler {
+	vh := &verificationHandler{Context: ctx}
+
+	return handlers.MethodHandler{
+		"POST": auth.RequireLogin(http.HandlerFunc(vh.RequestVerification)),
+	}
+}
+
+func verificationDispatcher(ctx *Context, r *http.Request) http.Handler {
+	vh := &verificationHandler{
+		Context: ctx,
+		Token:   getToken(ctx),
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(vh.Verify),
+	}
+}
+
+// RequestVerification sends a new verification link to the current user.
+func (vh *verificationHandler) RequestVerification(w http.ResponseWriter, r *http.Request) {
+	u := auth.GetUser(r.Context())
+	if u.Verified {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := vh.sendVerification(u); err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Verify marks the user the token was made for as verified.
+func (vh *verificationHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	uid, err := vh.verificationTokens.UserID(vh.Token)
+	if err != nil {
+		vh.Errors = append(vh.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+
+	u, err := vh.users.FindByID(vh, uid)
+	if err == user.ErrNotFound {
+		vh.Errors = append(vh.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+	if err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if err = vh.verificationTokens.Check(u, vh.Token); err != nil {
+		scontext.GetLogger(vh).Infof("rejected verification token for user %s: %v", uid, err)
+		vh.Errors = append(vh.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+
+	// the user is only verified if it wasn't concurrently, this way the
+	// token can be used once.
+	err = vh.users.Verify(vh, u)
+	if err == user.ErrChanged {
+		vh.Errors = append(vh.Errors, v1.ErrorCodeTokenInvalid)
+		return
+	}
+	if err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	serveJSON(w, http.StatusOK, u.Serialize())
+}
+
+// sendVerification mails a verification link to the user u.
+func (ctx *Context) sendVerification(u *user.User) error {
+	link, err := ctx.absoluteURL("auth.verify", "token", ctx.verificationTokens.Make(u))
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Hi,\r\n\r\n"+
+		"Please confirm your email address by opening the link below:\r\n\r\n"+
+		"%s\r\n\r\n"+
+		"If you didn't create an account, you can ignore this email.\r\n", link)
+	ctx.sendMail(ctx, []string{u.Email}, "Verify your email address", message)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+//go:build !windows
+// +build !windows
+
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd, following
+// stdin, stdout and stderr.
+const listenFdsStart = 3
+
+var (
+	activationOnce  sync.Once
+	activationMu    sync.Mutex
+	activationFiles map[string][]*os.File
+	activationOrder []*os.File
+)
+
+// loadActivationFiles collects the sockets passed by systemd through
+// LISTEN_FDS and LISTEN_FDNAMES. The environment is unset afterward so
+// child processes don't take them over too.
+func loadActivationFiles() {
+	activationFiles = make(map[string][]*os.File)
+
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// the sockets are meant for another process.
+		return
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return
+	}
+
+	var names []string
+	if fdnames := os.Getenv("LISTEN_FDNAMES"); fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		activationFiles[name] = append(activationFiles[name], f)
+		activationOrder = append(activationOrder, f)
+	}
+}
+
+// takeActivationFile returns the first unused socket passed by systemd with
+// the given name, or the first unused one if name is empty.
+func takeActivationFile(name string) (*os.File, error) {
+	activationOnce.Do(loadActivationFiles)
+
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	if len(activationOrder) == 0 {
+		return nil, fmt.Errorf("no socket passed by systemd, check LISTEN_FDS")
+	}
+
+	var f *os.File
+	if name == "" {
+		f = activationOrder[0]
+	} else {
+		files := activationFiles[name]
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no socket named %s passed by systemd", name)
+		}
+		f = files[0]
+	}
+
+	activationFiles[f.Name()] = removeFile(activationFiles[f.Name()], f)
+	activationOrder = removeFile(activationOrder, f)
+	return f, nil
+}
+
+func removeFile(files []*os.File, f *os.File) []*os.File {
+	for i := range files {
+		if files[i] == f {
+			return append(files[:i], files[i+1:]...)
+		}
+	}
+	return files
+}
+
+func newSystemdListener(name string) (net.Listener, error) {
+	f, err := takeActivationFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileListener(f)
+}
+
+// newFdListener takes over the listening socket inherited from the parent
+// process as file descriptor laddr.
+func newFdListener(laddr string) (net.Listener, error) {
+	fd, err := strconv.Atoi(laddr)
+	if err != nil || fd < listenFdsStart {
+		return nil, fmt.Errorf("invalid file descriptor %q", laddr)
+	}
+	syscall.CloseOnExec(fd)
+
+	return fileListener(os.NewFile(uintptr(fd), "fd"+laddr))
+}
+
+// fileListener returns a listener from the socket f. f is closed, the
+// listener uses its own duplicate of the file descriptor.
+func fileListener(f *os.File) (net.Listener, error) {
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not a listening socket: %v", f.Fd(), err)
+	}
+
+	if tcpln, ok := ln.(*net.TCPListener); ok {
+		return tcpKeepAliveListener{tcpln}, nil
+	}
+	return ln, nil
+}
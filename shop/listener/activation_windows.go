@@ -0,0 +1,16 @@
+package listener
+
+import (
+	"errors"
+	"net"
+)
+
+var errActivationUnsupported = errors.New("socket activation is not supported on windows")
+
+func newSystemdListener(name string) (net.Listener, error) {
+	return nil, errActivationUnsupported
+}
+
+func newFdListener(laddr string) (net.Listener, error) {
+	return nil, errActivationUnsupported
+}
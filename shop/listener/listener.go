@@ -27,13 +27,20 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 }
 
 // NewListener announces on laddr and net. Accepted values of the net are
-// 'unix' and 'tcp'
+// 'unix' and 'tcp', or 'systemd' and 'fd' to take over an already open
+// socket. For 'systemd' laddr is the name of a socket passed by systemd, an
+// empty one picks the first, and for 'fd' it is the number of a file
+// descriptor inherited from the parent process.
 func NewListener(net, laddr string) (net.Listener, error) {
 	switch net {
 	case "unix":
 		return newUnixListener(laddr)
 	case "tcp", "": // an empty net means tcp
 		return newTCPListener(laddr)
+	case "systemd":
+		return newSystemdListener(laddr)
+	case "fd":
+		return newFdListener(laddr)
 	default:
 		return nil, fmt.Errorf("unknown address type %s", net)
 	}
@@ -0,0 +1,59 @@
+package loghook
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// Factory creates the logrus hook described by the configuration. The levels
+// of the configuration are already parsed.
+type Factory func(conf configuration.LogHook, levels []logrus.Level) (logrus.Hook, error)
+
+var (
+	factories   = make(map[string]Factory)
+	factoriesMu sync.Mutex
+)
+
+// Register makes a hook factory available by the provided type name. If
+// Register is called twice with the same name or if factory is nil, it
+// panics.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("loghook: Register factory is nil")
+	}
+	if _, registered := factories[name]; registered {
+		panic(fmt.Sprintf("loghook: Register called twice for factory %s", name))
+	}
+	factories[name] = factory
+}
+
+// New creates a hook of the type named in the configuration.
+func New(conf configuration.LogHook) (logrus.Hook, error) {
+	factoriesMu.Lock()
+	factory, ok := factories[conf.Type]
+	factoriesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported log hook type: %q", conf.Type)
+	}
+
+	levels := make([]logrus.Level, 0, len(conf.Levels))
+	for _, v := range conf.Levels {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s log hook level: %v", conf.Type, err)
+		}
+		levels = append(levels, level)
+	}
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("%s log hook needs at least one level", conf.Type)
+	}
+
+	return factory(conf, levels)
+}
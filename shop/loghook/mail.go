@@ -0,0 +1,138 @@
+package loghook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syaiful6/thatique/configuration"
+	"github.com/syaiful6/thatique/shop/mail"
+)
+
+const (
+	// defaultMailInterval is the minimum time in between two mails.
+	defaultMailInterval = time.Minute
+
+	// defaultMailBatchSize is the maximum number of entries in a mail.
+	defaultMailBatchSize = 100
+)
+
+func init() {
+	Register("mail", newMailHook)
+}
+
+// mailHook sends the log entries by email. Entries are batched, so at most one
+// mail is sent per interval, and Fire never blocks on the SMTP server.
+type mailHook struct {
+	levels    []logrus.Level
+	mailer    *mail.Mailer
+	to        []string
+	interval  time.Duration
+	batchSize int
+	formatter logrus.Formatter
+	host      string
+
+	mu        sync.Mutex
+	entries   [][]byte
+	dropped   int
+	scheduled bool
+	lastSent  time.Time
+}
+
+func newMailHook(conf configuration.LogHook, levels []logrus.Level) (logrus.Hook, error) {
+	if conf.Mail.SMTP.Addr == "" {
+		return nil, errors.New("mail log hook: options.smtp.addr is required")
+	}
+	if len(conf.Mail.To) == 0 {
+		return nil, errors.New("mail log hook: options.to is required")
+	}
+
+	host, _ := os.Hostname()
+	hook := &mailHook{
+		levels:    levels,
+		mailer:    mail.New(conf.Mail),
+		to:        conf.Mail.To,
+		interval:  conf.Interval,
+		batchSize: conf.BatchSize,
+		formatter: &logrus.TextFormatter{
+			DisableColors:   true,
+			TimestampFormat: time.RFC3339Nano,
+		},
+		host: host,
+	}
+	if hook.interval <= 0 {
+		hook.interval = defaultMailInterval
+	}
+	if hook.batchSize <= 0 {
+		hook.batchSize = defaultMailBatchSize
+	}
+
+	return hook, nil
+}
+
+// Levels implements logrus.Hook.
+func (hook *mailHook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+// Fire implements logrus.Hook. The entry is queued and sent with the next
+// batch.
+func (hook *mailHook) Fire(entry *logrus.Entry) error {
+	line, err := hook.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	if len(hook.entries) < hook.batchSize {
+		hook.entries = append(hook.entries, line)
+	} else {
+		hook.dropped++
+	}
+
+	if !hook.scheduled {
+		hook.scheduled = true
+		delay := hook.interval - time.Since(hook.lastSent)
+		if delay < 0 {
+			delay = 0
+		}
+		time.AfterFunc(delay, hook.flush)
+	}
+
+	return nil
+}
+
+// flush sends the queued entries.
+func (hook *mailHook) flush() {
+	hook.mu.Lock()
+	entries, dropped := hook.entries, hook.dropped
+	hook.entries, hook.dropped = nil, 0
+	hook.scheduled = false
+	hook.lastSent = time.Now()
+	hook.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("[%s] %d log entries", hook.host, len(entries)+dropped)
+	var body bytes.Buffer
+	for _, line := range entries {
+		body.Write(line)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&body, "\n%d more entries were dropped.\n", dropped)
+	}
+
+	// errors can't go through the logger, they may fire this hook again.
+	if err := hook.mailer.SendMail(hook.to, subject, body.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "mail log hook: error sending mail: %v\n", err)
+	}
+}
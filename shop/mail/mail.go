@@ -0,0 +1,88 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// Mailer sends plain text emails through an SMTP server.
+type Mailer struct {
+	Addr, Username, Password, From string
+	Insecure                       bool
+}
+
+// New returns a Mailer configured with the SMTP settings of conf.
+func New(conf configuration.Mail) *Mailer {
+	return &Mailer{
+		Addr:     conf.SMTP.Addr,
+		Username: conf.SMTP.Username,
+		Password: conf.SMTP.Password,
+		Insecure: conf.SMTP.Insecure,
+		From:     conf.From,
+	}
+}
+
+// SendMail sends a plain text message to the given recipients.
+func (mail *Mailer) SendMail(to []string, subject, message string) error {
+	host, _, err := net.SplitHostPort(mail.Addr)
+	if err != nil {
+		return errors.New("invalid Mail Address")
+	}
+	msg := []byte("To: " + strings.Join(to, ", ") +
+		"\r\nFrom: " + mail.From +
+		"\r\nSubject: " + subject +
+		"\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		message)
+
+	c, err := smtp.Dial(mail.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: mail.Insecure,
+		}
+		if err = c.StartTLS(config); err != nil {
+			return err
+		}
+	}
+
+	if mail.Username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			auth := smtp.PlainAuth("", mail.Username, mail.Password, host)
+			if err = c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = c.Mail(mail.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
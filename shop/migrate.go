@@ -0,0 +1,217 @@
+package shop
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/syaiful6/thatique/configuration"
+	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/migrations"
+)
+
+var (
+	migrateConfigPath string
+	migrateDir        string
+)
+
+func init() {
+	migrateCommand.PersistentFlags().StringVarP(&migrateConfigPath, "config", "c", "",
+		"configuration file, defaults to $THATIQ_CONFIGURATION_PATH")
+	migrateCreate.Flags().StringVar(&migrateDir, "dir", filepath.Join("shop", "data", "migrations"),
+		"directory of the migration files")
+
+	migrateCommand.AddCommand(migrateUp, migrateDown, migrateStatus, migrateCreate)
+}
+
+var migrateCommand = &cobra.Command{
+	Use:   "migrate",
+	Short: "Thatiq's database migrations",
+	Long:  "Thatiq's database migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+var migrateUp = &cobra.Command{
+	Use:   "up [version]",
+	Short: "apply the pending migrations",
+	Long:  "apply the pending migrations, up to the given version included or all of them",
+	Args:  cobra.MaximumNArgs(1),
+	Run: runMigrateCommand(func(ctx context.Context, migrator *migrations.Migrator, args []string) error {
+		var target int64
+		if len(args) == 1 {
+			var err error
+			if target, err = strconv.ParseInt(args[0], 10, 64); err != nil || target <= 0 {
+				return fmt.Errorf("invalid version %q", args[0])
+			}
+		}
+
+		done, err := migrator.Up(ctx, target)
+		for _, m := range done {
+			fmt.Printf("applied %d %s\n", m.Version, m.Description)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("no pending migration")
+		}
+		return err
+	}),
+}
+
+var migrateDown = &cobra.Command{
+	Use:   "down [steps]",
+	Short: "revert the last applied migrations",
+	Long:  "revert the given number of applied migrations, the last one by default",
+	Args:  cobra.MaximumNArgs(1),
+	Run: runMigrateCommand(func(ctx context.Context, migrator *migrations.Migrator, args []string) error {
+		steps := 1
+		if len(args) == 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[0])
+			}
+		}
+
+		done, err := migrator.Down(ctx, steps)
+		for _, m := range done {
+			fmt.Printf("reverted %d %s\n", m.Version, m.Description)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("no applied migration")
+		}
+		return err
+	}),
+}
+
+var migrateStatus = &cobra.Command{
+	Use:   "status",
+	Short: "list the migrations and whether they are applied",
+	Long:  "list the migrations and whether they are applied",
+	Args:  cobra.NoArgs,
+	Run: runMigrateCommand(func(ctx context.Context, migrator *migrations.Migrator, args []string) error {
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Up == nil {
+				applied += " (unknown)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Description, applied)
+		}
+		return w.Flush()
+	}),
+}
+
+// migrationName is the allowed name of a new migration, it is part of the
+// file name.
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+var migrateCreate = &cobra.Command{
+	Use:   "create <name>",
+	Short: "write the skeleton of a new migration",
+	Long:  "write the skeleton of a new migration, named with lowercase letters, digits and underscores, to the migrations directory",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if !migrationName.MatchString(name) {
+			fmt.Fprintf(os.Stderr, "invalid migration name %q, use lowercase letters, digits and underscores\n", name)
+			os.Exit(1)
+		}
+
+		version := time.Now().UTC().Format("20060102150405")
+		path := filepath.Join(migrateDir, version+"_"+name+".go")
+		content := fmt.Sprintf(migrationTemplate, version, strings.Replace(name, "_", " ", -1))
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing the migration: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("created %s\n", path)
+	},
+}
+
+const migrationTemplate = `package migrations
+
+import (
+	"context"
+
+	"github.com/globalsign/mgo"
+)
+
+func init() {
+	Register(Migration{
+		Version:     %s,
+		Description: %q,
+		Up: func(ctx context.Context, db *mgo.Database) error {
+			return nil
+		},
+		Down: func(ctx context.Context, db *mgo.Database) error {
+			return nil
+		},
+	})
+}
+`
+
+// runMigrateCommand resolves the configuration, connects to MongoDB and runs
+// f, exiting on errors. The context is canceled on SIGINT and SIGTERM.
+func runMigrateCommand(f func(context.Context, *migrations.Migrator, []string) error) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		var configArgs []string
+		if migrateConfigPath != "" {
+			configArgs = []string{migrateConfigPath}
+		}
+
+		config, err := resolveConfiguration(configArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		mongoConfig := config.MongoDB[configuration.DefaultConnection]
+		conn, err := data.Dial(mongoConfig.URI, mongoConfig.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to mongodb: %v\n", err)
+			os.Exit(1)
+		}
+		defer conn.Session.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		go func() {
+			select {
+			case <-sig:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err = f(ctx, migrations.NewMigrator(conn), args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.CommandPath(), err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package ratelimit limits the rate of events, such as requests, with
+// counters kept in redis so the limits hold across the instances sharing it.
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+const (
+	// SlidingWindow allows Requests events in any Period. The count over the
+	// sliding window is approximated from the counts of the current and the
+	// previous fixed windows.
+	SlidingWindow = "slidingwindow"
+
+	// TokenBucket allows bursts of Burst events, the bucket being refilled
+	// at Requests per Period.
+	TokenBucket = "tokenbucket"
+)
+
+// slidingWindowScript counts an event in the window of KEYS[1], if the
+// weighted count of the current and previous windows allows it.
+// ARGV: now (ms), period (ms), limit. Both windows are kept in a single hash
+// so the script only touches a key, as required by Redis Cluster.
+var slidingWindowScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+local current = math.floor(now / period)
+local elapsed = now - current * period
+local count = tonumber(redis.call('HGET', KEYS[1], tostring(current)) or '0')
+local previous = tonumber(redis.call('HGET', KEYS[1], tostring(current - 1)) or '0')
+local weighted = previous * (period - elapsed) / period + count
+
+if weighted + 1 > limit then
+	local retry = period - elapsed
+	if count + 1 <= limit and previous > 0 then
+		-- wait for enough of the previous window to slide out.
+		retry = math.ceil((1 - (limit - count - 1) / previous) * period - elapsed)
+	end
+	return {0, math.max(0, math.floor(limit - weighted)), retry, period - elapsed}
+end
+
+redis.call('HINCRBY', KEYS[1], tostring(current), 1)
+redis.call('HDEL', KEYS[1], tostring(current - 2))
+redis.call('PEXPIRE', KEYS[1], period * 2)
+return {1, math.max(0, math.floor(limit - weighted - 1)), 0, period - elapsed}
+`)
+
+// tokenBucketScript takes a token from the bucket of KEYS[1], refilled since
+// the last event. ARGV: now (ms), period (ms), requests per period, burst.
+var tokenBucketScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local requests = tonumber(ARGV[3])
+local burst = tonumber(ARGV[4])
+local rate = requests / period
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+local reset = math.ceil((burst - tokens) / rate)
+redis.call('PEXPIRE', KEYS[1], math.max(1, reset))
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// Result is the outcome of an event against a limit.
+type Result struct {
+	// Allowed is set if the event is within the limit.
+	Allowed bool
+
+	// Limit is the number of events allowed in a period, or in a burst.
+	Limit int
+
+	// Remaining is the number of events still allowed now.
+	Remaining int
+
+	// RetryAfter is how long to wait before the next event is allowed, it
+	// is zero if Allowed is set.
+	RetryAfter time.Duration
+
+	// Reset is how long it takes for the limit to be fully available
+	// again, or for the current window to end.
+	Reset time.Duration
+}
+
+// Limiter counts the events in redis.
+type Limiter struct {
+	pool   *redis.Pool
+	prefix string
+	now    func() time.Time
+}
+
+// New returns a Limiter keeping its counters in the redis of pool.
+func New(pool *redis.Pool) *Limiter {
+	return &Limiter{
+		pool:   pool,
+		prefix: "ratelimit:",
+		now:    time.Now,
+	}
+}
+
+// Allow counts an event identified by key against limit. The event isn't
+// counted if it isn't allowed. The keys of the limits using different
+// algorithms must differ.
+func (l *Limiter) Allow(key string, limit configuration.RateLimit) (Result, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	now := l.now().UnixNano() / int64(time.Millisecond)
+	period := int64(limit.Period / time.Millisecond)
+	res := Result{Limit: limit.Requests}
+
+	var (
+		reply []int64
+		err   error
+	)
+	switch limit.Algorithm {
+	case "", SlidingWindow:
+		reply, err = redis.Int64s(slidingWindowScript.Do(conn, l.prefix+key, now, period, limit.Requests))
+	case TokenBucket:
+		burst := limit.Burst
+		if burst == 0 {
+			burst = limit.Requests
+		}
+		res.Limit = burst
+		reply, err = redis.Int64s(tokenBucketScript.Do(conn, l.prefix+key, now, period, limit.Requests, burst))
+	default:
+		return res, fmt.Errorf("ratelimit: unsupported algorithm %q", limit.Algorithm)
+	}
+	if err != nil {
+		return res, err
+	}
+	if len(reply) != 4 {
+		return res, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+
+	res.Allowed = reply[0] == 1
+	res.Remaining = int(reply[1])
+	res.RetryAfter = time.Duration(reply[2]) * time.Millisecond
+	res.Reset = time.Duration(reply[3]) * time.Millisecond
+	return res, nil
+}
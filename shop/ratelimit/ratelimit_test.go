@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	check "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// Hook up gocheck into the "go test" runner. The package isn't dot-imported,
+// its Result would collide with the one of ratelimit.
+func Test(t *testing.T) { check.TestingT(t) }
+
+// addrEnv is the environment variable naming the redis server the scripts
+// are tested against. The tests needing it are skipped when it isn't set.
+const addrEnv = "THATIQ_TEST_REDIS_ADDR"
+
+type LimiterSuite struct {
+	pool    *redis.Pool
+	limiter *Limiter
+	now     time.Time
+}
+
+var _ = check.Suite(new(LimiterSuite))
+
+func (suite *LimiterSuite) SetUpTest(c *check.C) {
+	addr := os.Getenv(addrEnv)
+	suite.pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr)
+		},
+	}
+	suite.limiter = New(suite.pool)
+	suite.limiter.prefix = "ratelimit_test:"
+	suite.limiter.now = func() time.Time { return suite.now }
+	suite.now = time.Unix(10, 0)
+}
+
+func (suite *LimiterSuite) TearDownTest(c *check.C) {
+	suite.pool.Close()
+}
+
+// requireRedis skips the test if no redis server is configured, and removes
+// the counters of key.
+func (suite *LimiterSuite) requireRedis(c *check.C, key string) {
+	if os.Getenv(addrEnv) == "" {
+		c.Skip(addrEnv + " is not set")
+	}
+	conn := suite.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", suite.limiter.prefix+key)
+	c.Assert(err, check.IsNil)
+}
+
+func (suite *LimiterSuite) allow(c *check.C, key string, limit configuration.RateLimit) Result {
+	res, err := suite.limiter.Allow(key, limit)
+	c.Assert(err, check.IsNil)
+	return res
+}
+
+// TestSlidingWindow validates that the count of the previous window slides
+// out as the current one goes on.
+func (suite *LimiterSuite) TestSlidingWindow(c *check.C) {
+	suite.requireRedis(c, "window")
+	limit := configuration.RateLimit{Requests: 3, Period: time.Second}
+
+	for _, remaining := range []int{2, 1, 0} {
+		c.Assert(suite.allow(c, "window", limit), check.Equals, Result{
+			Allowed: true, Limit: 3, Remaining: remaining, Reset: time.Second,
+		})
+	}
+	// the window is full until it ends.
+	c.Assert(suite.allow(c, "window", limit), check.Equals, Result{
+		Limit: 3, RetryAfter: time.Second, Reset: time.Second,
+	})
+
+	// halfway through the next window, half of the previous one counts.
+	suite.now = suite.now.Add(1500 * time.Millisecond)
+	c.Assert(suite.allow(c, "window", limit), check.Equals, Result{
+		Allowed: true, Limit: 3, Remaining: 0, Reset: 500 * time.Millisecond,
+	})
+	// 3 * (1000 - elapsed) / 1000 + 1 events count, the next one is allowed
+	// once elapsed reaches 667ms.
+	c.Assert(suite.allow(c, "window", limit), check.Equals, Result{
+		Limit: 3, RetryAfter: 167 * time.Millisecond, Reset: 500 * time.Millisecond,
+	})
+	suite.now = suite.now.Add(167 * time.Millisecond)
+	c.Assert(suite.allow(c, "window", limit).Allowed, check.Equals, true)
+}
+
+// TestTokenBucket validates that a burst is allowed and that the bucket is
+// refilled at the rate of the limit.
+func (suite *LimiterSuite) TestTokenBucket(c *check.C) {
+	suite.requireRedis(c, "bucket")
+	// a token every 1024ms, so the arithmetic is exact.
+	limit := configuration.RateLimit{Algorithm: TokenBucket, Requests: 1, Period: 1024 * time.Millisecond, Burst: 2}
+
+	c.Assert(suite.allow(c, "bucket", limit), check.Equals, Result{
+		Allowed: true, Limit: 2, Remaining: 1, Reset: 1024 * time.Millisecond,
+	})
+	c.Assert(suite.allow(c, "bucket", limit), check.Equals, Result{
+		Allowed: true, Limit: 2, Remaining: 0, Reset: 2048 * time.Millisecond,
+	})
+	c.Assert(suite.allow(c, "bucket", limit), check.Equals, Result{
+		Limit: 2, RetryAfter: 1024 * time.Millisecond, Reset: 2048 * time.Millisecond,
+	})
+
+	// half a token was refilled.
+	suite.now = suite.now.Add(512 * time.Millisecond)
+	c.Assert(suite.allow(c, "bucket", limit), check.Equals, Result{
+		Limit: 2, RetryAfter: 512 * time.Millisecond, Reset: 1536 * time.Millisecond,
+	})
+	suite.now = suite.now.Add(512 * time.Millisecond)
+	c.Assert(suite.allow(c, "bucket", limit), check.Equals, Result{
+		Allowed: true, Limit: 2, Remaining: 0, Reset: 2048 * time.Millisecond,
+	})
+}
+
+// TestDefaultBurst validates that the burst defaults to the requests of the
+// limit.
+func (suite *LimiterSuite) TestDefaultBurst(c *check.C) {
+	suite.requireRedis(c, "burst")
+	limit := configuration.RateLimit{Algorithm: TokenBucket, Requests: 4, Period: time.Second}
+
+	res := suite.allow(c, "burst", limit)
+	c.Assert(res.Limit, check.Equals, 4)
+	c.Assert(res.Remaining, check.Equals, 3)
+}
+
+// TestUnsupportedAlgorithm validates that an unknown algorithm is an error,
+// rather than allowing every event.
+func (suite *LimiterSuite) TestUnsupportedAlgorithm(c *check.C) {
+	_, err := suite.limiter.Allow("key", configuration.RateLimit{Algorithm: "leakybucket", Requests: 1, Period: time.Second})
+	c.Assert(err, check.ErrorMatches, `ratelimit: unsupported algorithm "leakybucket"`)
+}
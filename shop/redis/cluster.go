@@ -0,0 +1,423 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+const (
+	// numSlots is the number of hash slots of a Redis Cluster.
+	numSlots = 16384
+
+	// maxRedirects is the number of MOVED, ASK and TRYAGAIN replies
+	// followed before a command fails.
+	maxRedirects = 5
+
+	// tryAgainDelay is the delay before retrying a command that got a
+	// TRYAGAIN or CLUSTERDOWN reply, during resharding or failover.
+	tryAgainDelay = 100 * time.Millisecond
+)
+
+// cluster routes the commands to the nodes of a Redis Cluster, each node
+// having its own pool of connections.
+type cluster struct {
+	conf    configuration.Redis
+	options []redis.DialOption
+
+	mu         sync.RWMutex
+	addrs      []string
+	slots      [numSlots]string
+	pools      map[string]*redis.Pool
+	refreshing bool
+}
+
+func newCluster(conf configuration.Redis, options []redis.DialOption) *cluster {
+	return &cluster{
+		conf:    conf,
+		options: options,
+		addrs:   append([]string(nil), conf.Cluster.Addrs...),
+		pools:   make(map[string]*redis.Pool),
+	}
+}
+
+// refresh reads the slots served by the masters from the first node that
+// answers CLUSTER SLOTS.
+func (c *cluster) refresh() error {
+	c.mu.RLock()
+	addrs := append([]string(nil), c.addrs...)
+	c.mu.RUnlock()
+
+	var errs []string
+	for _, addr := range addrs {
+		slots, err := c.clusterSlots(addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+
+		c.mu.Lock()
+		known := make(map[string]bool)
+		for _, addr := range c.addrs {
+			known[addr] = true
+		}
+		for _, s := range slots {
+			for i := s.start; i <= s.end; i++ {
+				c.slots[i] = s.addr
+			}
+			if !known[s.addr] {
+				known[s.addr] = true
+				c.addrs = append(c.addrs, s.addr)
+			}
+		}
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("redis cluster: could not read the slots: %s", strings.Join(errs, "; "))
+}
+
+// refreshLater refreshes the slots in the background, unless a refresh is
+// running already.
+func (c *cluster) refreshLater() {
+	c.mu.Lock()
+	if c.refreshing {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing = true
+	c.mu.Unlock()
+
+	go func() {
+		c.refresh()
+		c.mu.Lock()
+		c.refreshing = false
+		c.mu.Unlock()
+	}()
+}
+
+// slotRange is a range of slots served by the master at addr.
+type slotRange struct {
+	start, end int
+	addr       string
+}
+
+func (c *cluster) clusterSlots(addr string) ([]slotRange, error) {
+	conn := c.pool(addr).Get()
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	slots := make([]slotRange, 0, len(values))
+	for _, value := range values {
+		var (
+			s      slotRange
+			master []interface{}
+		)
+		if _, err = redis.Scan(value.([]interface{}), &s.start, &s.end, &master); err != nil {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: %v", err)
+		}
+		var (
+			masterHost string
+			masterPort int
+		)
+		if _, err = redis.Scan(master, &masterHost, &masterPort); err != nil {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: %v", err)
+		}
+		if masterHost == "" || masterHost == "?" {
+			// the node doesn't know its own address, it is the one
+			// asked.
+			masterHost = host
+		}
+		if s.start < 0 || s.end >= numSlots || s.start > s.end {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: invalid range %d-%d", s.start, s.end)
+		}
+		s.addr = net.JoinHostPort(masterHost, strconv.Itoa(masterPort))
+		slots = append(slots, s)
+	}
+	return slots, nil
+}
+
+// pool returns the pool of connections to the node at addr.
+func (c *cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	pool, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return pool
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool, ok = c.pools[addr]; ok {
+		return pool
+	}
+	pool = &redis.Pool{
+		MaxIdle:     c.conf.MaxIdle,
+		MaxActive:   c.conf.MaxActive,
+		IdleTimeout: c.conf.IdleTimeout,
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			_, err := conn.Do("PING")
+			return err
+		},
+		Dial: func() (redis.Conn, error) {
+			// a cluster only has the database 0.
+			return dial(addr, c.options, c.conf.Password, 0)
+		},
+	}
+	c.pools[addr] = pool
+	return pool
+}
+
+// addr returns the address of the master serving slot, or any node for the
+// commands without key.
+func (c *cluster) addr(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if slot >= 0 && c.slots[slot] != "" {
+		return c.slots[slot]
+	}
+	return c.addrs[0]
+}
+
+// rotate moves the first node to the end, for the commands without key to
+// go to the next one.
+func (c *cluster) rotate() {
+	c.mu.Lock()
+	c.addrs = append(c.addrs[1:], c.addrs[0])
+	c.mu.Unlock()
+}
+
+// do runs the command on the node serving its key, following the
+// redirections.
+func (c *cluster) do(commandName string, args ...interface{}) (interface{}, error) {
+	slot := -1
+	if key, ok := commandKey(commandName, args); ok {
+		slot = hashSlot(key)
+	}
+	addr := c.addr(slot)
+
+	var (
+		asking bool
+		reply  interface{}
+		err    error
+	)
+	for i := 0; i <= maxRedirects; i++ {
+		conn := c.pool(addr).Get()
+		if asking {
+			// the slot is being migrated, the target node only
+			// serves the keys already migrated after ASKING.
+			conn.Send("ASKING")
+		}
+		reply, err = conn.Do(commandName, args...)
+		conn.Close()
+
+		redisErr, ok := err.(redis.Error)
+		if !ok {
+			if err != nil {
+				// the node may have failed over.
+				c.refreshLater()
+				if slot < 0 {
+					c.rotate()
+				}
+			}
+			return reply, err
+		}
+
+		fields := strings.Fields(string(redisErr))
+		switch {
+		case len(fields) == 3 && fields[0] == "MOVED":
+			addr, asking = fields[2], false
+			if slot >= 0 {
+				c.mu.Lock()
+				c.slots[slot] = addr
+				c.mu.Unlock()
+			}
+			c.refreshLater()
+		case len(fields) == 3 && fields[0] == "ASK":
+			addr, asking = fields[2], true
+		case len(fields) > 0 && (fields[0] == "TRYAGAIN" || fields[0] == "CLUSTERDOWN"):
+			time.Sleep(tryAgainDelay)
+		default:
+			return reply, err
+		}
+	}
+	return reply, err
+}
+
+// commandKey returns the key a command is routed by: the first key of
+// EVAL and EVALSHA, the first argument otherwise. ok is false for the
+// commands without key.
+func commandKey(commandName string, args []interface{}) (key string, ok bool) {
+	switch strings.ToUpper(commandName) {
+	case "PING", "ECHO", "INFO", "TIME", "DBSIZE", "CLUSTER", "SCRIPT", "COMMAND", "ROLE":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) < 3 {
+			return "", false
+		}
+		if n, err := strconv.Atoi(argString(args[1])); err != nil || n == 0 {
+			return "", false
+		}
+		return argString(args[2]), true
+	}
+	if len(args) == 0 {
+		return "", false
+	}
+	return argString(args[0]), true
+}
+
+// argString returns the argument as redigo sends it.
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	case redis.Argument:
+		return argString(arg.RedisArg())
+	default:
+		return fmt.Sprint(arg)
+	}
+}
+
+// hashSlot returns the slot of key. Only the hash tag, the part in between
+// the first { and the next }, is hashed if it isn't empty, so that related
+// keys like {user:1}:profile and {user:1}:sessions share a slot.
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % numSlots)
+}
+
+// crc16 is the CRC-16/XMODEM checksum Redis Cluster hashes the keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+var errClusterClosed = errors.New("redis cluster: connection closed")
+
+// clusterConn is the redis.Conn of the pool of a cluster. The commands are
+// run one at a time on the node serving their key, so the pipelined
+// commands are only sent when their reply is received. The transactions and
+// the subscriptions aren't supported, they need a connection to a single
+// node.
+type clusterConn struct {
+	cluster *cluster
+	pending []command
+	closed  bool
+}
+
+type command struct {
+	name string
+	args []interface{}
+}
+
+func (conn *clusterConn) Close() error {
+	conn.closed = true
+	conn.pending = nil
+	return nil
+}
+
+func (conn *clusterConn) Err() error {
+	if conn.closed {
+		return errClusterClosed
+	}
+	return nil
+}
+
+func (conn *clusterConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if conn.closed {
+		return nil, errClusterClosed
+	}
+
+	// like the single connections, Do receives the pending replies and
+	// returns the first error among them.
+	var (
+		reply    interface{}
+		firstErr error
+	)
+	for len(conn.pending) > 0 {
+		var err error
+		if reply, err = conn.Receive(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if commandName == "" {
+		return reply, firstErr
+	}
+
+	if err := supported(commandName); err != nil {
+		return nil, err
+	}
+	reply, err := conn.cluster.do(commandName, args...)
+	if firstErr != nil {
+		return reply, firstErr
+	}
+	return reply, err
+}
+
+func (conn *clusterConn) Send(commandName string, args ...interface{}) error {
+	if conn.closed {
+		return errClusterClosed
+	}
+	if err := supported(commandName); err != nil {
+		return err
+	}
+	conn.pending = append(conn.pending, command{name: commandName, args: args})
+	return nil
+}
+
+func (conn *clusterConn) Flush() error {
+	if conn.closed {
+		return errClusterClosed
+	}
+	return nil
+}
+
+func (conn *clusterConn) Receive() (interface{}, error) {
+	if conn.closed {
+		return nil, errClusterClosed
+	}
+	if len(conn.pending) == 0 {
+		return nil, errors.New("redis cluster: no pending reply")
+	}
+	cmd := conn.pending[0]
+	conn.pending = conn.pending[1:]
+	return conn.cluster.do(cmd.name, cmd.args...)
+}
+
+// supported reports the commands that can't be routed to a single node.
+func supported(commandName string) error {
+	switch strings.ToUpper(commandName) {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH",
+		"SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "SELECT":
+		return fmt.Errorf("redis cluster: %s is not supported", commandName)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type ClusterSuite struct{}
+
+var _ = Suite(new(ClusterSuite))
+
+// TestCRC16 validates the checksum against the reference value of the
+// Redis Cluster specification.
+func (suite *ClusterSuite) TestCRC16(c *C) {
+	c.Assert(crc16("123456789"), Equals, uint16(0x31C3))
+}
+
+// TestHashSlot validates that only the hash tags of the keys are hashed.
+func (suite *ClusterSuite) TestHashSlot(c *C) {
+	c.Assert(hashSlot("foo"), Equals, 12182)
+	c.Assert(hashSlot("{user1000}.following"), Equals, hashSlot("user1000"))
+	c.Assert(hashSlot("{user1000}.followers"), Equals, hashSlot("user1000"))
+	// empty hash tags are part of the key.
+	c.Assert(hashSlot("foo{}{bar}"), Equals, int(crc16("foo{}{bar}")%numSlots))
+	c.Assert(hashSlot("foo{{bar}}zap"), Equals, hashSlot("{bar"))
+}
+
+// TestCommandKey validates that the commands are routed by their key.
+func (suite *ClusterSuite) TestCommandKey(c *C) {
+	key, ok := commandKey("GET", []interface{}{"session:1"})
+	c.Assert(ok, Equals, true)
+	c.Assert(key, Equals, "session:1")
+
+	key, ok = commandKey("evalsha", []interface{}{"sha", 1, []byte("ratelimit:1"), 10})
+	c.Assert(ok, Equals, true)
+	c.Assert(key, Equals, "ratelimit:1")
+
+	_, ok = commandKey("EVAL", []interface{}{"return 1", 0})
+	c.Assert(ok, Equals, false)
+
+	_, ok = commandKey("PING", nil)
+	c.Assert(ok, Equals, false)
+}
+
+// TestUnsupported validates that the commands needing a single node are
+// rejected.
+func (suite *ClusterSuite) TestUnsupported(c *C) {
+	conn := &clusterConn{cluster: newCluster(configuration.Redis{}, nil)}
+	c.Assert(conn.Send("MULTI"), ErrorMatches, "redis cluster: MULTI is not supported")
+	_, err := conn.Do("subscribe", "events")
+	c.Assert(err, ErrorMatches, "redis cluster: subscribe is not supported")
+}
@@ -1,6 +1,10 @@
 package redis
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -8,28 +12,34 @@ import (
 	"github.com/syaiful6/thatique/configuration"
 )
 
+// DialWithConf dials the redis server at conf.Addr, authenticating and
+// selecting the database if asked.
 func DialWithConf(conf configuration.Redis) (redis.Conn, error) {
-	conn, err := redis.DialTimeout("tcp",
-		conf.Addr,
-		conf.DialTimeout,
-		conf.ReadTimeout,
-		conf.WriteTimeout)
+	options, err := dialOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+	return dial(conf.Addr, options, conf.Password, conf.DB)
+}
 
+// dial dials the redis server at addr.
+func dial(addr string, options []redis.DialOption, password string, db int) (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", addr, options...)
 	if err != nil {
 		return nil, err
 	}
 
-	if conf.Password != "" {
+	if password != "" {
 		// do auth
-		if _, err := conn.Do("AUTH", conf.Password); err != nil {
+		if _, err := conn.Do("AUTH", password); err != nil {
 			conn.Close()
 			return nil, err
 		}
 	}
 
 	// select DB if asked
-	if conf.DB != 0 {
-		if _, err = conn.Do("SELECT", conf.DB); err != nil {
+	if db != 0 {
+		if _, err = conn.Do("SELECT", db); err != nil {
 			conn.Close()
 			return nil, err
 		}
@@ -38,8 +48,66 @@ func DialWithConf(conf configuration.Redis) (redis.Conn, error) {
 	return conn, nil
 }
 
-// Create redis Pool
+// dialOptions returns the timeouts and TLS options of the connections to
+// the servers of conf.
+func dialOptions(conf configuration.Redis) ([]redis.DialOption, error) {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(conf.DialTimeout),
+		redis.DialReadTimeout(conf.ReadTimeout),
+		redis.DialWriteTimeout(conf.WriteTimeout),
+	}
+
+	if conf.TLS.Enabled {
+		tlsConfig, err := tlsConfig(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+
+	return options, nil
+}
+
+// tlsConfig loads the certificates of conf.
+func tlsConfig(conf configuration.RedisTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: conf.Insecure,
+	}
+
+	if conf.Certificate != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Certificate, conf.Key)
+		if err != nil {
+			return nil, fmt.Errorf("redis tls: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(conf.RootCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range conf.RootCAs {
+			pem, err := ioutil.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("redis tls: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("redis tls: could not add CA to pool: %s", ca)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// Create redis Pool. The connections go to the single server at conf.Addr, to
+// the master found by Sentinel or to a Redis Cluster, depending on conf.
 func NewRedisPool(conf configuration.Redis) (*redis.Pool, error) {
+	options, err := dialOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	pool := &redis.Pool{
 		MaxIdle:     conf.MaxIdle,
 		MaxActive:   conf.MaxActive,
@@ -49,12 +117,48 @@ func NewRedisPool(conf configuration.Redis) (*redis.Pool, error) {
 			return err
 		},
 		Dial: func() (redis.Conn, error) {
-			return DialWithConf(conf)
+			return dial(conf.Addr, options, conf.Password, conf.DB)
 		},
 	}
 
+	switch {
+	case conf.Sentinel.MasterName != "":
+		s := newSentinel(conf.Sentinel, options)
+		pool.Dial = func() (redis.Conn, error) {
+			addr, err := s.masterAddr()
+			if err != nil {
+				return nil, err
+			}
+			conn, err := dial(addr, options, conf.Password, conf.DB)
+			if err != nil {
+				return nil, err
+			}
+			if err = testRole(conn, "master"); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+		// the connections to a demoted master are dropped, the next
+		// ones are dialed to the new master.
+		pool.TestOnBorrow = func(c redis.Conn, t time.Time) error {
+			return testRole(c, "master")
+		}
+
+	case len(conf.Cluster.Addrs) > 0:
+		c := newCluster(conf, options)
+		if err := c.refresh(); err != nil {
+			return pool, err
+		}
+		pool.Dial = func() (redis.Conn, error) {
+			return &clusterConn{cluster: c}, nil
+		}
+		// the connections to the nodes are tested by their own pools.
+		pool.TestOnBorrow = nil
+	}
+
 	// test the connection
-	_, err := Ping(pool)
+	_, err = Ping(pool)
 	return pool, err
 }
 
@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// sentinel asks the sentinels for the address of the current master.
+type sentinel struct {
+	masterName string
+	password   string
+	options    []redis.DialOption
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func newSentinel(conf configuration.RedisSentinel, options []redis.DialOption) *sentinel {
+	return &sentinel{
+		masterName: conf.MasterName,
+		password:   conf.Password,
+		options:    options,
+		addrs:      append([]string(nil), conf.Addrs...),
+	}
+}
+
+// masterAddr returns the address of the master, as known by the first
+// sentinel that answers. That sentinel is asked first the next time.
+func (s *sentinel) masterAddr() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []string
+	for i, addr := range s.addrs {
+		master, err := s.askMaster(addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		s.addrs[0], s.addrs[i] = s.addrs[i], s.addrs[0]
+		return master, nil
+	}
+	return "", fmt.Errorf("redis sentinel: no sentinel knows the master %s: %s", s.masterName, strings.Join(errs, "; "))
+}
+
+func (s *sentinel) askMaster(addr string) (string, error) {
+	conn, err := dial(addr, s.options, s.password, 0)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.masterName))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("unknown master")
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("unexpected reply %q", res)
+	}
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+// testRole checks that the server c is connected to has the role, master or
+// slave.
+func testRole(c redis.Conn, role string) error {
+	res, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("redis: unexpected ROLE reply")
+	}
+	if actual, _ := redis.String(res[0], nil); actual != role {
+		return fmt.Errorf("redis: the server is a %s, expected a %s", actual, role)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package shop
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bugsnag/bugsnag-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/syaiful6/thatique/configuration"
+	scontext "github.com/syaiful6/thatique/context"
+)
+
+// Reload re-reads the configuration file and applies the parameters that can
+// change while serving: the log level, formatter and fields, the http
+// headers and the bugsnag settings. Nothing is applied if the configuration is
+// invalid or changes a parameter that requires a restart.
+func (shop *Shop) Reload() error {
+	shop.reloadMu.Lock()
+	defer shop.reloadMu.Unlock()
+
+	if shop.configPath == "" {
+		return fmt.Errorf("configuration path unspecified")
+	}
+
+	config, err := resolveConfiguration([]string{shop.configPath})
+	if err != nil {
+		return err
+	}
+	if err = configuration.Validate(config); err != nil {
+		return err
+	}
+
+	if params := restartRequired(shop.config, config); len(params) > 0 {
+		return fmt.Errorf("changes of %s require a restart", strings.Join(params, ", "))
+	}
+
+	level, err := log.ParseLevel(string(config.Log.Level))
+	if err != nil {
+		return err
+	}
+	formatter, err := logFormatter(config)
+	if err != nil {
+		return err
+	}
+
+	changes := configuration.Diff(shop.config, config)
+	if len(changes) == 0 {
+		scontext.GetLogger(shop.app).Info("configuration reloaded, nothing changed")
+		return nil
+	}
+
+	log.SetLevel(level)
+	log.SetFormatter(formatter)
+	shop.app.Reload(config)
+	if config.Reporting.Bugsnag.APIKey != "" {
+		bugsnag.Configure(bugsnagConfiguration(shop.app.Context, config.Reporting.Bugsnag))
+	}
+	shop.config = config
+
+	for _, change := range changes {
+		scontext.GetLogger(shop.app).Infof("configuration reloaded, %v", change)
+	}
+	return nil
+}
+
+// reloadOnSignal reloads the configuration on SIGHUP until stop is closed.
+func (shop *Shop) reloadOnSignal(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			if err := shop.Reload(); err != nil {
+				scontext.GetLogger(shop.app).Errorf("error reloading configuration, keeping the current one: %v", err)
+			}
+		}
+	}
+}
+
+// restartRequired returns the parameters that differ between old and new and
+// can't be reloaded.
+func restartRequired(old, new *configuration.Configuration) []string {
+	// blank out the reloadable parameters on copies, what remains must be
+	// equal.
+	a, b := *old, *new
+	for _, c := range []*configuration.Configuration{&a, &b} {
+		c.Log.Level = ""
+		c.Log.Formatter = ""
+		c.Log.Fields = nil
+		c.HTTP.Headers = nil
+		c.RateLimits = nil
+		c.Reporting.Bugsnag = configuration.BugsnagReporting{}
+	}
+
+	var params []string
+	for _, change := range configuration.Diff(&a, &b) {
+		params = append(params, change.Path)
+	}
+
+	// the bugsnag handler is only installed at startup.
+	if (old.Reporting.Bugsnag.APIKey == "") != (new.Reporting.Bugsnag.APIKey == "") {
+		params = append(params, "reporting.bugsnag.apikey")
+	}
+
+	return params
+}
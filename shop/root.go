@@ -14,6 +14,10 @@ func init() {
 
 	sessionCommand.AddCommand(sessionGenerateKey)
 	RootCmd.AddCommand(sessionCommand)
+
+	RootCmd.AddCommand(userCommand)
+	RootCmd.AddCommand(configCommand)
+	RootCmd.AddCommand(migrateCommand)
 }
 
 // RootCmd is the main command for the 'registry' binary.
@@ -0,0 +1,185 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/securecookie"
+)
+
+// sessionExpire is the default MaxAge of the sessions, 30 days.
+const sessionExpire = 86400 * 30
+
+// RedisStore stores sessions in redis. Only the session ID is kept in the
+// cookie, signed and optionally encrypted with the store's key pairs.
+type RedisStore struct {
+	Pool    *redis.Pool
+	Codecs  []securecookie.Codec
+	Options *Options // default configuration
+
+	// DefaultMaxAge is the redis TTL, in seconds, used when Options.MaxAge
+	// is 0.
+	DefaultMaxAge int
+
+	keyPrefix string
+}
+
+// NewRedisStore returns a new RedisStore backed by pool.
+//
+// Keys are defined in pairs to allow key rotation, but the common case is to
+// set a single authentication key and optionally an encryption key. See
+// securecookie.New for the key requirements.
+func NewRedisStore(pool *redis.Pool, keyPairs ...[]byte) *RedisStore {
+	return &RedisStore{
+		Pool:   pool,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &Options{
+			Path:     "/",
+			MaxAge:   sessionExpire,
+			HttpOnly: true,
+		},
+		DefaultMaxAge: 60 * 20, // 20 minutes seems like a reasonable default
+		keyPrefix:     "session_",
+	}
+}
+
+// SetKeyPrefix sets the prefix used for the redis keys.
+func (s *RedisStore) SetKeyPrefix(p string) {
+	s.keyPrefix = p
+}
+
+// SetMaxAge sets the maximum age for the store and the underlying cookie
+// implementation. Individual sessions can be deleted by setting
+// Options.MaxAge = -1 for that session.
+func (s *RedisStore) SetMaxAge(v int) {
+	s.Options.MaxAge = v
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(v)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *RedisStore) Get(r *http.Request, name string) (*Session, error) {
+	return GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the
+// registry. If the cookie can't be decoded or the session no longer exists
+// in redis, a new empty session is returned.
+func (s *RedisStore) New(r *http.Request, name string) (*Session, error) {
+	session := NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+
+	c, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+
+	err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		session.ID = ""
+		return session, err
+	}
+
+	ok, err := s.load(session)
+	session.IsNew = !(err == nil && ok)
+	if !ok {
+		// the session expired or was deleted on the server.
+		session.ID = ""
+	}
+	return session, err
+}
+
+// Save adds a single session to the response. If Options.MaxAge is negative
+// the session is removed from redis and the cookie expired.
+func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	if session.Options.MaxAge < 0 {
+		if err := s.Delete(session.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// save stores the session in redis, refreshing its expiry.
+func (s *RedisStore) save(session *Session) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return err
+	}
+
+	age := session.Options.MaxAge
+	if age == 0 {
+		age = s.DefaultMaxAge
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+	_, err := conn.Do("SETEX", s.keyPrefix+session.ID, age, buf.Bytes())
+	return err
+}
+
+// load reads the session from redis. It returns false if the session
+// doesn't exist.
+func (s *RedisStore) load(session *Session) (bool, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return false, err
+	}
+
+	data, err := redis.Bytes(conn.Do("GET", s.keyPrefix+session.ID))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	values := make(map[string]interface{})
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return false, errors.New("sessions: corrupted session data")
+	}
+	session.Values = values
+	return true, nil
+}
+
+// Delete removes the session with the given ID from redis, regardless of
+// which client holds it.
+func (s *RedisStore) Delete(id string) error {
+	if id == "" {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", s.keyPrefix+id)
+	return err
+}
@@ -0,0 +1,189 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/securecookie"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+// memoryConn is a redis connection to an in memory map, understanding the
+// commands used by RedisStore.
+type memoryConn struct {
+	data map[string][]byte
+	ttl  map[string]int
+}
+
+func newMemoryConn() *memoryConn {
+	return &memoryConn{
+		data: make(map[string][]byte),
+		ttl:  make(map[string]int),
+	}
+}
+
+func (m *memoryConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch strings.ToUpper(cmd) {
+	case "SETEX":
+		key := args[0].(string)
+		m.ttl[key] = args[1].(int)
+		m.data[key] = args[2].([]byte)
+		return "OK", nil
+	case "GET":
+		data, ok := m.data[args[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return data, nil
+	case "DEL":
+		key := args[0].(string)
+		if _, ok := m.data[key]; !ok {
+			return int64(0), nil
+		}
+		delete(m.data, key)
+		delete(m.ttl, key)
+		return int64(1), nil
+	}
+	return nil, errors.New("unexpected command " + cmd)
+}
+
+func (m *memoryConn) Send(cmd string, args ...interface{}) error { return nil }
+func (m *memoryConn) Flush() error                               { return nil }
+func (m *memoryConn) Receive() (interface{}, error)              { return nil, nil }
+func (m *memoryConn) Close() error                               { return nil }
+func (m *memoryConn) Err() error                                 { return nil }
+
+var hashKey = []byte("0123456789abcdef0123456789abcdef")
+
+type RedisStoreSuite struct {
+	conn  *memoryConn
+	store *RedisStore
+}
+
+var _ = Suite(new(RedisStoreSuite))
+
+func (suite *RedisStoreSuite) SetUpTest(c *C) {
+	suite.conn = newMemoryConn()
+	suite.store = NewRedisStore(&redis.Pool{
+		Dial: func() (redis.Conn, error) { return suite.conn, nil },
+	}, hashKey)
+	suite.store.SetKeyPrefix("test_")
+}
+
+// save stores a new session holding the given user and returns the cookie
+// sent to the client.
+func (suite *RedisStoreSuite) save(c *C, user string) (*Session, *http.Cookie) {
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := suite.store.New(r, "thatiq_session")
+	c.Assert(err, IsNil)
+	c.Assert(session.IsNew, Equals, true)
+
+	session.Values["user"] = user
+	w := httptest.NewRecorder()
+	c.Assert(session.Save(r, w), IsNil)
+
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, HasLen, 1)
+	return session, cookies[0]
+}
+
+// TestSave validates that the values are stored under the prefixed ID with
+// the max age as TTL, and that the cookie holds the signed ID.
+func (suite *RedisStoreSuite) TestSave(c *C) {
+	session, cookie := suite.save(c, "alice")
+
+	c.Assert(session.ID, Not(Equals), "")
+	c.Assert(suite.conn.data, HasLen, 1)
+	c.Assert(suite.conn.data["test_"+session.ID], NotNil)
+	c.Assert(suite.conn.ttl["test_"+session.ID], Equals, sessionExpire)
+
+	c.Assert(cookie.Name, Equals, "thatiq_session")
+	c.Assert(cookie.Value, Not(Equals), session.ID)
+	c.Assert(cookie.HttpOnly, Equals, true)
+	var id string
+	c.Assert(securecookie.DecodeMulti(cookie.Name, cookie.Value, &id, suite.store.Codecs...), IsNil)
+	c.Assert(id, Equals, session.ID)
+}
+
+// TestDefaultMaxAge validates that DefaultMaxAge is the TTL of the sessions
+// without max age.
+func (suite *RedisStoreSuite) TestDefaultMaxAge(c *C) {
+	suite.store.Options.MaxAge = 0
+	session, _ := suite.save(c, "alice")
+	c.Assert(suite.conn.ttl["test_"+session.ID], Equals, suite.store.DefaultMaxAge)
+}
+
+// TestLoad validates that the session of the cookie is read back.
+func (suite *RedisStoreSuite) TestLoad(c *C) {
+	saved, cookie := suite.save(c, "alice")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, err := suite.store.New(r, "thatiq_session")
+	c.Assert(err, IsNil)
+	c.Assert(session.IsNew, Equals, false)
+	c.Assert(session.ID, Equals, saved.ID)
+	c.Assert(session.Values["user"], Equals, "alice")
+}
+
+// TestLoadForged validates that a cookie which isn't signed with the key of
+// the store is rejected.
+func (suite *RedisStoreSuite) TestLoadForged(c *C) {
+	saved, _ := suite.save(c, "alice")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "thatiq_session", Value: saved.ID})
+	session, err := suite.store.New(r, "thatiq_session")
+	c.Assert(err, NotNil)
+	c.Assert(session.IsNew, Equals, true)
+	c.Assert(session.ID, Equals, "")
+	c.Assert(session.Values, HasLen, 0)
+}
+
+// TestLoadExpired validates that a new session is returned when the session
+// of the cookie is gone from redis.
+func (suite *RedisStoreSuite) TestLoadExpired(c *C) {
+	saved, cookie := suite.save(c, "alice")
+	delete(suite.conn.data, "test_"+saved.ID)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	session, err := suite.store.New(r, "thatiq_session")
+	c.Assert(err, IsNil)
+	c.Assert(session.IsNew, Equals, true)
+	c.Assert(session.ID, Equals, "")
+	c.Assert(session.Values, HasLen, 0)
+}
+
+// TestDelete validates that deleting a session removes it from redis and
+// expires the cookie.
+func (suite *RedisStoreSuite) TestDelete(c *C) {
+	session, _ := suite.save(c, "alice")
+
+	w := httptest.NewRecorder()
+	c.Assert(session.Delete(httptest.NewRequest("GET", "/", nil), w), IsNil)
+	c.Assert(suite.conn.data, HasLen, 0)
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, HasLen, 1)
+	c.Assert(cookies[0].Value, Equals, "")
+	c.Assert(cookies[0].MaxAge < 0, Equals, true)
+}
+
+// TestRegenerate validates that the values move to a new ID, the old one
+// being removed.
+func (suite *RedisStoreSuite) TestRegenerate(c *C) {
+	session, _ := suite.save(c, "alice")
+	old := session.ID
+
+	c.Assert(session.Regenerate(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder()), IsNil)
+	c.Assert(session.ID, Not(Equals), old)
+	c.Assert(suite.conn.data, HasLen, 1)
+	c.Assert(suite.conn.data["test_"+session.ID], NotNil)
+}
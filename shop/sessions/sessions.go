@@ -0,0 +1,234 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Options stores configuration for a session or session store. Fields are a
+// subset of http.Cookie fields.
+type Options struct {
+	Path   string
+	Domain string
+	// MaxAge=0 means no 'Max-Age' attribute specified.
+	// MaxAge<0 means delete cookie now, equivalently 'Max-Age: 0'.
+	// MaxAge>0 means Max-Age attribute present and given in seconds.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// Session stores the values and optional configuration for a session.
+type Session struct {
+	// ID is the identifier of the session in the server side storage. It is
+	// empty until the session is saved for the first time.
+	ID string
+
+	// Values contains the user-data for the session.
+	Values map[string]interface{}
+
+	Options *Options
+
+	// IsNew is true if the session was not loaded from the store.
+	IsNew bool
+
+	store Store
+	name  string
+}
+
+// NewSession is called by session stores to create a new session instance.
+func NewSession(store Store, name string) *Session {
+	return &Session{
+		Values:  make(map[string]interface{}),
+		Options: new(Options),
+		IsNew:   true,
+		store:   store,
+		name:    name,
+	}
+}
+
+// Name returns the name used to register the session.
+func (s *Session) Name() string {
+	return s.name
+}
+
+// Store returns the session store used to register the session.
+func (s *Session) Store() Store {
+	return s.store
+}
+
+// Save is a convenience method to save this session. It is the same as
+// calling store.Save(request, response, session).
+func (s *Session) Save(r *http.Request, w http.ResponseWriter) error {
+	return s.store.Save(r, w, s)
+}
+
+// Delete removes the session from the store and expires the cookie, after
+// this the session can't be used anymore by any client.
+func (s *Session) Delete(r *http.Request, w http.ResponseWriter) error {
+	s.Options.MaxAge = -1
+	for k := range s.Values {
+		delete(s.Values, k)
+	}
+	return s.store.Save(r, w, s)
+}
+
+// Regenerate moves the session values to a new ID and removes the old one
+// from the store. It should be called whenever the privilege level of the
+// session changes, such as on login, to prevent session fixation.
+func (s *Session) Regenerate(r *http.Request, w http.ResponseWriter) error {
+	if s.ID != "" {
+		if err := s.store.Delete(s.ID); err != nil {
+			return err
+		}
+		s.ID = ""
+	}
+	return s.store.Save(r, w, s)
+}
+
+// Store is an interface for custom session stores.
+type Store interface {
+	// Get should return a cached session.
+	Get(r *http.Request, name string) (*Session, error)
+
+	// New should create and return a new session.
+	//
+	// Note that New should never return a nil session, even in the case of
+	// an error if using the Registry infrastructure to cache the session.
+	New(r *http.Request, name string) (*Session, error)
+
+	// Save should persist session to the underlying store implementation.
+	Save(r *http.Request, w http.ResponseWriter, s *Session) error
+
+	// Delete should remove the session with the given ID from the underlying
+	// store implementation, invalidating it for every client holding it.
+	Delete(id string) error
+}
+
+// NewCookie returns an http.Cookie with the options set. It also sets the
+// Expires field calculated based on the MaxAge value, for Internet Explorer
+// compatibility.
+func NewCookie(name, value string, options *Options) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+		SameSite: options.SameSite,
+	}
+	if options.MaxAge > 0 {
+		d := time.Duration(options.MaxAge) * time.Second
+		cookie.Expires = time.Now().Add(d)
+	} else if options.MaxAge < 0 {
+		// Set it to the past to expire now.
+		cookie.Expires = time.Unix(1, 0)
+	}
+	return cookie
+}
+
+type sessionInfo struct {
+	s *Session
+	e error
+}
+
+// Registry stores sessions used during a request, so every call to Get with
+// the same name returns the same session.
+type Registry struct {
+	request  *http.Request
+	sessions map[string]sessionInfo
+}
+
+type registryKey struct{}
+
+func (registryKey) String() string { return "sessions.registry" }
+
+// WithRegistry returns a context carrying a fresh session registry for the
+// request r.
+func WithRegistry(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, registryKey{}, &Registry{
+		request:  r,
+		sessions: make(map[string]sessionInfo),
+	})
+}
+
+// GetRegistry returns the registry attached to the request. If the request's
+// context doesn't carry one, a registry that only lives as long as the
+// returned value is created.
+func GetRegistry(r *http.Request) *Registry {
+	if registry, ok := r.Context().Value(registryKey{}).(*Registry); ok {
+		return registry
+	}
+
+	return &Registry{
+		request:  r,
+		sessions: make(map[string]sessionInfo),
+	}
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (s *Registry) Get(store Store, name string) (session *Session, err error) {
+	if info, ok := s.sessions[name]; ok {
+		session, err = info.s, info.e
+	} else {
+		session, err = store.New(s.request, name)
+		session.name = name
+		s.sessions[name] = sessionInfo{s: session, e: err}
+	}
+	session.store = store
+	return
+}
+
+// Save saves all sessions registered for the current request.
+func (s *Registry) Save(w http.ResponseWriter) error {
+	var errMulti MultiError
+	for name, info := range s.sessions {
+		session := info.s
+		if session.store == nil {
+			errMulti = append(errMulti, fmt.Errorf(
+				"sessions: missing store for session %q", name))
+		} else if err := session.store.Save(s.request, w, session); err != nil {
+			errMulti = append(errMulti, fmt.Errorf(
+				"sessions: error saving session %q -- %v", name, err))
+		}
+	}
+	if errMulti != nil {
+		return errMulti
+	}
+	return nil
+}
+
+// Save saves all sessions used during the current request.
+func Save(r *http.Request, w http.ResponseWriter) error {
+	return GetRegistry(r).Save(w)
+}
+
+// MultiError stores multiple errors.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	s, n := "", 0
+	for _, e := range m {
+		if e != nil {
+			if n == 0 {
+				s = e.Error()
+			}
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		return "(0 errors)"
+	case 1:
+		return s
+	case 2:
+		return s + " (and 1 other error)"
+	}
+	return fmt.Sprintf("%s (and %d other errors)", s, n-1)
+}
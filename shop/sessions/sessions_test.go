@@ -0,0 +1,96 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+// countingStore creates empty sessions, counting the calls to New and Save.
+type countingStore struct {
+	created int
+	saved   int
+	err     error
+}
+
+func (s *countingStore) Get(r *http.Request, name string) (*Session, error) {
+	return GetRegistry(r).Get(s, name)
+}
+
+func (s *countingStore) New(r *http.Request, name string) (*Session, error) {
+	s.created++
+	return NewSession(s, name), nil
+}
+
+func (s *countingStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	s.saved++
+	return s.err
+}
+
+func (s *countingStore) Delete(id string) error {
+	return nil
+}
+
+type RegistrySuite struct {
+	store   *countingStore
+	request *http.Request
+}
+
+var _ = Suite(new(RegistrySuite))
+
+func (suite *RegistrySuite) SetUpTest(c *C) {
+	suite.store = new(countingStore)
+	r := httptest.NewRequest("GET", "/", nil)
+	suite.request = r.WithContext(WithRegistry(r.Context(), r))
+}
+
+// TestGet validates that a session is created once per name and request.
+func (suite *RegistrySuite) TestGet(c *C) {
+	first, err := suite.store.Get(suite.request, "a")
+	c.Assert(err, IsNil)
+	first.Values["user"] = "alice"
+
+	again, err := suite.store.Get(suite.request, "a")
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, first)
+	c.Assert(again.Values["user"], Equals, "alice")
+
+	other, err := suite.store.Get(suite.request, "b")
+	c.Assert(err, IsNil)
+	c.Assert(other, Not(Equals), first)
+	c.Assert(other.Name(), Equals, "b")
+	c.Assert(suite.store.created, Equals, 2)
+}
+
+// TestWithoutRegistry validates that the sessions aren't shared when the
+// request doesn't carry a registry.
+func (suite *RegistrySuite) TestWithoutRegistry(c *C) {
+	r := httptest.NewRequest("GET", "/", nil)
+	first, _ := suite.store.Get(r, "a")
+	again, _ := suite.store.Get(r, "a")
+	c.Assert(again, Not(Equals), first)
+	c.Assert(suite.store.created, Equals, 2)
+}
+
+// TestSave validates that every session of the request is saved.
+func (suite *RegistrySuite) TestSave(c *C) {
+	suite.store.Get(suite.request, "a")
+	suite.store.Get(suite.request, "b")
+
+	c.Assert(Save(suite.request, httptest.NewRecorder()), IsNil)
+	c.Assert(suite.store.saved, Equals, 2)
+}
+
+// TestSaveErrors validates that the errors of every session are reported.
+func (suite *RegistrySuite) TestSaveErrors(c *C) {
+	suite.store.err = errors.New("redis is down")
+	suite.store.Get(suite.request, "a")
+	suite.store.Get(suite.request, "b")
+
+	err := Save(suite.request, httptest.NewRecorder())
+	c.Assert(err, FitsTypeOf, MultiError{})
+	c.Assert(err.(MultiError), HasLen, 2)
+	c.Assert(err, ErrorMatches, `sessions: error saving session "[ab]" -- redis is down \(and 1 other error\)`)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 	scontext "github.com/syaiful6/thatique/context"
 	"github.com/syaiful6/thatique/shop/handlers"
 	"github.com/syaiful6/thatique/shop/listener"
+	"github.com/syaiful6/thatique/shop/loghook"
 	"github.com/syaiful6/thatique/uuid"
 	"github.com/syaiful6/thatique/version"
 )
@@ -35,7 +37,6 @@ var ServeCmd = &cobra.Command{
 		ctx := scontext.WithVersion(scontext.Background(), version.Version)
 
 		config, err := resolveConfiguration(args)
-
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 			cmd.Usage()
@@ -46,6 +47,7 @@ var ServeCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalln(err)
 		}
+		shop.configPath = configurationPath(args)
 
 		if err = shop.ListenAndServe(); err != nil {
 			log.Fatalln(err)
@@ -54,9 +56,15 @@ var ServeCmd = &cobra.Command{
 }
 
 type Shop struct {
+	// config is the configuration as parsed, without the secrets NewApp
+	// generates when they are missing. Reloads are compared against it.
 	config *configuration.Configuration
 	server *http.Server
 	app    *handlers.App
+
+	// configPath is the file the configuration is reloaded from on SIGHUP.
+	configPath string
+	reloadMu   sync.Mutex
 }
 
 func NewShop(ctx context.Context, config *configuration.Configuration) (*Shop, error) {
@@ -69,11 +77,14 @@ func NewShop(ctx context.Context, config *configuration.Configuration) (*Shop, e
 	// with uuid generation under low entropy.
 	uuid.Loggerf = scontext.GetLogger(ctx).Warnf
 
+	parsed := *config
 	app, err := handlers.NewApp(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("error creting handlers app: %v", err)
 	}
 
+	app.RegisterHealthChecks()
+
 	handler := panicHandler(configureReporting(app))
 
 	if !config.Log.AccessLog.Disabled {
@@ -86,7 +97,7 @@ func NewShop(ctx context.Context, config *configuration.Configuration) (*Shop, e
 
 	return &Shop{
 		app:    app,
-		config: config,
+		config: &parsed,
 		server: server,
 	}, nil
 }
@@ -94,19 +105,46 @@ func NewShop(ctx context.Context, config *configuration.Configuration) (*Shop, e
 // ListenAndServe runs the shope's HTTP server.
 func (shop *Shop) ListenAndServe() error {
 	config := shop.config
+	defer shop.app.Shutdown()
 
 	ln, err := listener.NewListener(config.HTTP.Net, config.HTTP.Addr)
 	if err != nil {
 		return err
 	}
 
+	if config.HTTP.TLS.Certificate != "" {
+		reloader, err := newTLSReloader(config.HTTP.TLS)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		shop.server.TLSConfig = reloader.TLSConfig()
+
+		tlsStop := make(chan struct{})
+		defer close(tlsStop)
+		go reloader.watch(tlsStop)
+
+		scontext.GetLogger(shop.app).Infof("listening on %v, tls", ln.Addr())
+	} else {
+		scontext.GetLogger(shop.app).Infof("listening on %v", ln.Addr())
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go shop.reloadOnSignal(stop)
+
 	// setup channel to get notified on SIGTERM signal
 	signal.Notify(quit, syscall.SIGTERM)
 	serveErr := make(chan error)
 
 	// Start serving in goroutine and listen for stop signal in main thread
 	go func() {
-		serveErr <- shop.server.Serve(ln)
+		if shop.server.TLSConfig != nil {
+			// the certificate comes from the TLSConfig.
+			serveErr <- shop.server.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- shop.server.Serve(ln)
+		}
 	}()
 
 	select {
@@ -140,20 +178,7 @@ func configureReporting(app *handlers.App) http.Handler {
 	var handler http.Handler = app
 
 	if app.Config.Reporting.Bugsnag.APIKey != "" {
-		bugsnagConfig := bugsnag.Configuration{
-			APIKey: app.Config.Reporting.Bugsnag.APIKey,
-		}
-		ver := scontext.GetVersion(app.Context)
-		if ver != "" {
-			bugsnagConfig.AppVersion = ver
-		}
-		if app.Config.Reporting.Bugsnag.ReleaseStage != "" {
-			bugsnagConfig.ReleaseStage = app.Config.Reporting.Bugsnag.ReleaseStage
-		}
-		if app.Config.Reporting.Bugsnag.Endpoint != "" {
-			bugsnagConfig.Endpoint = app.Config.Reporting.Bugsnag.Endpoint
-		}
-		bugsnag.Configure(bugsnagConfig)
+		bugsnag.Configure(bugsnagConfiguration(app.Context, app.Config.Reporting.Bugsnag))
 
 		handler = bugsnag.Handler(handler)
 	}
@@ -161,52 +186,109 @@ func configureReporting(app *handlers.App) http.Handler {
 	return handler
 }
 
+func bugsnagConfiguration(ctx context.Context, conf configuration.BugsnagReporting) bugsnag.Configuration {
+	bugsnagConfig := bugsnag.Configuration{
+		APIKey: conf.APIKey,
+	}
+	ver := scontext.GetVersion(ctx)
+	if ver != "" {
+		bugsnagConfig.AppVersion = ver
+	}
+	if conf.ReleaseStage != "" {
+		bugsnagConfig.ReleaseStage = conf.ReleaseStage
+	}
+	if conf.Endpoint != "" {
+		bugsnagConfig.Endpoint = conf.Endpoint
+	}
+	return bugsnagConfig
+}
+
 // configureLogging prepares the context with a logger using the
 // configuration.
 func configureLogging(ctx context.Context, config *configuration.Configuration) (context.Context, error) {
+	formatter, err := logFormatter(config)
+	if err != nil {
+		return ctx, err
+	}
+
 	log.SetLevel(logLevel(config.Log.Level))
+	log.SetFormatter(formatter)
+
+	if config.Log.Formatter != "" {
+		log.Debugf("using %q logging formatter", config.Log.Formatter)
+	}
+
+	for _, hookConfig := range config.Log.Hooks {
+		if hookConfig.Disabled {
+			continue
+		}
 
+		hook, err := loghook.New(hookConfig)
+		if err != nil {
+			return ctx, fmt.Errorf("error configuring log hook: %v", err)
+		}
+		log.AddHook(hook)
+		log.Debugf("using %q log hook for levels %v", hookConfig.Type, hookConfig.Levels)
+	}
+
+	return ctx, nil
+}
+
+// logFormatter returns the configured formatter. The static fields are added
+// by the formatter, so both change at once when the configuration is
+// reloaded.
+func logFormatter(config *configuration.Configuration) (log.Formatter, error) {
 	formatter := config.Log.Formatter
 	if formatter == "" {
 		formatter = "text" // default formatter
 	}
 
+	var f log.Formatter
 	switch formatter {
 	case "json":
-		log.SetFormatter(&log.JSONFormatter{
+		f = &log.JSONFormatter{
 			TimestampFormat: time.RFC3339Nano,
-		})
+		}
 	case "text":
-		log.SetFormatter(&log.TextFormatter{
+		f = &log.TextFormatter{
 			TimestampFormat: time.RFC3339Nano,
-		})
+		}
 	case "logstash":
-		log.SetFormatter(&logstash.LogstashFormatter{
+		f = &logstash.LogstashFormatter{
 			TimestampFormat: time.RFC3339Nano,
-		})
-	default:
-		// just let the library use default on empty string.
-		if config.Log.Formatter != "" {
-			return ctx, fmt.Errorf("unsupported logging formatter: %q", config.Log.Formatter)
 		}
+	default:
+		return nil, fmt.Errorf("unsupported logging formatter: %q", config.Log.Formatter)
 	}
 
-	if config.Log.Formatter != "" {
-		log.Debugf("using %q logging formatter", config.Log.Formatter)
+	if len(config.Log.Fields) > 0 {
+		f = &fieldsFormatter{Formatter: f, fields: config.Log.Fields}
 	}
 
-	if len(config.Log.Fields) > 0 {
-		// build up the static fields, if present.
-		var fields []interface{}
-		for k := range config.Log.Fields {
-			fields = append(fields, k)
-		}
+	return f, nil
+}
+
+// fieldsFormatter adds static fields to the entries before formatting them.
+// The fields of the entry take precedence.
+type fieldsFormatter struct {
+	log.Formatter
+	fields map[string]interface{}
+}
 
-		ctx = scontext.WithValues(ctx, config.Log.Fields)
-		ctx = scontext.WithLogger(ctx, scontext.GetLogger(ctx, fields...))
+func (f *fieldsFormatter) Format(entry *log.Entry) ([]byte, error) {
+	// the data of the entry may be shared with other goroutines, work on a
+	// copy.
+	data := make(log.Fields, len(f.fields)+len(entry.Data))
+	for k, v := range f.fields {
+		data[k] = v
+	}
+	for k, v := range entry.Data {
+		data[k] = v
 	}
 
-	return ctx, nil
+	e := *entry
+	e.Data = data
+	return f.Formatter.Format(&e)
 }
 
 func logLevel(level configuration.Loglevel) log.Level {
@@ -219,27 +301,22 @@ func logLevel(level configuration.Loglevel) log.Level {
 	return l
 }
 
-func resolveConfiguration(args []string) (*configuration.Configuration, error) {
-	var configurationPath string
-
+// configurationPath returns the configuration file given in args, or by
+// $THATIQ_CONFIGURATION_PATH.
+func configurationPath(args []string) string {
 	if len(args) > 0 {
-		configurationPath = args[0]
-	} else if os.Getenv("THATIQ_CONFIGURATION_PATH") != "" {
-		configurationPath = os.Getenv("THATIQ_CONFIGURATION_PATH")
+		return args[0]
 	}
+	return os.Getenv("THATIQ_CONFIGURATION_PATH")
+}
 
+func resolveConfiguration(args []string) (*configuration.Configuration, error) {
+	configurationPath := configurationPath(args)
 	if configurationPath == "" {
 		return nil, fmt.Errorf("configuration path unspecified")
 	}
 
-	fp, err := os.Open(configurationPath)
-	if err != nil {
-		return nil, err
-	}
-
-	defer fp.Close()
-
-	config, err := configuration.Parse(fp)
+	config, err := configuration.ParseFile(configurationPath)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing %s: %v", configurationPath, err)
 	}
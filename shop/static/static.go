@@ -0,0 +1,331 @@
+// Package static serves the static assets of the shop, such as the
+// stylesheets and the images. Each asset is also served under a name carrying
+// the hash of its content, css/application.0123456789abcdef.css, which can be
+// cached forever: a new version of the asset gets a new name.
+//
+// An asset can be precompressed, the variants named after it with the .br or
+// .gz extension are served to the clients accepting them.
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// hashSize is the number of hex digits of the hashes in the names.
+	hashSize = 16
+
+	// immutableCacheControl is sent with the hashed names, whose content
+	// never changes.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+
+	// revalidateCacheControl is sent with the plain names, the clients must
+	// check that their copy is still current.
+	revalidateCacheControl = "public, no-cache"
+)
+
+// encodings are the precompressed variants served, by order of preference.
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// asset is a file served.
+type asset struct {
+	// name is the slash separated path of the asset, relative to the root.
+	name string
+
+	// hashed is name with the hash of the content before the extension.
+	hashed string
+
+	hash    string
+	modTime time.Time
+
+	// encodings holds the precompressed variants available.
+	encodings map[string]bool
+}
+
+// Assets serves the files of a file system. The files are hashed when Assets
+// is created, or when they change if reload is set.
+type Assets struct {
+	fsys   fs.FS
+	prefix string
+	reload bool
+
+	mu       sync.RWMutex
+	byName   map[string]*asset
+	byHashed map[string]*asset
+	stamp    string
+}
+
+// New returns Assets serving the files of fsys under the URL path prefix,
+// e.g. "/static/". If reload is set, the files are hashed again when they
+// change, for development.
+func New(fsys fs.FS, prefix string, reload bool) (*Assets, error) {
+	a := &Assets{
+		fsys:   fsys,
+		prefix: strings.TrimSuffix(prefix, "/") + "/",
+		reload: reload,
+	}
+	if err := a.scan(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Prefix returns the URL path prefix the assets are served under.
+func (a *Assets) Prefix() string {
+	return a.prefix
+}
+
+// URL returns the URL path of the named asset, under its hashed name. The
+// plain name is used if the asset doesn't exist.
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if a.reload {
+		// a failure is reported when the asset is requested.
+		a.rescan()
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if asset, ok := a.byName[name]; ok {
+		return a.prefix + asset.hashed
+	}
+	return a.prefix + name
+}
+
+// ServeHTTP serves the asset at the request path, relative to the prefix.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if a.reload {
+		if err := a.rescan(); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, a.prefix)
+	a.mu.RLock()
+	asset, hashed := a.byHashed[name]
+	if !hashed {
+		asset = a.byName[name]
+	}
+	a.mu.RUnlock()
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, etag := asset.name, `"`+asset.hash+`"`
+	if len(asset.encodings) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+		for _, enc := range encodings {
+			if asset.encodings[enc.name] && accepted[enc.name] {
+				file, etag = asset.name+enc.ext, `"`+asset.hash+"-"+enc.name+`"`
+				w.Header().Set("Content-Encoding", enc.name)
+				break
+			}
+		}
+	}
+
+	content, err := a.open(file)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(asset.name)); contentType != "" {
+		// the precompressed variants can't be sniffed.
+		w.Header().Set("Content-Type", contentType)
+	}
+	if hashed {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", revalidateCacheControl)
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, asset.name, asset.modTime, content)
+}
+
+// open returns the content of the named file, seekable.
+func (a *Assets) open(name string) (io.ReadSeeker, error) {
+	f, err := a.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// rescan hashes the files again if they changed since the last scan.
+func (a *Assets) rescan() error {
+	stamp, err := a.stampFiles()
+	if err != nil {
+		return err
+	}
+	a.mu.RLock()
+	changed := stamp != a.stamp
+	a.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	return a.scan()
+}
+
+// scan hashes the files, the precompressed variants being attached to the
+// file they are named after.
+func (a *Assets) scan() error {
+	stamp, err := a.stampFiles()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]fs.FileInfo)
+	err = a.walk(func(name string, fi fs.FileInfo) error {
+		files[name] = fi
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*asset)
+	byHashed := make(map[string]*asset)
+	for name, fi := range files {
+		if variantOf(files, name) != "" {
+			continue
+		}
+
+		content, err := fs.ReadFile(a.fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])[:hashSize]
+		asset := &asset{
+			name:      name,
+			hashed:    hashedName(name, hash),
+			hash:      hash,
+			modTime:   fi.ModTime(),
+			encodings: make(map[string]bool),
+		}
+		for _, enc := range encodings {
+			if _, ok := files[name+enc.ext]; ok {
+				asset.encodings[enc.name] = true
+			}
+		}
+		byName[name] = asset
+		byHashed[asset.hashed] = asset
+	}
+
+	a.mu.Lock()
+	a.byName, a.byHashed, a.stamp = byName, byHashed, stamp
+	a.mu.Unlock()
+	return nil
+}
+
+// stampFiles returns the modification times and sizes of the files, which
+// change when a file is edited, added or removed.
+func (a *Assets) stampFiles() (string, error) {
+	var buf bytes.Buffer
+	err := a.walk(func(name string, fi fs.FileInfo) error {
+		fmt.Fprintf(&buf, "%s:%d:%d;", name, fi.ModTime().UnixNano(), fi.Size())
+		return nil
+	})
+	return buf.String(), err
+}
+
+// walk calls f with every regular file, the hidden ones excluded.
+func (a *Assets) walk(f func(name string, fi fs.FileInfo) error) error {
+	return fs.WalkDir(a.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return f(name, fi)
+	})
+}
+
+// variantOf returns the file name is a precompressed variant of, or "".
+func variantOf(files map[string]fs.FileInfo, name string) string {
+	for _, enc := range encodings {
+		if original := strings.TrimSuffix(name, enc.ext); original != name {
+			if _, ok := files[original]; ok {
+				return original
+			}
+		}
+	}
+	return ""
+}
+
+// hashedName inserts hash before the extension of name.
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// acceptedEncodings returns the content codings of the Accept-Encoding header
+// that aren't refused with q=0.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		coding, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			coding = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && q > 0 {
+			accepted[coding] = true
+		}
+	}
+	return accepted
+}
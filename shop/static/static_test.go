@@ -0,0 +1,118 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type StaticSuite struct {
+	fsys   fstest.MapFS
+	assets *Assets
+}
+
+var _ = Suite(new(StaticSuite))
+
+func (suite *StaticSuite) SetUpTest(c *C) {
+	modTime := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	suite.fsys = fstest.MapFS{
+		"css/application.css":    {Data: []byte("body{}"), ModTime: modTime},
+		"css/application.css.br": {Data: []byte("br"), ModTime: modTime},
+		"css/application.css.gz": {Data: []byte("gz"), ModTime: modTime},
+		"images/logo.png":        {Data: []byte("png"), ModTime: modTime},
+		"archive.gz":             {Data: []byte("archive"), ModTime: modTime},
+		".hidden/secret":         {Data: []byte("secret"), ModTime: modTime},
+	}
+
+	var err error
+	suite.assets, err = New(suite.fsys, "/shop/static", false)
+	c.Assert(err, IsNil)
+}
+
+func (suite *StaticSuite) get(path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	suite.assets.ServeHTTP(w, r)
+	return w
+}
+
+// TestURL validates the hashed names of the assets.
+func (suite *StaticSuite) TestURL(c *C) {
+	c.Assert(suite.assets.URL("css/application.css"), Matches, `/shop/static/css/application\.[0-9a-f]{16}\.css`)
+	c.Assert(suite.assets.URL("/images/logo.png"), Matches, `/shop/static/images/logo\.[0-9a-f]{16}\.png`)
+	c.Assert(suite.assets.URL("archive.gz"), Matches, `/shop/static/archive\.[0-9a-f]{16}\.gz`)
+	c.Assert(suite.assets.URL("missing.js"), Equals, "/shop/static/missing.js")
+}
+
+// TestServeHashed validates that the hashed names are cached forever and the
+// plain ones revalidated.
+func (suite *StaticSuite) TestServeHashed(c *C) {
+	w := suite.get(suite.assets.URL("images/logo.png"))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, "png")
+	c.Assert(w.Header().Get("Content-Type"), Equals, "image/png")
+	c.Assert(w.Header().Get("Cache-Control"), Equals, immutableCacheControl)
+	c.Assert(w.Header().Get("Vary"), Equals, "")
+
+	w = suite.get("/shop/static/images/logo.png")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Cache-Control"), Equals, revalidateCacheControl)
+
+	etag := w.Header().Get("ETag")
+	w = suite.get("/shop/static/images/logo.png", "If-None-Match", etag)
+	c.Assert(w.Code, Equals, http.StatusNotModified)
+
+	c.Assert(suite.get("/shop/static/images/logo.0123456789abcdef.png").Code, Equals, http.StatusNotFound)
+	c.Assert(suite.get("/shop/static/.hidden/secret").Code, Equals, http.StatusNotFound)
+	c.Assert(suite.get("/shop/static/css/application.css.br").Code, Equals, http.StatusNotFound)
+}
+
+// TestServePrecompressed validates the selection of the precompressed
+// variants.
+func (suite *StaticSuite) TestServePrecompressed(c *C) {
+	url := suite.assets.URL("css/application.css")
+	for _, tc := range []struct {
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{"", "", "body{}"},
+		{"gzip, deflate", "gzip", "gz"},
+		{"gzip, deflate, br", "br", "br"},
+		{"br;q=0, gzip", "gzip", "gz"},
+		{"identity", "", "body{}"},
+	} {
+		w := suite.get(url, "Accept-Encoding", tc.acceptEncoding)
+		comment := Commentf("Accept-Encoding: %s", tc.acceptEncoding)
+		c.Assert(w.Code, Equals, http.StatusOK, comment)
+		c.Assert(w.Header().Get("Content-Encoding"), Equals, tc.encoding, comment)
+		c.Assert(w.Header().Get("Content-Type"), Equals, "text/css; charset=utf-8", comment)
+		c.Assert(w.Header().Get("Vary"), Equals, "Accept-Encoding", comment)
+		c.Assert(w.Body.String(), Equals, tc.body, comment)
+	}
+}
+
+// TestReload validates that the assets are hashed again when they change.
+func (suite *StaticSuite) TestReload(c *C) {
+	assets, err := New(suite.fsys, "/static/", true)
+	c.Assert(err, IsNil)
+	before := assets.URL("images/logo.png")
+
+	suite.fsys["images/logo.png"] = &fstest.MapFile{Data: []byte("new png"), ModTime: time.Now()}
+	after := assets.URL("images/logo.png")
+	c.Assert(after, Not(Equals), before)
+
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, httptest.NewRequest("GET", after, nil))
+	c.Assert(w.Body.String(), Equals, "new png")
+}
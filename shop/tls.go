@@ -0,0 +1,220 @@
+package shop
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/syaiful6/thatique/configuration"
+)
+
+// tlsWatchInterval is how often the certificate files are checked for
+// changes.
+const tlsWatchInterval = 30 * time.Second
+
+// tlsVersions maps the accepted values of http.tls.minimumtls.
+var tlsVersions = map[string]uint16{
+	"tls1.0": tls.VersionTLS10,
+	"tls1.1": tls.VersionTLS11,
+	"tls1.2": tls.VersionTLS12,
+	"tls1.3": tls.VersionTLS13,
+}
+
+// defaultMinimumTLS is used when http.tls.minimumtls is not set.
+const defaultMinimumTLS = "tls1.2"
+
+// tlsReloader holds the TLS configuration built from the certificate files
+// and rebuilds it when they change. Handshakes pick the current configuration,
+// so established connections are never dropped by a reload.
+type tlsReloader struct {
+	conf configuration.TLS
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+}
+
+// newTLSReloader loads the certificate files of conf.
+func newTLSReloader(conf configuration.TLS) (*tlsReloader, error) {
+	r := &tlsReloader{conf: conf}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the configuration to hand to the http server.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: r.getConfigForClient,
+		// http.Server.ServeTLS requires a certificate source, the
+		// configuration returned by getConfigForClient is used instead.
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.current().Certificates[0], nil
+		},
+	}
+}
+
+func (r *tlsReloader) current() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.current(), nil
+}
+
+// reload rebuilds the configuration from the files. The previous
+// configuration is kept if the files can't be loaded.
+func (r *tlsReloader) reload() error {
+	modTimes := r.statFiles()
+
+	config, err := buildTLSConfig(r.conf)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any of the files was modified since the last
+// reload.
+func (r *tlsReloader) changed() bool {
+	modTimes := r.statFiles()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, t := range modTimes {
+		if !t.Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *tlsReloader) statFiles() map[string]time.Time {
+	files := append([]string{r.conf.Certificate, r.conf.Key}, r.conf.ClientCAs...)
+	modTimes := make(map[string]time.Time, len(files))
+	for _, name := range files {
+		if fi, err := os.Stat(name); err == nil {
+			modTimes[name] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// watch reloads the configuration on SIGHUP or when the files change, until
+// stop is closed.
+func (r *tlsReloader) watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(tlsWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		}
+
+		if err := r.reload(); err != nil {
+			log.Errorf("error reloading tls certificate, keeping the previous one: %v", err)
+			continue
+		}
+		log.Infof("reloaded tls certificate %s", r.conf.Certificate)
+	}
+}
+
+// buildTLSConfig loads the certificate, key and client CAs of conf.
+func buildTLSConfig(conf configuration.TLS) (*tls.Config, error) {
+	minimumTLS := conf.MinimumTLS
+	if minimumTLS == "" {
+		minimumTLS = defaultMinimumTLS
+	}
+	minVersion, ok := tlsVersions[minimumTLS]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimum TLS level %q specified for http.tls.minimumtls", minimumTLS)
+	}
+
+	cipherSuites, err := getCipherSuites(conf.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.Certificate, conf.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if len(conf.ClientCAs) != 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range conf.ClientCAs {
+			caPem, err := ioutil.ReadFile(ca)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok := pool.AppendCertsFromPEM(caPem); !ok {
+				return nil, fmt.Errorf("could not add CA to pool: %s", ca)
+			}
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+
+	return config, nil
+}
+
+// getCipherSuites maps the cipher suite names to their ids. An empty list
+// selects the defaults of crypto/tls.
+func getCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q specified for http.tls.ciphersuites", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
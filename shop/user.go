@@ -0,0 +1,336 @@
+package shop
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/syaiful6/thatique/configuration"
+	"github.com/syaiful6/thatique/shop/data"
+	"github.com/syaiful6/thatique/shop/data/user"
+)
+
+// userCommandTimeout bounds the database operations of the user commands.
+const userCommandTimeout = 30 * time.Second
+
+var (
+	userConfigPath string
+	userName       string
+	userStaff      bool
+	userSuperuser  bool
+	userListLimit  int
+	userListSearch string
+)
+
+func init() {
+	userCommand.PersistentFlags().StringVarP(&userConfigPath, "config", "c", "",
+		"configuration file, defaults to $THATIQ_CONFIGURATION_PATH")
+
+	userCreate.Flags().StringVar(&userName, "name", "", "profile name of the user")
+	userCreate.Flags().BoolVar(&userStaff, "staff", false, "create a staff user")
+	userCreate.Flags().BoolVar(&userSuperuser, "superuser", false, "create a superuser")
+	userList.Flags().IntVar(&userListLimit, "limit", 100, "maximum number of users to list")
+	userList.Flags().StringVar(&userListSearch, "search", "", "only list the users whose email or name contain this")
+	userPromote.Flags().BoolVar(&userStaff, "staff", false, "grant staff status")
+	userPromote.Flags().BoolVar(&userSuperuser, "superuser", false, "grant superuser status")
+	userDemote.Flags().BoolVar(&userStaff, "staff", false, "revoke staff status")
+	userDemote.Flags().BoolVar(&userSuperuser, "superuser", false, "revoke superuser status")
+
+	userCommand.AddCommand(userCreate, userList, userSetPassword, userPromote,
+		userDemote, userDisable, userEnable, userDelete)
+}
+
+var userCommand = &cobra.Command{
+	Use:   "user",
+	Short: "Thatiq's user management",
+	Long:  "Thatiq's user management",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+var userCreate = &cobra.Command{
+	Use:   "create <email>",
+	Short: "create a user",
+	Long:  "create a user, prompting for the password",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		email := strings.ToLower(strings.TrimSpace(args[0]))
+		password, err := promptNewPassword()
+		if err != nil {
+			return err
+		}
+
+		u, err := user.Create(email, password)
+		if err != nil {
+			return err
+		}
+		u.Profile.Name = userName
+		u.Staff = userStaff || userSuperuser
+		u.Superuser = userSuperuser
+		// the administrator vouches for the address.
+		u.Verified = true
+
+		err = user.NewRepository(conn).Insert(ctx, u)
+		if err == user.ErrEmailExists {
+			return fmt.Errorf("user %s already exists", email)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created user %s (%s)\n", email, u.Id.Hex())
+		return nil
+	}),
+}
+
+var userList = &cobra.Command{
+	Use:   "list",
+	Short: "list the users",
+	Long:  "list the users, most recent first",
+	Args:  cobra.NoArgs,
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		users, total, err := user.NewRepository(conn).List(ctx, user.ListOptions{
+			Search: userListSearch,
+			Limit:  userListLimit,
+		})
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEMAIL\tNAME\tSTAFF\tSUPERUSER\tVERIFIED\tDISABLED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%t\t%t\n", u.Id.Hex(), u.Email,
+				u.Profile.Name, u.Staff, u.Superuser, u.Verified, u.Disabled)
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+
+		if total > len(users) {
+			fmt.Printf("%d of %d users listed, use --limit to list more\n", len(users), total)
+		}
+		return nil
+	}),
+}
+
+var userSetPassword = &cobra.Command{
+	Use:   "set-password <email>",
+	Short: "set the password of a user",
+	Long:  "set the password of a user, prompting for the new password. All sessions of the user are logged out",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		password, err := promptNewPassword()
+		if err != nil {
+			return err
+		}
+
+		return updateUser(ctx, conn, args[0], []string{"password"}, func(u *user.User) error {
+			return u.SetPassword(password)
+		})
+	}),
+}
+
+var userPromote = &cobra.Command{
+	Use:   "promote <email>",
+	Short: "grant staff or superuser status",
+	Long:  "grant staff or superuser status to a user, superusers are staff too",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		if !userStaff && !userSuperuser {
+			return errors.New("one of --staff or --superuser is required")
+		}
+
+		return updateUser(ctx, conn, args[0], []string{"is_staff", "is_superuser"}, func(u *user.User) error {
+			u.Staff = true
+			u.Superuser = u.Superuser || userSuperuser
+			return nil
+		})
+	}),
+}
+
+var userDemote = &cobra.Command{
+	Use:   "demote <email>",
+	Short: "revoke staff or superuser status",
+	Long:  "revoke staff or superuser status of a user, both are revoked if no flag is given",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		return updateUser(ctx, conn, args[0], []string{"is_staff", "is_superuser"}, func(u *user.User) error {
+			u.Superuser = false
+			if userStaff || !userSuperuser {
+				u.Staff = false
+			}
+			return nil
+		})
+	}),
+}
+
+var userDisable = &cobra.Command{
+	Use:   "disable <email>",
+	Short: "disable a user",
+	Long:  "disable a user, the user is logged out and can't log in anymore",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		return updateUser(ctx, conn, args[0], []string{"is_disabled"}, func(u *user.User) error {
+			u.Disabled = true
+			return nil
+		})
+	}),
+}
+
+var userEnable = &cobra.Command{
+	Use:   "enable <email>",
+	Short: "enable a disabled user",
+	Long:  "enable a disabled user",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		return updateUser(ctx, conn, args[0], []string{"is_disabled"}, func(u *user.User) error {
+			u.Disabled = false
+			return nil
+		})
+	}),
+}
+
+var userDelete = &cobra.Command{
+	Use:   "delete <email>",
+	Short: "delete a user",
+	Long:  "delete a user permanently",
+	Args:  cobra.ExactArgs(1),
+	Run: runUserCommand(func(ctx context.Context, conn *data.MongoConn, args []string) error {
+		users := user.NewRepository(conn)
+		u, err := users.FindByEmail(ctx, args[0])
+		if err == user.ErrNotFound {
+			return fmt.Errorf("user %s not found", args[0])
+		}
+		if err != nil {
+			return err
+		}
+
+		err = users.Delete(ctx, u.Id)
+		if err == user.ErrNotFound {
+			return fmt.Errorf("user %s not found", u.Email)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("deleted user %s\n", u.Email)
+		return nil
+	}),
+}
+
+// runUserCommand resolves the configuration, connects to MongoDB and runs f,
+// exiting on errors.
+func runUserCommand(f func(context.Context, *data.MongoConn, []string) error) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		var configArgs []string
+		if userConfigPath != "" {
+			configArgs = []string{userConfigPath}
+		}
+
+		config, err := resolveConfiguration(configArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		mongoConfig := config.MongoDB[configuration.DefaultConnection]
+		conn, err := data.Dial(mongoConfig.URI, mongoConfig.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error connecting to mongodb: %v\n", err)
+			os.Exit(1)
+		}
+		defer conn.Session.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), userCommandTimeout)
+		defer cancel()
+
+		if err = user.NewRepository(conn).EnsureIndexes(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error creating the users indexes: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err = f(ctx, conn, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.CommandPath(), err)
+			os.Exit(1)
+		}
+	}
+}
+
+// updateUser applies change to the user with the given email, compared
+// case-insensitively, and saves the fields it changes.
+func updateUser(ctx context.Context, conn *data.MongoConn, email string, fields []string, change func(*user.User) error) error {
+	users := user.NewRepository(conn)
+	u, err := users.FindByEmail(ctx, email)
+	if err == user.ErrNotFound {
+		return fmt.Errorf("user %s not found", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = change(u); err != nil {
+		return err
+	}
+	err = users.Update(ctx, u, fields...)
+	if err == user.ErrNotFound {
+		return fmt.Errorf("user %s not found", u.Email)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("updated user %s\n", u.Email)
+	return nil
+}
+
+// promptNewPassword asks for a password twice. If stdin is not a terminal,
+// the password is read from the first line of stdin instead. Like the API, it
+// rejects the passwords shorter than user.MinPasswordLength.
+func promptNewPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading password: %v", err)
+		}
+		return checkPassword(strings.TrimRight(line, "\r\n"))
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Password (again): ")
+	again, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if string(password) != string(again) {
+		return "", errors.New("passwords don't match")
+	}
+
+	return checkPassword(string(password))
+}
+
+func checkPassword(password string) (string, error) {
+	if len(password) < user.MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", user.MinPasswordLength)
+	}
+	return password, nil
+}